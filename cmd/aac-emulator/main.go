@@ -1,55 +1,576 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	emulator "urbanwizardry.com/aac-emulator/internal"
 )
 
+// archiveFormat is the export format of a full archive of the store
+const archiveFormat = "archive"
+
+// shutdownTimeout bounds how long serve waits, once signalled, for the
+// requests in flight to finish
+const shutdownTimeout = 10 * time.Second
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+const usage = `Usage: aac-emulator [command] [flags]
+
+Commands:
+  serve    run the App Configuration emulator (default)
+  import   import settings from JSON, YAML, .properties, .env or kvset files
+  export   export the latest version of every setting
+  reset    delete everything in the store
+  restore  replace the store with an archive written by 'export --format archive'
+  replay   re-issue requests recorded with 'serve --record' and compare the responses
+  conformance
+           replay recordings of the real service and score the emulator's compatibility
+  version  print the version
+
+Run 'aac-emulator <command> -h' for the flags of a command.
+`
+
 func main() {
-	dbpath := "/home/alex/tmp/localConfigStore" //os.Args[1]
+	args := os.Args[1:]
+
+	command := "serve"
+	if len(args) > 0 && (len(args[0]) == 0 || args[0][0] != '-') {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serveCommand(args)
+	case "import":
+		err = importCommand(args)
+	case "export":
+		err = exportCommand(args)
+	case "reset":
+		err = resetCommand(args)
+	case "restore":
+		err = restoreCommand(args)
+	case "replay":
+		err = replayCommand(args)
+	case "conformance":
+		err = conformanceCommand(args)
+	case "version":
+		fmt.Println(version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "aac-emulator %s: %s\n", command, err)
+		os.Exit(1)
+	}
+}
+
+func serveCommand(args []string) error {
+	var opts serveOptions
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	opts.register(fs)
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+
+	serverOpts, err := opts.restServerOptions()
+	if err != nil {
+		return err
+	}
+
+	// Everything started below stops on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	store, closer, err := emulator.NewPersistentConfigStore(
-		emulator.MakeCloverFactory(dbpath),
-	)
+	store, closer, err := emulator.NewPersistentConfigStore(opts.cloverFactory())
 	if err != nil {
-		panic(err)
+		return errors.Wrap(err, "failed to open store")
 	}
 	defer closer()
 
-	// TEMP
-	store.UpdateSetting("blahblah", "blahblah")
+	seedOpts, err := opts.seedOptions.importOptions()
+	if err != nil {
+		return err
+	}
+
+	for _, seed := range opts.seeds {
+		count, err := emulator.ImportSettingsFile(store, seed, seedOpts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to seed from %s", seed)
+		}
+		slog.Info("seeded settings", "file", seed, "count", count)
+	}
+
+	if len(opts.webhooks) > 0 {
+		dispatcher, err := emulator.NewWebhookDispatcher(store, opts.webhookSubscriptions(),
+			emulator.WebhookOptions{EventOptions: opts.eventOptions()})
+		if err != nil {
+			return err
+		}
+		go dispatcher.Run(ctx)
+	}
+
+	if len(opts.queues) > 0 {
+		broker := emulator.NewQueueBroker(emulator.QueueOptions{})
+		err = broker.SubscribeChanges(store, opts.queueSubscriptions(), opts.eventOptions())
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, emulator.WithQueues(broker))
+	}
+
+	if opts.otlpEndpoint != "" {
+		tracer, err := emulator.NewTracer(emulator.TracingOptions{
+			Endpoint:    opts.otlpEndpoint,
+			ServiceName: opts.otlpServiceName,
+		})
+		if err != nil {
+			return err
+		}
+		// The remaining spans are exported once the server has shut down,
+		// before the store is closed
+		flushed := make(chan struct{})
+		go func() {
+			tracer.Run(ctx)
+			close(flushed)
+		}()
+		defer func() {
+			stop()
+			<-flushed
+		}()
+		serverOpts = append(serverOpts, emulator.WithTracing(tracer))
+	}
+
+	if opts.record != "" {
+		f, err := os.OpenFile(opts.record, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", opts.record)
+		}
+		defer f.Close()
+		serverOpts = append(serverOpts, emulator.WithRecorder(emulator.NewTrafficRecorder(f)))
+	}
 
-	value, err := store.GetSetting("wibble")
+	proxyOpts, proxying, err := opts.proxyOptions()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if proxying {
+		proxy, err := emulator.NewUpstreamProxy(store, proxyOpts)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, emulator.WithProxy(proxy))
+		slog.Info("proxying to upstream store", "endpoint", proxyOpts.Endpoint)
 	}
 
-	fmt.Printf("wibble is %s", value)
+	quotaOpts, enforcing := opts.quotaOptions()
+	if enforcing {
+		quotas, err := emulator.NewQuotaEnforcer(store, quotaOpts)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, emulator.WithQuotas(quotas))
+		slog.Info("enforcing tier quotas", "tier", quotaOpts.Tier)
+	}
 
-	restServer := emulator.SetupRestServer(store)
+	if opts.watchDir != "" {
+		sync := emulator.NewDirectorySync(store, opts.watchDir, opts.watchSep)
+		result, err := sync.Reconcile()
+		if err != nil {
+			return errors.Wrapf(err, "failed to sync %s", opts.watchDir)
+		}
+		slog.Info("synced directory", "dir", opts.watchDir,
+			"created", result.Created, "updated", result.Updated, "deleted", result.Deleted)
 
-	err = runHttpServer(restServer)
-	panic(err)
+		go func() {
+			err := sync.Watch(ctx)
+			if err != nil {
+				slog.Error("stopped watching directory", "dir", opts.watchDir, "error", err)
+			}
+		}()
+	}
 
+	restServer := emulator.SetupRestServer(store, serverOpts...)
+
+	slog.Info("serving App Configuration emulator",
+		"version", version, "listen", opts.listen, "storage", opts.storage,
+		"auth", opts.auth, "readOnly", opts.readOnly)
+
+	return runHttpServer(ctx, opts.listen, restServer)
 }
 
-// func registerHandlers(api *operations.AzureAppConfigurationAPI) {
-// 	api.KeysGetKeysHandler = emulator.KeysGetKeysHandler{}
-// 	api.KeyValuesGetKeyValuesStarHandler = emulator.KeyValuesGetKeyValuesStarHandler{}
-// 	api.KeyValuesGetKeyValueHandler = emulator.KeyValuesGetKeyValueHandler{}
-// 	api.KeyValuesGetKeyValuesHandler = emulator.KeyValuesGetKeyValuesHandler{}
-// }
+func importCommand(args []string) error {
+	var opts storeOptions
+	var imports importOptions
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	opts.register(fs)
+	imports.register(fs, "")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: aac-emulator import [flags] file...")
+		fs.PrintDefaults()
+	}
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
 
-func runHttpServer(restServer *gin.Engine) error {
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("at least one file to import is required")
+	}
+
+	err = imports.validate()
+	if err != nil {
+		return err
+	}
+
+	importOpts, err := imports.importOptions()
+	if err != nil {
+		return err
+	}
+
+	err = checkOffline(opts)
+	if err != nil {
+		return err
+	}
+
+	store, closer, err := emulator.NewPersistentConfigStore(opts.cloverFactory())
+	if err != nil {
+		return errors.Wrap(err, "failed to open store")
+	}
+	defer closer()
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
-		restServer.ServeHTTP(w, rq)
-	})
+	for _, path := range fs.Args() {
+		count, err := emulator.ImportSettingsFile(store, path, importOpts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "imported %d settings from %s\n", count, path)
+	}
+
+	return nil
+}
+
+func exportCommand(args []string) error {
+	var opts storeOptions
+	var output string
+	var exportOpts emulator.ExportOptions
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&output, "output", "", "file to write to (default stdout)")
+	fs.StringVar(&exportOpts.Format, "format", emulator.SeedFormatRecords,
+		"file format: records, kvset, the Azure CLI default profile formats json, yaml and properties, "+
+			"or archive for the whole store with full history")
+	fs.StringVar(&exportOpts.Label, "label", "",
+		"label to export, for the default profile formats (default the null label)")
+	fs.StringVar(&exportOpts.Prefix, "prefix", "",
+		"export only keys with this prefix, removing it, for the default profile formats")
+	fs.StringVar(&exportOpts.Separator, "separator", "",
+		"nest keys on this separator, for the default profile json and yaml formats")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+
+	if exportOpts.Format != archiveFormat &&
+		(exportOpts.Format == emulator.SeedFormatDotEnv || !slices.Contains(emulator.SeedFormats, exportOpts.Format)) {
+		return fmt.Errorf("cannot export to format '%s'", exportOpts.Format)
+	}
+
+	err = checkOffline(opts)
+	if err != nil {
+		return err
+	}
+
+	store, closer, err := emulator.NewPersistentConfigStore(opts.cloverFactory())
+	if err != nil {
+		return errors.Wrap(err, "failed to open store")
+	}
+	defer closer()
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s", output)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if exportOpts.Format == archiveFormat {
+		return emulator.ExportArchive(store, w)
+	}
+
+	count, err := emulator.ExportSettings(store, w, exportOpts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d settings\n", count)
+
+	return nil
+}
 
-	err := http.ListenAndServe(":9876", handler)
+func restoreCommand(args []string) error {
+	var opts storeOptions
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: aac-emulator restore [flags] archive")
+		fs.PrintDefaults()
+	}
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one archive to restore is required")
+	}
+
+	err = checkOffline(opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", fs.Arg(0))
+	}
+	defer f.Close()
+
+	store, closer, err := emulator.NewPersistentConfigStore(opts.cloverFactory())
+	if err != nil {
+		return errors.Wrap(err, "failed to open store")
+	}
+	defer closer()
+
+	archive, err := emulator.RestoreArchive(store, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d settings, %d snapshots and %d secrets\n",
+		len(archive.Settings), len(archive.Snapshots), len(archive.Secrets))
+
+	return nil
+}
+
+func replayCommand(args []string) error {
+	var replayOpts emulator.ReplayOptions
+	var ignoredFields string
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&replayOpts.Target, "target", "http://localhost:9876", "base URL of the emulator to replay against")
+	fs.StringVar(&ignoredFields, "ignore-fields", "", "comma separated JSON response body fields not to compare")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: aac-emulator replay [flags] recording.jsonl")
+		fs.PrintDefaults()
+	}
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one recording to replay is required")
+	}
+
+	var fields listFlag
+	fields.Set(ignoredFields)
+	replayOpts.IgnoredFields = fields
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", fs.Arg(0))
+	}
+	defer f.Close()
+
+	exchanges, err := emulator.ReadRecording(f)
+	if err != nil {
+		return err
+	}
+
+	results, err := emulator.Replay(context.Background(), exchanges, replayOpts)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, result := range results {
+		if len(result.Differences) == 0 {
+			continue
+		}
+		failed++
+		fmt.Printf("%d: %s %s\n", i+1, result.Exchange.Request.Method, result.Exchange.Request.Path)
+		for _, difference := range result.Differences {
+			fmt.Printf("    %s\n", difference)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d requests, %d differed\n", len(results), failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d responses differed from the recording", failed)
+	}
+	return nil
+}
+
+func conformanceCommand(args []string) error {
+	var conformanceOpts emulator.ConformanceOptions
+	var ignoredFields string
+	var minScore float64
+	var verbose bool
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	fs.StringVar(&conformanceOpts.Target, "target", "http://localhost:9876",
+		"base URL of the emulator to replay against; its store is emptied before each fixture")
+	fs.StringVar(&ignoredFields, "ignore-fields", "", "comma separated JSON response body fields not to compare")
+	fs.Float64Var(&minScore, "min-score", 0, "fail if the overall score, in percent, is lower")
+	fs.BoolVar(&verbose, "v", false, "print the differences of every response that didn't match")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: aac-emulator conformance [flags] fixtures-dir")
+		fmt.Fprintln(fs.Output(), "The fixtures are .jsonl recordings of requests to the real service, each starting from an empty store.")
+		fs.PrintDefaults()
+	}
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one directory of fixtures is required")
+	}
+
+	var fields listFlag
+	fields.Set(ignoredFields)
+	conformanceOpts.IgnoredFields = fields
+
+	fixtures, err := emulator.ReadConformanceFixtures(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	report, err := emulator.RunConformance(context.Background(), fixtures, conformanceOpts)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		for _, result := range report.Results {
+			if len(result.Differences) == 0 {
+				continue
+			}
+			fmt.Printf("%s: %s %s (%s)\n", result.Fixture, result.Exchange.Request.Method, result.Exchange.Request.Path, result.Operation)
+			for _, difference := range result.Differences {
+				fmt.Printf("    %s\n", difference)
+			}
+		}
+	}
+
+	for _, score := range append(report.Operations, report.Overall) {
+		fmt.Printf("%-24s %5d/%-5d %6.1f%%\n", score.Operation, score.Matched, score.Total, score.Percent())
+	}
+
+	if report.Overall.Percent() < minScore {
+		return fmt.Errorf("the overall score %.1f%% is lower than %.1f%%", report.Overall.Percent(), minScore)
+	}
+	return nil
+}
+
+func resetCommand(args []string) error {
+	var opts storeOptions
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	opts.register(fs)
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+
+	err = checkOffline(opts)
+	if err != nil {
+		return err
+	}
+
+	store, closer, err := emulator.NewPersistentConfigStore(opts.cloverFactory())
+	if err != nil {
+		return errors.Wrap(err, "failed to open store")
+	}
+	defer closer()
+
+	return store.Reset()
+}
+
+// checkOffline rejects storage backends that the offline subcommands
+// cannot operate on.
+func checkOffline(opts storeOptions) error {
+	if opts.storage == storageMemory {
+		return fmt.Errorf("--storage=%s has nothing to operate on outside of serve", storageMemory)
+	}
+	return nil
+}
+
+// runHttpServer serves @param restServer on @param listen until @param ctx
+// is done, then shuts the server down. The contexts of the requests in
+// flight are derived from @param ctx, so that streams end too.
+func runHttpServer(ctx context.Context, listen string, restServer *gin.Engine) error {
+	server := &http.Server{
+		Addr:        listen,
+		Handler:     restServer,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
 
-	return err
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+	emulator "urbanwizardry.com/aac-emulator/internal"
+)
+
+// Every flag can also be set by an environment variable, named
+// AAC_EMU_ followed by the flag name in upper case with '-' replaced by '_'.
+// Flags take precedence over the environment: a repeatable flag given on the
+// command line replaces the values of its variable. Repeatable flags, and
+// their variables, also accept comma separated values; a comma that is part
+// of a value is escaped as '\,'.
+const envPrefix = "AAC_EMU_"
+
+const (
+	storageClover = "clover"
+	storageMemory = "memory"
+)
+
+// storeOptions are common to every subcommand that opens the store
+type storeOptions struct {
+	dataDir  string
+	storage  string
+	logLevel string
+}
+
+func (so *storeOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&so.dataDir, "data-dir", "aac-emulator-data",
+		"directory the store is persisted in")
+	fs.StringVar(&so.storage, "storage", storageClover,
+		"storage backend: clover (persistent, in --data-dir) or memory")
+	fs.StringVar(&so.logLevel, "log-level", "info",
+		"log level: debug, info, warn or error")
+}
+
+func (so *storeOptions) validate() error {
+	if !slices.Contains([]string{storageClover, storageMemory}, so.storage) {
+		return fmt.Errorf("unknown storage backend '%s'", so.storage)
+	}
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(so.logLevel))
+	if err != nil {
+		return fmt.Errorf("unknown log level '%s'", so.logLevel)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if level <= slog.LevelDebug {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	return nil
+}
+
+func (so *storeOptions) cloverFactory() func() (*clover.DB, func(), error) {
+	if so.storage == storageMemory {
+		return emulator.MakeInMemoryCloverFactory()
+	}
+	return emulator.MakeCloverFactory(so.dataDir)
+}
+
+// importOptions describe how seed files are read, for the import
+// subcommand and for --seed files on serve
+type importOptions struct {
+	format      string
+	separator   string
+	prefix      string
+	label       string
+	contentType string
+	tags        listFlag
+}
+
+// register adds the import flags to @param fs, with names starting
+// @param namePrefix
+func (imp *importOptions) register(fs *flag.FlagSet, namePrefix string) {
+	fs.StringVar(&imp.format, namePrefix+"format", "",
+		"file format: "+strings.Join(emulator.SeedFormats, ", ")+" (default from the file extension)")
+	fs.StringVar(&imp.separator, namePrefix+"separator", emulator.DefaultSeedSeparator,
+		"separator joining the keys of nested JSON and YAML objects")
+	fs.StringVar(&imp.prefix, namePrefix+"prefix", "",
+		"prefix added to every imported key")
+	fs.StringVar(&imp.label, namePrefix+"label", "",
+		"label applied to imported settings")
+	fs.StringVar(&imp.contentType, namePrefix+"content-type", "",
+		"content type applied to imported settings")
+	fs.Var(&imp.tags, namePrefix+"tag",
+		"tag applied to imported settings, as name=value (repeatable)")
+}
+
+func (imp *importOptions) validate() error {
+	if imp.format != "" && !slices.Contains(emulator.SeedFormats, imp.format) {
+		return fmt.Errorf("unknown format '%s'", imp.format)
+	}
+
+	_, err := imp.importOptions()
+	return err
+}
+
+func (imp *importOptions) importOptions() (emulator.ImportOptions, error) {
+	tags := map[string]string{}
+	for _, tag := range imp.tags {
+		name, value, found := strings.Cut(tag, "=")
+		if !found || name == "" {
+			return emulator.ImportOptions{}, fmt.Errorf("invalid tag '%s', expected name=value", tag)
+		}
+		tags[name] = value
+	}
+
+	return emulator.ImportOptions{
+		Format:      imp.format,
+		Separator:   imp.separator,
+		Prefix:      imp.prefix,
+		Label:       imp.label,
+		ContentType: imp.contentType,
+		Tags:        tags,
+	}, nil
+}
+
+// serveOptions are the options of the serve subcommand
+type serveOptions struct {
+	storeOptions
+	seedOptions importOptions
+
+	listen      string
+	auth        string
+	credentials listFlag
+	seeds       listFlag
+	watchDir    string
+	watchSep    string
+	readOnly    bool
+	apiVersions listFlag
+	keyVault    bool
+
+	webhooks      listFlag
+	webhookSchema string
+	webhookPrefix string
+	queues        listFlag
+	queueSchema   string
+	queuePrefix   string
+	eventOrigin   string
+	// webhookOrigin is the deprecated name of eventOrigin
+	webhookOrigin string
+
+	otlpEndpoint    string
+	otlpServiceName string
+
+	record string
+
+	proxy                 string
+	proxyConnectionString string
+
+	faults string
+
+	tier           string
+	quotaPerHour   int
+	quotaPerDay    int
+	quotaBurst     int
+	quotaStorageMB int
+}
+
+func (so *serveOptions) register(fs *flag.FlagSet) {
+	so.storeOptions.register(fs)
+
+	fs.StringVar(&so.listen, "listen", ":9876",
+		"address to listen on")
+	fs.StringVar(&so.auth, "auth", emulator.AuthModeNone,
+		"authentication mode: "+strings.Join(emulator.AuthModes, " or "))
+	fs.Var(&so.credentials, "credential",
+		"access key accepted in hmac mode, as id:base64secret (repeatable)")
+	fs.Var(&so.seeds, "seed",
+		"file of settings to import on startup (repeatable)")
+	so.seedOptions.register(fs, "seed-")
+	fs.StringVar(&so.watchDir, "watch-dir", "",
+		"directory of YAML files to keep the store in sync with; subdirectories name labels")
+	fs.StringVar(&so.watchSep, "watch-separator", emulator.DefaultSeedSeparator,
+		"separator joining the keys of nested objects in --watch-dir files")
+	fs.BoolVar(&so.readOnly, "read-only", false,
+		"reject all operations that modify the store")
+	fs.Var(&so.apiVersions, "api-versions",
+		"comma separated api-version values to accept (default "+strings.Join(emulator.DefaultApiVersions, ",")+")")
+	fs.BoolVar(&so.keyVault, "keyvault", false,
+		"also serve a minimal Key Vault secrets API")
+	fs.Var(&so.webhooks, "webhook",
+		"URL to POST change events to, as Event Grid would (repeatable)")
+	fs.StringVar(&so.webhookSchema, "webhook-schema", emulator.EventSchemaEventGrid,
+		"schema of webhook events: "+strings.Join(emulator.EventSchemas, " or "))
+	fs.StringVar(&so.webhookPrefix, "webhook-key-prefix", "",
+		"only send webhook events for keys starting with this prefix")
+	fs.Var(&so.queues, "event-queue",
+		"name of a queue, served under "+emulator.QueuesBasePath+", to send change events to (repeatable)")
+	fs.StringVar(&so.queueSchema, "event-queue-schema", emulator.EventSchemaEventGrid,
+		"schema of queued events: "+strings.Join(emulator.EventSchemas, " or "))
+	fs.StringVar(&so.queuePrefix, "event-queue-key-prefix", "",
+		"only queue events for keys starting with this prefix")
+	fs.StringVar(&so.eventOrigin, "event-origin", "",
+		"base URL of the emulator in change events (default http://localhost<listen>)")
+	fs.StringVar(&so.webhookOrigin, "webhook-origin", "",
+		"deprecated, use --event-origin")
+	fs.StringVar(&so.otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OTLP/HTTP collector URL to export traces to, e.g. http://localhost:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.StringVar(&so.otlpServiceName, "otlp-service-name", os.Getenv("OTEL_SERVICE_NAME"),
+		"service.name of exported traces (default $OTEL_SERVICE_NAME, or "+emulator.DefaultTracingServiceName+")")
+	fs.StringVar(&so.record, "record", "",
+		"JSONL file to append every request and its response to, for 'aac-emulator replay'")
+	fs.StringVar(&so.proxy, "proxy", "",
+		"upstream App Configuration endpoint to forward requests to, capturing its responses into the store")
+	fs.StringVar(&so.proxyConnectionString, "proxy-connection-string", "",
+		"connection string of the upstream store to forward requests to, re-signing them with its credential")
+	fs.StringVar(&so.faults, "faults", "",
+		"JSON file of fault rules to inject from startup; rules can also be managed under "+emulator.AdminBasePath+"/faults")
+	fs.StringVar(&so.tier, "tier", "",
+		"enforce the request and storage limits of a pricing tier: "+strings.Join(emulator.Tiers, ", ")+" (default none)")
+	fs.IntVar(&so.quotaPerHour, "quota-requests-per-hour", 0,
+		"override the --tier limit of requests per hour")
+	fs.IntVar(&so.quotaPerDay, "quota-requests-per-day", 0,
+		"override the --tier limit of requests per day")
+	fs.IntVar(&so.quotaBurst, "quota-burst", 0,
+		"override the --tier limit of requests per second")
+	fs.IntVar(&so.quotaStorageMB, "quota-storage-mb", 0,
+		"override the --tier limit of storage, in megabytes")
+}
+
+func (so *serveOptions) validate() error {
+	err := so.storeOptions.validate()
+	if err != nil {
+		return err
+	}
+
+	err = so.seedOptions.validate()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(emulator.AuthModes, so.auth) {
+		return fmt.Errorf("unknown auth mode '%s'", so.auth)
+	}
+	if so.auth == emulator.AuthModeHmac && len(so.credentials) == 0 {
+		return fmt.Errorf("--auth=%s requires at least one --credential", emulator.AuthModeHmac)
+	}
+	if so.proxy != "" && so.proxyConnectionString != "" {
+		return fmt.Errorf("only one of --proxy and --proxy-connection-string may be given")
+	}
+	if so.readOnly && (so.proxy != "" || so.proxyConnectionString != "") {
+		return fmt.Errorf("--read-only can't be combined with --proxy or --proxy-connection-string, which write to the upstream store")
+	}
+	if so.tier != "" && !slices.Contains(emulator.Tiers, so.tier) {
+		return fmt.Errorf("unknown tier '%s'", so.tier)
+	}
+	if so.tier == "" && (so.quotaPerHour != 0 || so.quotaPerDay != 0 || so.quotaBurst != 0 || so.quotaStorageMB != 0) {
+		return fmt.Errorf("the --quota- flags require --tier")
+	}
+	if !slices.Contains(emulator.EventSchemas, so.webhookSchema) {
+		return fmt.Errorf("unknown webhook schema '%s'", so.webhookSchema)
+	}
+	if !slices.Contains(emulator.EventSchemas, so.queueSchema) {
+		return fmt.Errorf("unknown event queue schema '%s'", so.queueSchema)
+	}
+	if so.webhookOrigin != "" {
+		if so.eventOrigin != "" && so.eventOrigin != so.webhookOrigin {
+			return fmt.Errorf("--webhook-origin is deprecated, and conflicts with --event-origin")
+		}
+		slog.Warn("--webhook-origin is deprecated, use --event-origin", "env", envName("event-origin"))
+	}
+
+	return nil
+}
+
+func (so *serveOptions) restServerOptions() ([]emulator.RestServerOption, error) {
+	opts := []emulator.RestServerOption{}
+
+	if so.auth == emulator.AuthModeHmac {
+		credentials := []emulator.Credential{}
+		for _, credential := range so.credentials {
+			id, secret, found := strings.Cut(credential, ":")
+			if !found || id == "" || secret == "" {
+				return nil, fmt.Errorf("invalid credential '%s', expected id:secret", credential)
+			}
+			credentials = append(credentials, emulator.Credential{Id: id, Secret: secret})
+		}
+		opts = append(opts, emulator.WithHmacAuth(credentials))
+	}
+
+	if so.readOnly {
+		opts = append(opts, emulator.WithReadOnly())
+	}
+	if len(so.apiVersions) > 0 {
+		opts = append(opts, emulator.WithApiVersions(so.apiVersions))
+	}
+	if so.keyVault {
+		opts = append(opts, emulator.WithKeyVault())
+	}
+
+	if so.faults != "" {
+		f, err := os.Open(so.faults)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open %s", so.faults)
+		}
+		defer f.Close()
+
+		rules, err := emulator.ReadFaultRules(f)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, emulator.WithFaultRules(rules))
+	}
+
+	return opts, nil
+}
+
+// proxyOptions returns the options of the upstream proxy, and whether
+// proxy mode is enabled
+func (so *serveOptions) proxyOptions() (emulator.ProxyOptions, bool, error) {
+	if so.proxyConnectionString != "" {
+		endpoint, credential, err := emulator.ParseConnectionString(so.proxyConnectionString)
+		if err != nil {
+			return emulator.ProxyOptions{}, false, err
+		}
+		return emulator.ProxyOptions{Endpoint: endpoint, Credential: &credential}, true, nil
+	}
+
+	return emulator.ProxyOptions{Endpoint: so.proxy}, so.proxy != "", nil
+}
+
+// quotaOptions returns the options of the quota enforcer, and whether
+// quotas are enforced
+func (so *serveOptions) quotaOptions() (emulator.QuotaOptions, bool) {
+	return emulator.QuotaOptions{
+		Tier: so.tier,
+		Limits: emulator.QuotaLimits{
+			RequestsPerHour: so.quotaPerHour,
+			RequestsPerDay:  so.quotaPerDay,
+			BurstPerSecond:  so.quotaBurst,
+			StorageBytes:    int64(so.quotaStorageMB) * 1024 * 1024,
+		},
+	}, so.tier != ""
+}
+
+func (so *serveOptions) webhookSubscriptions() []emulator.WebhookSubscription {
+	subscriptions := []emulator.WebhookSubscription{}
+	for _, endpoint := range so.webhooks {
+		subscriptions = append(subscriptions, emulator.WebhookSubscription{
+			Endpoint:  endpoint,
+			Schema:    so.webhookSchema,
+			KeyPrefix: so.webhookPrefix,
+		})
+	}
+	return subscriptions
+}
+
+func (so *serveOptions) queueSubscriptions() []emulator.QueueSubscription {
+	subscriptions := []emulator.QueueSubscription{}
+	for _, queue := range so.queues {
+		subscriptions = append(subscriptions, emulator.QueueSubscription{
+			Queue:     queue,
+			Schema:    so.queueSchema,
+			KeyPrefix: so.queuePrefix,
+		})
+	}
+	return subscriptions
+}
+
+func (so *serveOptions) eventOptions() emulator.EventOptions {
+	origin := so.eventOrigin
+	if origin == "" {
+		origin = so.webhookOrigin
+	}
+	if origin == "" {
+		origin = "http://localhost" + so.listen
+		if !strings.HasPrefix(so.listen, ":") {
+			origin = "http://" + so.listen
+		}
+	}
+
+	return emulator.EventOptions{Origin: origin}
+}
+
+// listFlag is a repeatable flag, that also accepts comma separated values.
+// A comma escaped as '\,' is part of a value.
+type listFlag []string
+
+func (lf *listFlag) String() string {
+	values := []string{}
+	for _, v := range *lf {
+		values = append(values, strings.ReplaceAll(v, ",", `\,`))
+	}
+	return strings.Join(values, ",")
+}
+
+func (lf *listFlag) Set(value string) error {
+	var v strings.Builder
+	add := func() {
+		if trimmed := strings.TrimSpace(v.String()); trimmed != "" {
+			*lf = append(*lf, trimmed)
+		}
+		v.Reset()
+	}
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == ',':
+			v.WriteByte(',')
+			i++
+		case value[i] == ',':
+			add()
+		default:
+			v.WriteByte(value[i])
+		}
+	}
+	add()
+
+	return nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses @param args into @param fs, then sets each flag that
+// was not given from its environment variable, if that is set
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, found := os.LookupEnv(envName(f.Name))
+		if err != nil || given[f.Name] || !found {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s '%s': %v", envName(f.Name), value, setErr)
+		}
+	})
+
+	return err
+}
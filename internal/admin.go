@@ -0,0 +1,213 @@
+package emulator
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+// The admin API is not part of App Configuration. It gives tests and
+// tooling a convenient way to inspect and manipulate the emulator's store.
+
+const (
+	AdminBasePath = "/_admin"
+
+	errTypeNotFound           = "https://azconfig.io/errors/not-found"
+	errTypeKeyLocked          = "https://azconfig.io/errors/key-locked"
+	errTypePreconditionFailed = "https://azconfig.io/errors/precondition-failed"
+)
+
+type adminServer struct {
+	configStore *persistentConfigStore
+}
+
+func registerAdminRoutes(g *gin.RouterGroup, configStore *persistentConfigStore) {
+	as := adminServer{configStore: configStore}
+
+	flags := g.Group("/featureflags")
+	flags.GET("", as.listFeatureFlags)
+	flags.GET("/:id", as.getFeatureFlag)
+	flags.PUT("/:id", as.putFeatureFlag)
+	flags.POST("/:id/enable", as.setFeatureFlagEnabled(true))
+	flags.POST("/:id/disable", as.setFeatureFlagEnabled(false))
+	flags.POST("/:id/evaluate", as.evaluateFeatureFlag)
+
+	g.GET("/archive", as.exportArchive)
+	g.PUT("/archive", as.restoreArchive)
+
+	registerAdminSettingRoutes(g, &as)
+	registerAdminUIRoutes(g)
+}
+
+func (as *adminServer) listFeatureFlags(c *gin.Context) {
+	span := startStoreSpan(c, "GetFeatureFlags")
+	flags, err := as.configStore.GetFeatureFlags()
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": flags})
+}
+
+func (as *adminServer) getFeatureFlag(c *gin.Context) {
+	span := startStoreSpan(c, "GetFeatureFlag")
+	flag, err := as.configStore.GetFeatureFlag(c.Param("id"))
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
+
+// putFeatureFlag creates or replaces a feature flag. The id in the body
+// may be omitted, in which case the id from the path is used.
+func (as *adminServer) putFeatureFlag(c *gin.Context) {
+	var flag FeatureFlag
+	err := c.ShouldBindJSON(&flag)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid feature flag", "", err.Error())
+		return
+	}
+
+	id := c.Param("id")
+	if flag.Id == "" {
+		flag.Id = id
+	}
+	if flag.Id != id {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid feature flag", "id",
+			"feature flag id does not match the request path")
+		return
+	}
+
+	if err := flag.Validate(); err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid feature flag", "", err.Error())
+		return
+	}
+
+	span := startStoreSpan(c, "PutFeatureFlag")
+	flag, err = as.configStore.As(requestActor(c)).PutFeatureFlag(flag)
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
+
+func (as *adminServer) setFeatureFlagEnabled(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		span := startStoreSpan(c, "SetFeatureFlagEnabled")
+		flag, err := as.configStore.As(requestActor(c)).SetFeatureFlagEnabled(c.Param("id"), enabled)
+		endStoreSpan(span, err)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, flag)
+	}
+}
+
+// evaluateFeatureFlag evaluates a flag for the targeting context in the
+// request body. An empty body evaluates for an anonymous user.
+func (as *adminServer) evaluateFeatureFlag(c *gin.Context) {
+	var context TargetingContext
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&context)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid targeting context", "", err.Error())
+			return
+		}
+	}
+
+	span := startStoreSpan(c, "EvaluateFeatureFlag")
+	evaluation, err := as.configStore.EvaluateFeatureFlag(c.Param("id"), context)
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, evaluation)
+}
+
+// exportArchive downloads the whole store, with full history, as an Archive
+func (as *adminServer) exportArchive(c *gin.Context) {
+	var body bytes.Buffer
+	span := startStoreSpan(c, "ExportArchive")
+	err := ExportArchive(as.configStore, &body)
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="aac-emulator-archive.json"`)
+	c.Data(http.StatusOK, "application/json", body.Bytes())
+}
+
+// restoreArchive replaces the whole store with the Archive in the request body
+func (as *adminServer) restoreArchive(c *gin.Context) {
+	span := startStoreSpan(c, "RestoreArchive")
+	archive, err := RestoreArchive(as.configStore, c.Request.Body)
+	endStoreSpan(span, err)
+	if errors.Is(err, ErrInvalidArchive) {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid archive", "", err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"settings":  len(archive.Settings),
+		"snapshots": len(archive.Snapshots),
+		"secrets":   len(archive.Secrets),
+	})
+}
+
+// writeStoreError maps an error from the store onto an error response
+func writeStoreError(c *gin.Context, err error) {
+	status, problem := storeErrorProblem(err)
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(status, problem)
+}
+
+// storeErrorProblem returns the status and error body the service responds
+// with for an error from the store
+func storeErrorProblem(err error) (int, ogen.Error) {
+	if errors.Is(err, ErrSettingNotFound) {
+		return http.StatusNotFound, newError(http.StatusNotFound, errTypeNotFound, "Not found", "", err.Error())
+	}
+
+	if errors.Is(err, ErrSettingLocked) {
+		return http.StatusConflict, newError(http.StatusConflict, errTypeKeyLocked, "Modifying key is not allowed", "",
+			"The key is read-only. To allow modification unlock it first.")
+	}
+
+	if errors.Is(err, ErrSnapshotExists) {
+		return http.StatusConflict, newError(http.StatusConflict, errTypeInvalidArgument, "Snapshot already exists", "name", err.Error())
+	}
+
+	if errors.Is(err, ErrPreconditionFailed) {
+		return http.StatusPreconditionFailed, newError(http.StatusPreconditionFailed, errTypePreconditionFailed, "Precondition failed", "",
+			"The etag of the key-value does not match the request's If-Match or If-None-Match.")
+	}
+
+	var kvErr *KeyValueError
+	if errors.As(err, &kvErr) {
+		return kvErr.Status, newError(kvErr.Status, kvErr.errType(), kvErr.title(), kvErr.Name, kvErr.Detail)
+	}
+
+	return http.StatusInternalServerError, newError(http.StatusInternalServerError, errTypeInternal, "Internal server error", "", err.Error())
+}
@@ -0,0 +1,368 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Settings and snapshots in the admin API, as used by the admin UI. Keys
+// may contain '/', so settings are addressed by the key and label query
+// parameters rather than by path.
+
+// adminSetting is a setting as listed by the admin API: its latest version
+// and lock state
+type adminSetting struct {
+	Key          string            `json:"key"`
+	Label        string            `json:"label"`
+	Value        string            `json:"value"`
+	ContentType  string            `json:"content_type"`
+	Tags         map[string]string `json:"tags"`
+	Etag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+	Locked       bool              `json:"locked"`
+	Versions     int               `json:"versions"`
+}
+
+// adminSettingVersion is one entry of a setting's history
+type adminSettingVersion struct {
+	Value        string            `json:"value"`
+	ContentType  string            `json:"content_type"`
+	Tags         map[string]string `json:"tags"`
+	Etag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+type adminPutSetting struct {
+	Value       *string           `json:"value"`
+	ContentType string            `json:"content_type"`
+	Tags        map[string]string `json:"tags"`
+}
+
+type adminCreateSnapshot struct {
+	Name    string           `json:"name"`
+	Filters []SnapshotFilter `json:"filters"`
+}
+
+func registerAdminSettingRoutes(g *gin.RouterGroup, as *adminServer) {
+	g.GET("/labels", as.listLabels)
+	g.GET("/audit", as.getAuditLog)
+
+	settings := g.Group("/settings")
+	settings.GET("", as.listSettings)
+	settings.GET("/history", as.getSettingHistory)
+	settings.PUT("", as.putSetting)
+	settings.DELETE("", as.deleteSetting)
+	settings.POST("/lock", as.setSettingLocked(true))
+	settings.POST("/unlock", as.setSettingLocked(false))
+
+	snapshots := g.Group("/snapshots")
+	snapshots.GET("", as.listSnapshots)
+	snapshots.POST("", as.createSnapshot)
+	snapshots.GET("/:name", as.getSnapshot)
+	snapshots.POST("/:name/archive", as.setSnapshotArchived(true))
+	snapshots.POST("/:name/recover", as.setSnapshotArchived(false))
+}
+
+// listSettings lists the latest version of each setting, optionally
+// filtered with the same key and label filters as the key-value API
+func (as *adminServer) listSettings(c *gin.Context) {
+	keyFilter, labelFilter, ok := querySettingFilters(c)
+	if !ok {
+		return
+	}
+
+	span := startStoreSpan(c, "GetSettings")
+	settings, err := as.configStore.GetSettings()
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	items := []adminSetting{}
+	for _, setting := range settings {
+		if !keyFilter.Apply(setting.Key) || !labelFilter.Apply(setting.Label) {
+			continue
+		}
+
+		latest, err := setting.GetLatest()
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+
+		items = append(items, adminSetting{
+			Key:          setting.Key,
+			Label:        setting.Label,
+			Value:        latest.Value,
+			ContentType:  latest.ContentType,
+			Tags:         latest.Tags,
+			Etag:         latest.Uuid,
+			LastModified: latest.Timestamp,
+			Locked:       setting.Locked,
+			Versions:     len(setting.Versions),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// querySettingFilters parses the key and label query parameters as filters,
+// as the key-value API does. A missing key matches every key. If either
+// is invalid it writes the error response and returns false.
+func querySettingFilters(c *gin.Context) (Filter, Filter, bool) {
+	keyFilter := Filter(nullFilter{})
+	if key := c.Query("key"); key != "" {
+		f, err := newFilter(key)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid key filter", "key", err.Error())
+			return nil, nil, false
+		}
+		keyFilter = f
+	}
+
+	var labelParam *string
+	if label, found := c.GetQuery("label"); found {
+		labelParam = &label
+	}
+	labelFilter, err := newLabelFilter(labelParam)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid label filter", "label", err.Error())
+		return nil, nil, false
+	}
+
+	return keyFilter, labelFilter, true
+}
+
+// getAuditLog returns the audit log, newest first, optionally filtered by
+// key and label and limited to the latest limit entries
+func (as *adminServer) getAuditLog(c *gin.Context) {
+	keyFilter, labelFilter, ok := querySettingFilters(c)
+	if !ok {
+		return
+	}
+
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid limit", "limit",
+				"limit must be a positive whole number")
+			return
+		}
+	}
+
+	span := startStoreSpan(c, "GetAuditLog")
+	entries, err := as.configStore.GetAuditLog(keyFilter, labelFilter, limit)
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// getSettingHistory returns every version of a setting, newest first
+func (as *adminServer) getSettingHistory(c *gin.Context) {
+	span := startStoreSpan(c, "GetLabeledConfigSetting")
+	setting, err := as.configStore.GetLabeledConfigSetting(c.Query("key"), c.Query("label"))
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	normalizeVersions(setting.Versions)
+
+	items := make([]adminSettingVersion, 0, len(setting.Versions))
+	for _, version := range setting.Versions {
+		items = append(items, adminSettingVersion{
+			Value:        version.Value,
+			ContentType:  version.ContentType,
+			Tags:         version.Tags,
+			Etag:         version.Uuid,
+			LastModified: version.Timestamp,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":    setting.Key,
+		"label":  setting.Label,
+		"locked": setting.Locked,
+		"items":  items,
+	})
+}
+
+// putSetting writes a new version of a setting, validating values with a
+// reserved or JSON content type
+func (as *adminServer) putSetting(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid setting", "key", "a key is required")
+		return
+	}
+
+	var body adminPutSetting
+	err := c.ShouldBindJSON(&body)
+	if err != nil || body.Value == nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid setting", "value", "a value is required")
+		return
+	}
+
+	title, err := validateSettingValue(key, *body.Value, body.ContentType)
+	if err == nil && isJsonContentType(body.ContentType) && !json.Valid([]byte(*body.Value)) {
+		title, err = "Invalid JSON value", errors.New("the value is not valid JSON")
+	}
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, title, "value", err.Error())
+		return
+	}
+
+	span := startStoreSpan(c, "UpdateLabeledSetting")
+	setting, err := as.configStore.As(requestActor(c)).UpdateLabeledSetting(key, c.Query("label"), *body.Value, SettingAttributes{
+		ContentType: body.ContentType,
+		Tags:        body.Tags,
+	})
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": setting.Key, "label": setting.Label, "versions": len(setting.Versions)})
+}
+
+func (as *adminServer) deleteSetting(c *gin.Context) {
+	span := startStoreSpan(c, "DeleteLabeledSetting")
+	err := as.configStore.As(requestActor(c)).DeleteLabeledSetting(c.Query("key"), c.Query("label"))
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (as *adminServer) setSettingLocked(locked bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, label := c.Query("key"), c.Query("label")
+
+		span := startStoreSpan(c, "GetLabeledConfigSetting")
+		_, err := as.configStore.GetLabeledConfigSetting(key, label)
+		endStoreSpan(span, err)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+
+		if locked {
+			span = startStoreSpan(c, "LockLabeledSetting")
+			_, err = as.configStore.As(requestActor(c)).LockLabeledSetting(key, label)
+		} else {
+			span = startStoreSpan(c, "UnlockLabeledSetting")
+			_, err = as.configStore.As(requestActor(c)).UnlockLabeledSetting(key, label)
+		}
+		endStoreSpan(span, err)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"key": key, "label": label, "locked": locked})
+	}
+}
+
+// listLabels returns the distinct labels in use, the null label first
+func (as *adminServer) listLabels(c *gin.Context) {
+	span := startStoreSpan(c, "GetSettings")
+	settings, err := as.configStore.GetSettings()
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	labels := []string{}
+	for _, setting := range settings {
+		labels = append(labels, setting.Label)
+	}
+	slices.Sort(labels)
+
+	c.JSON(http.StatusOK, gin.H{"items": slices.Compact(labels)})
+}
+
+func (as *adminServer) listSnapshots(c *gin.Context) {
+	span := startStoreSpan(c, "GetSnapshots")
+	snapshots, err := as.configStore.GetSnapshots()
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": snapshots})
+}
+
+func (as *adminServer) getSnapshot(c *gin.Context) {
+	span := startStoreSpan(c, "GetSnapshot")
+	snapshot, err := as.configStore.GetSnapshot(c.Param("name"))
+	endStoreSpan(span, err)
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (as *adminServer) createSnapshot(c *gin.Context) {
+	var body adminCreateSnapshot
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid snapshot", "", err.Error())
+		return
+	}
+
+	span := startStoreSpan(c, "CreateSnapshot")
+	snapshot, err := as.configStore.CreateSnapshot(body.Name, body.Filters)
+	endStoreSpan(span, err)
+	if errors.Is(err, ErrSnapshotExists) {
+		writeError(c, http.StatusConflict, errTypeInvalidArgument, "Snapshot already exists", "name", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid snapshot", "", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+func (as *adminServer) setSnapshotArchived(archived bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var snapshot ConfigurationSnapshot
+		var err error
+		span := startStoreSpan(c, "UpdateSnapshot")
+		if archived {
+			snapshot, err = as.configStore.ArchiveSnapshot(c.Param("name"))
+		} else {
+			snapshot, err = as.configStore.RecoverSnapshot(c.Param("name"))
+		}
+		endStoreSpan(span, err)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, snapshot)
+	}
+}
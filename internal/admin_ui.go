@@ -0,0 +1,30 @@
+package emulator
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The admin UI is a small single page application over the admin API, for
+// browsing and editing the store from a browser.
+
+const adminUIPath = "/ui"
+
+//go:embed ui
+var adminUIFiles embed.FS
+
+func registerAdminUIRoutes(g *gin.RouterGroup) {
+	files, err := fs.Sub(adminUIFiles, "ui")
+	if err != nil {
+		// The embedded directory is fixed at build time
+		panic(err)
+	}
+
+	g.GET("", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, g.BasePath()+adminUIPath+"/")
+	})
+	g.StaticFS(adminUIPath, http.FS(files))
+}
@@ -0,0 +1,127 @@
+package emulator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Service API versions the emulator knows how to speak
+const (
+	ApiVersion1_0             = "1.0"
+	ApiVersion2022_11_01_Prev = "2022-11-01-preview"
+	ApiVersion2023_10_01      = "2023-10-01"
+	ApiVersion2024_09_01      = "2024-09-01"
+)
+
+// DefaultApiVersions is the set of api-version values accepted unless
+// the server is configured otherwise.
+var DefaultApiVersions = []string{
+	ApiVersion1_0,
+	ApiVersion2022_11_01_Prev,
+	ApiVersion2023_10_01,
+	ApiVersion2024_09_01,
+}
+
+// apiFeature is a piece of the REST API that only exists from a particular
+// api-version onwards.
+type apiFeature struct {
+	name       string
+	minVersion string
+}
+
+var (
+	featureSnapshots = apiFeature{name: "snapshots", minVersion: ApiVersion2022_11_01_Prev}
+	featureTags      = apiFeature{name: "tags filtering", minVersion: ApiVersion2024_09_01}
+)
+
+// snapshotOperations are the operation IDs that make up the snapshots API
+var snapshotOperations = []string{
+	"CreateSnapshot",
+	"UpdateSnapshot",
+	"GetSnapshot",
+	"GetSnapshots",
+	"CheckSnapshot",
+	"CheckSnapshots",
+	"GetOperationDetails",
+}
+
+// apiVersions holds the set of api-version values a server accepts
+type apiVersions struct {
+	supported []string
+}
+
+func newApiVersions(supported []string) apiVersions {
+	sorted := slices.Clone(supported)
+	slices.SortFunc(sorted, compareApiVersions)
+	return apiVersions{supported: sorted}
+}
+
+func (av apiVersions) IsSupported(version string) bool {
+	return slices.Contains(av.supported, version)
+}
+
+// Supports reports whether @param version is new enough for @param feature
+func (av apiVersions) Supports(version string, feature apiFeature) bool {
+	return compareApiVersions(version, feature.minVersion) >= 0
+}
+
+func (av apiVersions) String() string {
+	return strings.Join(av.supported, ", ")
+}
+
+// checkRequest validates the api-version of a request to @param operationID.
+// The returned error's message is suitable for returning to the client.
+func (av apiVersions) checkRequest(version string, operationID string, query map[string][]string) error {
+	if !av.IsSupported(version) {
+		return fmt.Errorf(
+			"the api-version '%s' is invalid, the supported versions are: %s",
+			version, av.String(),
+		)
+	}
+
+	if slices.Contains(snapshotOperations, operationID) || len(query["snapshot"]) > 0 {
+		if !av.Supports(version, featureSnapshots) {
+			return unsupportedFeatureError(version, featureSnapshots)
+		}
+	}
+
+	if len(query["tags"]) > 0 && !av.Supports(version, featureTags) {
+		return unsupportedFeatureError(version, featureTags)
+	}
+
+	return nil
+}
+
+func unsupportedFeatureError(version string, feature apiFeature) error {
+	return fmt.Errorf(
+		"the api-version '%s' does not support %s, use api-version '%s' or later",
+		version, feature.name, feature.minVersion,
+	)
+}
+
+// compareApiVersions orders api-version strings. "1.0" predates all the
+// date-based versions, and a preview sorts before the GA of the same date.
+func compareApiVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == ApiVersion1_0 {
+		return -1
+	}
+	if b == ApiVersion1_0 {
+		return 1
+	}
+
+	aDate, aPreview := strings.CutSuffix(a, "-preview")
+	bDate, _ := strings.CutSuffix(b, "-preview")
+	if c := strings.Compare(aDate, bDate); c != 0 {
+		return c
+	}
+
+	// Same date, a != b, so exactly one of them is the preview
+	if aPreview {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,294 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+)
+
+// An archive is a complete dump of the store: every setting with its full
+// version history and lock state, the snapshots and the secrets. Archives
+// are deterministic, so that the same store always produces the same bytes,
+// and restoring one recreates the store exactly. The audit log is not
+// archived: it is only ever appended to, so restoring an archive adds to it
+// rather than replacing it.
+
+const (
+	ArchiveFormat = "aac-emulator-archive"
+	// ArchiveVersion is incremented whenever the archive layout changes
+	ArchiveVersion = 1
+
+	// cloverIdField is the id Clover adds to every document
+	cloverIdField = "_id"
+)
+
+// ErrInvalidArchive is returned (wrapped) when an archive cannot be restored
+var ErrInvalidArchive = errors.New("invalid archive")
+
+type Archive struct {
+	Format    string                   `json:"format"`
+	Version   int                      `json:"version"`
+	Settings  []ConfigSetting          `json:"settings"`
+	Snapshots []map[string]interface{} `json:"snapshots"`
+	Secrets   []ConfigSetting          `json:"secrets"`
+}
+
+// Dump returns the whole store as an Archive
+func (pcs *persistentConfigStore) Dump() (Archive, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	settings, err := pcs.getSettings()
+	if err != nil {
+		return Archive{}, err
+	}
+
+	secrets, err := pcs.dumpSettingCollection(SECRET_COLLECTION_NAME)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	snapshots, err := pcs.dumpSnapshots()
+	if err != nil {
+		return Archive{}, err
+	}
+
+	for _, setting := range slices.Concat(settings, secrets) {
+		normalizeVersions(setting.Versions)
+	}
+
+	return Archive{
+		Format:    ArchiveFormat,
+		Version:   ArchiveVersion,
+		Settings:  settings,
+		Snapshots: snapshots,
+		Secrets:   secrets,
+	}, nil
+}
+
+// Restore replaces the whole contents of the store with @param archive.
+// The archive is validated before anything is removed.
+func (pcs *persistentConfigStore) Restore(archive Archive) error {
+	err := validateArchive(archive)
+	if err != nil {
+		return err
+	}
+
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	err = pcs.reset()
+	if err != nil {
+		return err
+	}
+
+	collections := []struct {
+		name      string
+		documents []interface{}
+	}{
+		{SETTING_COLECTION_NAME, toInterfaces(archive.Settings)},
+		{SECRET_COLLECTION_NAME, toInterfaces(archive.Secrets)},
+		{SNAPSHOT_COLECTION_NAME, toInterfaces(archive.Snapshots)},
+	}
+
+	for _, snapshot := range archive.Snapshots {
+		delete(snapshot, cloverIdField)
+	}
+
+	for _, collection := range collections {
+		for _, document := range collection.documents {
+			_, err = pcs.cdb.InsertOne(collection.name, clover.NewDocumentOf(document))
+			if err != nil {
+				return errors.Wrapf(err, "failed to restore %s", collection.name)
+			}
+		}
+	}
+
+	for _, setting := range archive.Settings {
+		pcs.notifyChange(ChangeRestore, setting, "")
+	}
+
+	return nil
+}
+
+// validateArchive checks that every part of @param archive can be restored
+func validateArchive(archive Archive) error {
+	if archive.Format != ArchiveFormat {
+		return errors.Wrapf(ErrInvalidArchive, "format is not %s", ArchiveFormat)
+	}
+	if archive.Version != ArchiveVersion {
+		return errors.Wrapf(ErrInvalidArchive, "unsupported version %d, expected %d", archive.Version, ArchiveVersion)
+	}
+
+	for _, settings := range [][]ConfigSetting{archive.Settings, archive.Secrets} {
+		seen := map[[2]string]bool{}
+		for _, setting := range settings {
+			if setting.Key == "" {
+				return errors.Wrap(ErrInvalidArchive, "a setting has no key")
+			}
+			if len(setting.Versions) == 0 {
+				return errors.Wrapf(ErrInvalidArchive, "setting %s has no versions", setting.Key)
+			}
+			id := [2]string{setting.Key, setting.Label}
+			if seen[id] {
+				return errors.Wrapf(ErrInvalidArchive, "setting %s with label '%s' is archived twice", setting.Key, setting.Label)
+			}
+			seen[id] = true
+		}
+	}
+
+	names := map[string]bool{}
+	for i, document := range archive.Snapshots {
+		var snapshot ConfigurationSnapshot
+		err := clover.NewDocumentOf(document).Unmarshal(&snapshot)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidArchive, "snapshot %d can't be read: %s", i+1, err)
+		}
+		if snapshot.Name == "" {
+			return errors.Wrapf(ErrInvalidArchive, "snapshot %d has no name", i+1)
+		}
+		if names[snapshot.Name] {
+			return errors.Wrapf(ErrInvalidArchive, "snapshot %s is archived twice", snapshot.Name)
+		}
+		names[snapshot.Name] = true
+	}
+
+	return nil
+}
+
+// ExportArchive writes the whole store to @param w as an Archive
+func ExportArchive(store *persistentConfigStore, w io.Writer) error {
+	archive, err := store.Dump()
+	if err != nil {
+		return errors.Wrap(err, "failed to dump store")
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(archive)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode archive")
+	}
+
+	return nil
+}
+
+// RestoreArchive reads an Archive from @param r and restores the store from it
+func RestoreArchive(store *persistentConfigStore, r io.Reader) (Archive, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var archive Archive
+	err := decoder.Decode(&archive)
+	if err != nil {
+		return Archive{}, errors.Wrapf(ErrInvalidArchive, "failed to decode archive: %s", err)
+	}
+
+	err = store.Restore(archive)
+	if err != nil {
+		return Archive{}, errors.Wrap(err, "failed to restore archive")
+	}
+
+	return archive, nil
+}
+
+// dumpSettingCollection returns the ConfigSetting documents of collection
+// @param name, ordered by key and label.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) dumpSettingCollection(name string) ([]ConfigSetting, error) {
+	docs, err := pcs.cdb.Query(name).FindAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %s", name)
+	}
+
+	settings := make([]ConfigSetting, 0, len(docs))
+	for _, doc := range docs {
+		var setting ConfigSetting
+		err = doc.Unmarshal(&setting)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s document %s", name, doc.ObjectId())
+		}
+		settings = append(settings, setting)
+	}
+
+	slices.SortFunc(settings, func(a, b ConfigSetting) int {
+		if c := strings.Compare(a.Key, b.Key); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Label, b.Label)
+	})
+
+	return settings, nil
+}
+
+// dumpSnapshots returns the snapshot documents, without their Clover ids,
+// ordered by their JSON encoding.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) dumpSnapshots() ([]map[string]interface{}, error) {
+	docs, err := pcs.cdb.Query(SNAPSHOT_COLECTION_NAME).FindAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list snapshots")
+	}
+
+	type encodedSnapshot struct {
+		snapshot map[string]interface{}
+		encoded  []byte
+	}
+
+	encodedSnapshots := make([]encodedSnapshot, 0, len(docs))
+	for _, doc := range docs {
+		var snapshot map[string]interface{}
+		err = doc.Unmarshal(&snapshot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal snapshot document %s", doc.ObjectId())
+		}
+		delete(snapshot, cloverIdField)
+
+		encoded, err := json.Marshal(snapshot)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode snapshot")
+		}
+		encodedSnapshots = append(encodedSnapshots, encodedSnapshot{snapshot, encoded})
+	}
+
+	slices.SortFunc(encodedSnapshots, func(a, b encodedSnapshot) int {
+		return bytes.Compare(a.encoded, b.encoded)
+	})
+
+	snapshots := make([]map[string]interface{}, 0, len(encodedSnapshots))
+	for _, es := range encodedSnapshots {
+		snapshots = append(snapshots, es.snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// normalizeVersions orders @param versions newest first, with timestamps in UTC
+func normalizeVersions(versions []ConfigSettingVersion) {
+	for i := range versions {
+		versions[i].Timestamp = versions[i].Timestamp.UTC()
+		if versions[i].Tags == nil {
+			versions[i].Tags = map[string]string{}
+		}
+	}
+
+	slices.SortStableFunc(versions, func(a, b ConfigSettingVersion) int {
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Uuid, b.Uuid)
+	})
+}
+
+func toInterfaces[T any](items []T) []interface{} {
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[i] = item
+	}
+	return result
+}
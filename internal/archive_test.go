@@ -0,0 +1,133 @@
+package emulator
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ostafen/clover"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	populate := func(t *testing.T, store *persistentConfigStore) {
+		_, err := store.UpdateSetting("testsetting1", "testvalue_1_1")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("testsetting1", "testvalue_1_2")
+		require.NoError(t, err)
+		_, err = store.UpdateLabeledSetting("testsetting1", "prod", "testvalue_1_prod", SettingAttributes{
+			ContentType: "text/plain",
+			Tags:        map[string]string{"owner": "ops"},
+		})
+		require.NoError(t, err)
+		_, err = store.LockLabeledSetting("testsetting1", "prod")
+		require.NoError(t, err)
+		_, err = store.SetSecret("secret1", "hunter2", SettingAttributes{})
+		require.NoError(t, err)
+
+		_, err = store.cdb.InsertOne(SNAPSHOT_COLECTION_NAME, clover.NewDocumentOf(map[string]interface{}{
+			"name": "release-1", "status": "ready",
+		}))
+		require.NoError(t, err)
+	}
+
+	t.Run("Restore recreates the store exactly", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		populate(t, store)
+
+		var first bytes.Buffer
+		require.NoError(t, ExportArchive(store, &first))
+
+		// Exporting is deterministic
+		var second bytes.Buffer
+		require.NoError(t, ExportArchive(store, &second))
+		require.Equal(t, first.String(), second.String())
+
+		_, err = store.UpdateSetting("testsetting2", "added after export")
+		require.NoError(t, err)
+
+		restored, err := RestoreArchive(store, bytes.NewReader(first.Bytes()))
+		require.NoError(t, err)
+		require.Len(t, restored.Settings, 2)
+		require.Len(t, restored.Snapshots, 1)
+		require.Len(t, restored.Secrets, 1)
+
+		var third bytes.Buffer
+		require.NoError(t, ExportArchive(store, &third))
+		require.Equal(t, first.String(), third.String())
+
+		setting, err := store.GetConfigSetting("testsetting1")
+		require.NoError(t, err)
+		require.Len(t, setting.Versions, 2)
+		require.Equal(t, "testvalue_1_2", setting.Versions[0].Value)
+
+		prod, err := store.GetLabeledConfigSetting("testsetting1", "prod")
+		require.NoError(t, err)
+		require.True(t, prod.Locked)
+
+		_, err = store.GetConfigSetting("testsetting2")
+		require.ErrorIs(t, err, ErrSettingNotFound)
+	})
+
+	t.Run("Invalid archives are rejected", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		invalid := map[string]string{
+			"not json":         `wibble`,
+			"wrong format":     `{"format": "something-else", "version": 1}`,
+			"future version":   `{"format": "aac-emulator-archive", "version": 99}`,
+			"no versions":      `{"format": "aac-emulator-archive", "version": 1, "settings": [{"key": "a", "versions": []}]}`,
+			"unexpected field": `{"format": "aac-emulator-archive", "version": 1, "wibble": true}`,
+			"duplicate setting": `{"format": "aac-emulator-archive", "version": 1, "settings": [
+				{"key": "a", "versions": [{"value": "1"}]}, {"key": "a", "versions": [{"value": "2"}]}]}`,
+			"unnamed snapshot": `{"format": "aac-emulator-archive", "version": 1,
+				"settings": [{"key": "a", "versions": [{"value": "1"}]}], "snapshots": [{"status": "ready"}]}`,
+		}
+
+		populate(t, store)
+		for name, archive := range invalid {
+			_, err := RestoreArchive(store, strings.NewReader(archive))
+			require.ErrorIs(t, err, ErrInvalidArchive, name)
+
+			// Nothing is removed before the archive is validated
+			_, err = store.GetConfigSetting("testsetting1")
+			require.NoError(t, err, name)
+		}
+	})
+
+	t.Run("Admin endpoints", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		populate(t, store)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, AdminBasePath+"/archive", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+		archive := rec.Body.String()
+
+		require.NoError(t, store.Reset())
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPut, AdminBasePath+"/archive", strings.NewReader(archive))
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		value, err := store.GetSetting("testsetting1")
+		require.NoError(t, err)
+		require.Equal(t, "testvalue_1_2", value)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPut, AdminBasePath+"/archive", strings.NewReader(`{}`))
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
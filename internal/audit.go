@@ -0,0 +1,212 @@
+package emulator
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+)
+
+// The audit log records every change to a setting: what changed, when, by
+// whom, and the etag it replaced. Entries are only ever appended; resetting
+// or restoring the store does not remove them. Values are not recorded.
+
+const (
+	AUDIT_COLLECTION_NAME = "audit"
+
+	// SystemPrincipal is recorded for changes not made on behalf of a
+	// request, such as seeding and directory syncs
+	SystemPrincipal = "system"
+)
+
+// Actor identifies who a change is made by
+type Actor struct {
+	Principal string
+	RequestId string
+}
+
+// AuditEntry is one change in the audit log. Fields are named so that
+// their json names match the stored document's.
+type AuditEntry struct {
+	Sequence     int64           `json:"sequence"`
+	Time         time.Time       `json:"time"`
+	Operation    ChangeOperation `json:"operation"`
+	Key          string          `json:"key"`
+	Label        string          `json:"label"`
+	Etag         string          `json:"etag"`
+	PreviousEtag string          `json:"previousEtag"`
+	Principal    string          `json:"principal"`
+	RequestId    string          `json:"requestId"`
+}
+
+// An ActorStore makes changes to the store on behalf of an Actor, who the
+// audit log attributes them to. Changes to settings may be made only if a
+// Precondition holds.
+type ActorStore struct {
+	pcs   *persistentConfigStore
+	actor Actor
+	cond  Precondition
+}
+
+// As returns a view of the store whose changes are attributed to @param actor
+func (pcs *persistentConfigStore) As(actor Actor) ActorStore {
+	return ActorStore{pcs: pcs, actor: actor}
+}
+
+// If returns a view of the store that changes a setting only if
+// @param cond holds for it, and otherwise returns ErrPreconditionFailed
+func (as ActorStore) If(cond Precondition) ActorStore {
+	as.cond = cond
+	return as
+}
+
+// lock locks the store with the actor set, returning the function that
+// unlocks it again
+func (as ActorStore) lock() func() {
+	as.pcs.Lock()
+	as.pcs.actor = as.actor
+
+	return func() {
+		as.pcs.actor = Actor{}
+		as.pcs.Unlock()
+	}
+}
+
+// UpdateLabeledSetting is persistentConfigStore.UpdateLabeledSetting
+func (as ActorStore) UpdateLabeledSetting(key string, label string, value string, attrs SettingAttributes) (ConfigSetting, error) {
+	defer as.lock()()
+	err := as.pcs.checkPrecondition(key, label, as.cond)
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+	return as.pcs.updateLabeledSetting(key, label, value, attrs)
+}
+
+// DeleteLabeledSetting is persistentConfigStore.DeleteLabeledSetting
+func (as ActorStore) DeleteLabeledSetting(key string, label string) error {
+	defer as.lock()()
+	err := as.pcs.checkPrecondition(key, label, as.cond)
+	if err != nil {
+		return err
+	}
+	return as.pcs.deleteLabeledSetting(key, label)
+}
+
+// LockLabeledSetting is persistentConfigStore.LockLabeledSetting
+func (as ActorStore) LockLabeledSetting(key string, label string) (ConfigSetting, error) {
+	defer as.lock()()
+	err := as.pcs.checkPrecondition(key, label, as.cond)
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+	return as.pcs.setSettingLocked(key, label, true)
+}
+
+// UnlockLabeledSetting is persistentConfigStore.UnlockLabeledSetting
+func (as ActorStore) UnlockLabeledSetting(key string, label string) (ConfigSetting, error) {
+	defer as.lock()()
+	err := as.pcs.checkPrecondition(key, label, as.cond)
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+	return as.pcs.setSettingLocked(key, label, false)
+}
+
+// PutFeatureFlag is persistentConfigStore.PutFeatureFlag
+func (as ActorStore) PutFeatureFlag(flag FeatureFlag) (FeatureFlag, error) {
+	defer as.lock()()
+	return as.pcs.putFeatureFlag(flag, map[string]string{})
+}
+
+// SetFeatureFlagEnabled is persistentConfigStore.SetFeatureFlagEnabled
+func (as ActorStore) SetFeatureFlagEnabled(id string, enabled bool) (FeatureFlag, error) {
+	defer as.lock()()
+	return as.pcs.setFeatureFlagEnabled(id, enabled)
+}
+
+// GetAuditLog returns the audit entries for settings matching
+// @param keyFilter and @param labelFilter, newest first, at most
+// @param limit of them (all if limit <= 0)
+func (pcs *persistentConfigStore) GetAuditLog(keyFilter Filter, labelFilter Filter, limit int) ([]AuditEntry, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	docs, err := pcs.cdb.Query(AUDIT_COLLECTION_NAME).
+		Sort(clover.SortOption{Field: "Sequence", Direction: -1}).
+		FindAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the audit log")
+	}
+
+	entries := []AuditEntry{}
+	for _, doc := range docs {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+
+		var entry AuditEntry
+		err = doc.Unmarshal(&entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal audit document %s", doc.ObjectId())
+		}
+		if keyFilter.Apply(entry.Key) && labelFilter.Apply(entry.Label) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// appendAudit records @param change in the audit log. A failure to do so
+// is logged rather than failing the change, which has already been made.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) appendAudit(change SettingChange) {
+	principal := change.Actor.Principal
+	if principal == "" {
+		principal = SystemPrincipal
+	}
+
+	entry := AuditEntry{
+		Sequence:     change.Sequence,
+		Time:         change.Time,
+		Operation:    change.Operation,
+		Key:          change.Key,
+		Label:        change.Label,
+		Etag:         change.Etag,
+		PreviousEtag: change.PreviousEtag,
+		Principal:    principal,
+		RequestId:    change.Actor.RequestId,
+	}
+
+	_, err := pcs.cdb.InsertOne(AUDIT_COLLECTION_NAME, clover.NewDocumentOf(entry))
+	if err != nil {
+		slog.Error("failed to append to the audit log", "key", change.Key, "label", change.Label, "error", err)
+	}
+
+	slog.Debug("setting changed", "operation", change.Operation, "key", change.Key, "label", change.Label,
+		"etag", change.Etag, "principal", principal, "request_id", change.Actor.RequestId)
+}
+
+// lastAuditSequence returns the sequence number of the latest audit entry,
+// or 0 if there are none
+func lastAuditSequence(cdb *clover.DB) (int64, error) {
+	docs, err := cdb.Query(AUDIT_COLLECTION_NAME).
+		Sort(clover.SortOption{Field: "Sequence", Direction: -1}).
+		Limit(1).
+		FindAll()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read the audit log")
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	var entry AuditEntry
+	err = docs[0].Unmarshal(&entry)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to unmarshal audit document")
+	}
+
+	return entry.Sequence, nil
+}
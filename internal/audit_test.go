@@ -0,0 +1,135 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ostafen/clover"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Run("Changes are attributed to their principal", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		credential := Credential{Id: "test-id", Secret: base64.StdEncoding.EncodeToString([]byte("test-secret"))}
+		engine := SetupRestServer(store, WithHmacAuth([]Credential{credential}))
+
+		created, err := store.UpdateSetting("App:Name", "seeded")
+		require.NoError(t, err)
+
+		body := `{"value": "from the api"}`
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		signRequest(t, rq, body, credential)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+		requestId := rec.Header().Get(requestIdHeader)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPost, AdminBasePath+"/settings/lock?key=App:Name", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		entries, err := store.GetAuditLog(nullFilter{}, nullFilter{}, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		lock, update, create := entries[0], entries[1], entries[2]
+		require.Equal(t, ChangeCreate, create.Operation)
+		require.Equal(t, SystemPrincipal, create.Principal)
+		require.Equal(t, created.Versions[0].Uuid, create.Etag)
+		require.Empty(t, create.PreviousEtag)
+
+		require.Equal(t, ChangeUpdate, update.Operation)
+		require.Equal(t, "test-id", update.Principal)
+		require.Equal(t, requestId, update.RequestId)
+		require.Equal(t, create.Etag, update.PreviousEtag)
+
+		require.Equal(t, ChangeLock, lock.Operation)
+		require.Equal(t, adminPrincipal, lock.Principal)
+		require.Equal(t, update.Etag, lock.Etag)
+	})
+
+	t.Run("Admin endpoint filters and limits", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := store.UpdateSetting("App:Name", "1")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("App:Name", "2")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "3")
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, AdminBasePath+"/audit?key=App:*&limit=1", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var body struct {
+			Items []AuditEntry `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Len(t, body.Items, 1)
+		require.Equal(t, int64(2), body.Items[0].Sequence)
+		require.Equal(t, ChangeUpdate, body.Items[0].Operation)
+	})
+
+	t.Run("The sequence carries on when the store is reopened", func(t *testing.T) {
+		store, cdb, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting("App:Name", "1")
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteSetting("App:Name"))
+
+		reopened, _, err := NewPersistentConfigStore(func() (*clover.DB, func(), error) {
+			return cdb, func() {}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, formatSyncToken(2), reopened.SyncToken())
+	})
+
+	t.Run("Requests are logged without values", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		var logs bytes.Buffer
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+		defer slog.SetDefault(defaultLogger)
+
+		clientRequestId := uuid.NewString()
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/App:Password?api-version=2023-10-01&label=prod",
+			strings.NewReader(`{"value": "hunter2"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		rq.Header.Set(clientRequestIdHeader, clientRequestId)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		require.NotContains(t, logs.String(), "hunter2")
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+		require.Equal(t, "request", entry["msg"])
+		require.Equal(t, rec.Header().Get(requestIdHeader), entry["request_id"])
+		require.Equal(t, clientRequestId, entry["client_request_id"])
+		require.Equal(t, "PutKeyValue", entry["operation"])
+		require.Equal(t, anonymousPrincipal, entry["principal"])
+		require.Equal(t, "App:Password", entry["key"])
+		require.Equal(t, "prod", entry["label"])
+		require.Equal(t, float64(http.StatusOK), entry["status"])
+	})
+}
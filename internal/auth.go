@@ -0,0 +1,201 @@
+package emulator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Authentication modes for the App Configuration API
+const (
+	// AuthModeNone accepts every request, authenticated or not
+	AuthModeNone = "none"
+	// AuthModeHmac requires requests to be signed with an access key, as the
+	// SDKs do when given a connection string
+	AuthModeHmac = "hmac"
+)
+
+var AuthModes = []string{AuthModeNone, AuthModeHmac}
+
+const (
+	errTypeUnauthorized = "https://azconfig.io/errors/unauthorized"
+
+	hmacScheme = "HMAC-SHA256"
+
+	// hmacMaxClockSkew is how far from now the date of a signed request
+	// may be, so that a captured signature can't be replayed for long
+	hmacMaxClockSkew = 15 * time.Minute
+)
+
+// hmacRequiredSignedHeaders are the headers a signature must cover, any
+// one of each set: the date, so that it can't be replayed, the host, and
+// the content hash, so that the body can't be replaced
+var hmacRequiredSignedHeaders = [][]string{{"x-ms-date", "date"}, {"host"}, {"x-ms-content-sha256"}}
+
+// Credential is an App Configuration access key, as found in the Id and
+// Secret fields of a connection string.
+type Credential struct {
+	Id     string
+	Secret string
+}
+
+// hmacAuthMiddleware verifies the HMAC-SHA256 signature of each request
+// against @param credentials.
+// See https://learn.microsoft.com/azure/azure-app-configuration/rest-api-authentication-hmac
+func hmacAuthMiddleware(credentials []Credential) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := verifyHmacRequest(c.Request, credentials)
+		if err != nil {
+			c.Header("WWW-Authenticate", hmacScheme)
+			writeError(c, http.StatusUnauthorized, errTypeUnauthorized, "Unauthorized", "", err.Error())
+			return
+		}
+		c.Set(principalContextKey, id)
+	}
+}
+
+// verifyHmacRequest returns the id of the credential @param rq is signed with
+func verifyHmacRequest(rq *http.Request, credentials []Credential) (string, error) {
+	scheme, params, found := strings.Cut(rq.Header.Get("Authorization"), " ")
+	if !found || scheme != hmacScheme {
+		return "", fmt.Errorf("an %s Authorization header is required", hmacScheme)
+	}
+
+	// The documentation separates the parameters with '&', the SDKs with ', '
+	fields := map[string]string{}
+	for _, field := range strings.FieldsFunc(params, func(r rune) bool { return r == '&' || r == ',' }) {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	var credential *Credential
+	for i := range credentials {
+		if credentials[i].Id == fields["Credential"] {
+			credential = &credentials[i]
+		}
+	}
+	if credential == nil {
+		return "", fmt.Errorf("unknown credential '%s'", fields["Credential"])
+	}
+
+	signedHeaders := strings.Split(strings.ToLower(fields["SignedHeaders"]), ";")
+	for _, required := range hmacRequiredSignedHeaders {
+		if !slices.ContainsFunc(required, func(name string) bool { return slices.Contains(signedHeaders, name) }) {
+			return "", fmt.Errorf("SignedHeaders must include %s", strings.Join(required, " or "))
+		}
+	}
+
+	date := rq.Header.Get("x-ms-date")
+	if !slices.Contains(signedHeaders, "x-ms-date") {
+		date = rq.Header.Get("Date")
+	}
+	signedAt, err := http.ParseTime(date)
+	if err != nil {
+		return "", fmt.Errorf("invalid request date '%s'", date)
+	}
+	if skew := time.Since(signedAt).Abs(); skew > hmacMaxClockSkew {
+		return "", fmt.Errorf("the request date is more than %s from now", hmacMaxClockSkew)
+	}
+
+	// The body must match the content hash, which is covered by the signature
+	body, err := io.ReadAll(rq.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read request body")
+	}
+	rq.Body = io.NopCloser(bytes.NewReader(body))
+
+	contentHash := sha256.Sum256(body)
+	if rq.Header.Get("x-ms-content-sha256") != base64.StdEncoding.EncodeToString(contentHash[:]) {
+		return "", fmt.Errorf("x-ms-content-sha256 does not match the request body")
+	}
+
+	signedValues := []string{}
+	for _, name := range signedHeaders {
+		if name == "host" {
+			signedValues = append(signedValues, rq.Host)
+		} else {
+			signedValues = append(signedValues, rq.Header.Get(name))
+		}
+	}
+
+	expected, err := hmacSignature(*credential, rq.Method, rq.URL.RequestURI(), signedValues)
+	if err != nil {
+		return "", err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	return credential.Id, nil
+}
+
+// SignHmacRequest signs @param rq, whose body is @param body, with
+// @param credential, as the SDKs do
+func SignHmacRequest(rq *http.Request, body []byte, credential Credential) error {
+	contentHash := sha256.Sum256(body)
+	rq.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	rq.Header.Set("x-ms-content-sha256", base64.StdEncoding.EncodeToString(contentHash[:]))
+
+	signature, err := hmacSignature(credential, rq.Method, rq.URL.RequestURI(),
+		[]string{rq.Header.Get("x-ms-date"), rq.URL.Host, rq.Header.Get("x-ms-content-sha256")})
+	if err != nil {
+		return err
+	}
+
+	rq.Header.Set("Authorization", hmacScheme+" Credential="+credential.Id+
+		"&SignedHeaders=x-ms-date;host;x-ms-content-sha256&Signature="+signature)
+
+	return nil
+}
+
+// hmacSignature returns the signature, with @param credential, of a
+// request with @param method and @param requestURI, and the values of
+// its signed headers @param signedValues
+func hmacSignature(credential Credential, method string, requestURI string, signedValues []string) (string, error) {
+	stringToSign := strings.Join([]string{
+		method,
+		requestURI,
+		strings.Join(signedValues, ";"),
+	}, "\n")
+
+	secret, err := base64.StdEncoding.DecodeString(credential.Secret)
+	if err != nil {
+		return "", errors.Wrap(err, "credential secret is not valid base64")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ParseConnectionString returns the endpoint and credential of the
+// App Configuration connection string @param connectionString, of the form
+// Endpoint=https://name.azconfig.io;Id=...;Secret=...
+func ParseConnectionString(connectionString string) (string, Credential, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(connectionString, ";") {
+		name, value, found := strings.Cut(field, "=")
+		if found {
+			fields[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+	}
+
+	endpoint, id, secret := fields["endpoint"], fields["id"], fields["secret"]
+	if endpoint == "" || id == "" || secret == "" {
+		return "", Credential{}, fmt.Errorf("a connection string requires Endpoint, Id and Secret")
+	}
+
+	return endpoint, Credential{Id: id, Secret: secret}, nil
+}
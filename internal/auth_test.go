@@ -0,0 +1,242 @@
+package emulator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signRequest signs @param rq the way the SDKs do for a connection string
+func signRequest(t *testing.T, rq *http.Request, body string, credential Credential) {
+	signRequestAt(t, rq, body, credential, time.Now(), []string{"x-ms-date", "host", "x-ms-content-sha256"})
+}
+
+// signRequestAt signs @param rq as dated @param date, covering
+// @param signedHeaders
+func signRequestAt(t *testing.T, rq *http.Request, body string, credential Credential, date time.Time, signedHeaders []string) {
+	contentHash := sha256.Sum256([]byte(body))
+	rq.Header.Set("x-ms-date", date.UTC().Format(http.TimeFormat))
+	rq.Header.Set("x-ms-content-sha256", base64.StdEncoding.EncodeToString(contentHash[:]))
+
+	signedValues := []string{}
+	for _, name := range signedHeaders {
+		if name == "host" {
+			signedValues = append(signedValues, rq.Host)
+		} else {
+			signedValues = append(signedValues, rq.Header.Get(name))
+		}
+	}
+	stringToSign := strings.Join([]string{
+		rq.Method,
+		rq.URL.RequestURI(),
+		strings.Join(signedValues, ";"),
+	}, "\n")
+
+	secret, err := base64.StdEncoding.DecodeString(credential.Secret)
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+
+	rq.Header.Set("Authorization", hmacScheme+" Credential="+credential.Id+
+		"&SignedHeaders="+strings.Join(signedHeaders, ";")+
+		"&Signature="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func TestHmacAuth(t *testing.T) {
+	credential := Credential{Id: "test-id", Secret: base64.StdEncoding.EncodeToString([]byte("test-secret"))}
+
+	t.Run("Unsigned and signed requests", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithHmacAuth([]Credential{credential}))
+		body := `{"value": "testvalue_1_1"}`
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01", strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01", strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		signRequest(t, rq, body, credential)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// A body that does not match the signed hash is rejected
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01",
+			strings.NewReader(`{"value": "tampered"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		signRequest(t, rq, body, credential)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Signatures must cover the date, host and content hash", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithHmacAuth([]Credential{credential}))
+		body := `{"value": "v"}`
+
+		for _, signedHeaders := range [][]string{
+			{},
+			{"host", "x-ms-content-sha256"},
+			{"x-ms-date", "x-ms-content-sha256"},
+			{"x-ms-date", "host"},
+		} {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodPut, "/kv/App?api-version=2023-10-01", strings.NewReader(body))
+			rq.Header.Set("Content-Type", "application/json")
+			signRequestAt(t, rq, body, credential, time.Now(), signedHeaders)
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, http.StatusUnauthorized, rec.Code, "%v", signedHeaders)
+			require.Contains(t, rec.Body.String(), "SignedHeaders must include")
+		}
+	})
+
+	t.Run("Requests dated more than 15 minutes from now are refused", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithHmacAuth([]Credential{credential}))
+
+		for _, tc := range []struct {
+			date   time.Time
+			status int
+		}{
+			{time.Now().Add(-14 * time.Minute), http.StatusOK},
+			{time.Now().Add(14 * time.Minute), http.StatusOK},
+			{time.Now().Add(-16 * time.Minute), http.StatusUnauthorized},
+			{time.Now().Add(16 * time.Minute), http.StatusUnauthorized},
+		} {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodGet, "/kv?api-version=2023-10-01", nil)
+			signRequestAt(t, rq, "", credential, tc.date, []string{"x-ms-date", "host", "x-ms-content-sha256"})
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, tc.status, rec.Code, "%s: %s", tc.date, rec.Body.String())
+		}
+	})
+
+	t.Run("Routes outside the App Configuration API", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithHmacAuth([]Credential{credential}),
+			WithKeyVault(), WithQueues(NewQueueBroker(QueueOptions{})))
+
+		for _, tc := range []struct {
+			method string
+			target string
+			body   string
+			status int
+		}{
+			// Clients of App Configuration authenticate
+			{http.MethodPost, QueuesBasePath + "/events/messages", `{}`, http.StatusUnauthorized},
+			{http.MethodGet, QueuesBasePath, "", http.StatusUnauthorized},
+			{http.MethodGet, WatchPath, "", http.StatusUnauthorized},
+			// The admin API and the secrets API are exempt
+			{http.MethodPut, AdminBasePath + "/settings?key=App", `{"value": "v"}`, http.StatusOK},
+			{http.MethodPut, "/secrets/db", `{"value": "v"}`, http.StatusOK},
+			{http.MethodGet, MetricsPath, "", http.StatusOK},
+		} {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, tc.status, rec.Code, "%s %s", tc.method, tc.target)
+		}
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPost, QueuesBasePath+"/events/messages", strings.NewReader(`{}`))
+		rq.Header.Set("Content-Type", "application/json")
+		signRequest(t, rq, `{}`, credential)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusCreated, rec.Code)
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Run("Writes are forbidden and reads allowed", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting("testsetting1", "testvalue_1_1")
+		require.NoError(t, err)
+
+		engine := SetupRestServer(store, WithReadOnly())
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01",
+			strings.NewReader(`{"value": "testvalue_1_2"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodGet, "/kv/testsetting1?api-version=2023-10-01", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "testvalue_1_1")
+	})
+
+	t.Run("Routes outside the App Configuration API", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting(FeatureFlagKeyPrefix+"Beta", `{"id": "Beta", "enabled": true}`)
+		require.NoError(t, err)
+
+		quotas, err := NewQuotaEnforcer(store, QuotaOptions{Tier: TierPremium})
+		require.NoError(t, err)
+		engine := SetupRestServer(store, WithReadOnly(), WithKeyVault(),
+			WithQueues(NewQueueBroker(QueueOptions{})), WithQuotas(quotas))
+
+		for _, tc := range []struct {
+			method string
+			target string
+			body   string
+			status int
+		}{
+			{http.MethodPut, AdminBasePath + "/settings?key=App", `{"value": "v"}`, http.StatusForbidden},
+			{http.MethodDelete, AdminBasePath + "/settings?key=App", "", http.StatusForbidden},
+			{http.MethodPut, AdminBasePath + "/archive", `{}`, http.StatusForbidden},
+			{http.MethodPost, AdminBasePath + "/settings/lock?key=App", "", http.StatusForbidden},
+			{http.MethodPut, AdminBasePath + "/featureflags/Beta", `{"enabled": false}`, http.StatusForbidden},
+			{http.MethodPost, AdminBasePath + "/snapshots", `{"name": "s"}`, http.StatusForbidden},
+			{http.MethodPut, "/secrets/db", `{"value": "v"}`, http.StatusForbidden},
+			// Reads, and requests that don't modify the store, are allowed
+			{http.MethodGet, AdminBasePath + "/settings", "", http.StatusOK},
+			{http.MethodGet, AdminBasePath + "/archive", "", http.StatusOK},
+			{http.MethodPost, AdminBasePath + "/featureflags/Beta/evaluate", `{}`, http.StatusOK},
+			{http.MethodPost, AdminBasePath + "/faults", `{"operation": "GetKeyValue", "status": 503}`, http.StatusCreated},
+			{http.MethodDelete, AdminBasePath + "/faults", "", http.StatusNoContent},
+			{http.MethodDelete, AdminBasePath + "/quotas", "", http.StatusNoContent},
+			{http.MethodPost, QueuesBasePath + "/events/messages", `{}`, http.StatusCreated},
+			{http.MethodPost, QueuesBasePath + "/events/messages/head", "", http.StatusCreated},
+			{http.MethodGet, QueuesBasePath, "", http.StatusOK},
+		} {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, tc.status, rec.Code, "%s %s: %s", tc.method, tc.target, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,441 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// The Azure CLI's `az appconfig kv export` and `import` use two file
+// profiles. The default profile is a plain JSON, YAML or .properties
+// configuration file for a single label, with feature flags in a feature
+// management section. The kvset profile is a JSON file of every key-value,
+// feature flags included, with its label, content type and tags.
+
+// featureManagementSections are the names the Azure CLI accepts for the
+// feature management section of a default profile file
+var featureManagementSections = []string{"feature_management", "FeatureManagement", "featureManagement", "Feature_Management"}
+
+// featureFlagsSection holds feature flags in the Microsoft schema, within
+// the feature management section
+const featureFlagsSection = "feature_flags"
+
+// kvSet is the kvset profile file
+type kvSet struct {
+	Items []kvSetItem `json:"items"`
+}
+
+// kvSetItem is one key-value of a kvSet. A nil label or content type is
+// written as JSON null, as the Azure CLI does.
+type kvSetItem struct {
+	Key         string            `json:"key"`
+	Value       *string           `json:"value"`
+	Label       *string           `json:"label"`
+	ContentType *string           `json:"content_type"`
+	Tags        map[string]string `json:"tags"`
+}
+
+func parseKVSet(data []byte) ([]SettingRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var set kvSet
+	err := decoder.Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]SettingRecord, 0, len(set.Items))
+	for i, item := range set.Items {
+		if item.Key == "" {
+			return nil, fmt.Errorf("item %d has no key", i)
+		}
+		if item.Value == nil {
+			return nil, fmt.Errorf("item %s has no value", item.Key)
+		}
+
+		record := SettingRecord{Key: item.Key, Value: *item.Value, Tags: item.Tags}
+		if item.Label != nil {
+			record.Label = *item.Label
+		}
+		if item.ContentType != nil {
+			record.ContentType = *item.ContentType
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// parseDefaultProfile turns a default profile JSON or YAML document into
+// settings: feature flags from its feature management section, and
+// everything else flattened with @param separator.
+func parseDefaultProfile(doc map[string]interface{}, separator string, contentType string) ([]SettingRecord, error) {
+	records := []SettingRecord{}
+
+	settings := make(map[string]interface{}, len(doc))
+	for name, value := range doc {
+		if !slices.Contains(featureManagementSections, name) {
+			settings[name] = value
+			continue
+		}
+
+		flags, err := parseFeatureManagement(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s section", name)
+		}
+
+		for _, flag := range flags {
+			value, err := flag.MarshalValue()
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, SettingRecord{
+				Key:         FeatureFlagKey(flag.Id),
+				Value:       value,
+				ContentType: FeatureFlagContentType,
+			})
+		}
+	}
+
+	flattened, err := flattenSeed(settings, separator, isJsonContentType(contentType))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(flattened, records...), nil
+}
+
+// parseFeatureManagement reads the feature flags of a feature management
+// section, either in the Microsoft schema or in the older .NET schema of
+// flag names mapped to true, false or their filters.
+func parseFeatureManagement(section interface{}) ([]FeatureFlag, error) {
+	sectionMap, ok := section.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object")
+	}
+
+	flags := []FeatureFlag{}
+
+	if list, found := sectionMap[featureFlagsSection]; found {
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&flags)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid feature_flags")
+		}
+
+		for _, flag := range flags {
+			err = flag.Validate()
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid feature flag %s", flag.Id)
+			}
+		}
+
+		return flags, nil
+	}
+
+	names := make([]string, 0, len(sectionMap))
+	for name := range sectionMap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		flag, err := parseDotnetFeatureFlag(name, sectionMap[name])
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+func parseDotnetFeatureFlag(name string, value interface{}) (FeatureFlag, error) {
+	enabled := false
+	flag := FeatureFlag{Id: name, Enabled: &enabled}
+
+	switch v := value.(type) {
+	case bool:
+		enabled = v
+
+	case map[string]interface{}:
+		enabledFor, _ := v["EnabledFor"].([]interface{})
+		for _, f := range enabledFor {
+			filter, ok := f.(map[string]interface{})
+			if !ok {
+				return FeatureFlag{}, fmt.Errorf("invalid filter for feature flag %s", name)
+			}
+
+			filterName, _ := filter["Name"].(string)
+			if strings.EqualFold(filterName, "AlwaysOn") {
+				continue
+			}
+
+			parameters, _ := filter["Parameters"].(map[string]interface{})
+			if flag.Conditions == nil {
+				flag.Conditions = &FeatureFlagConditions{ClientFilters: []FeatureFlagFilter{}}
+			}
+			flag.Conditions.ClientFilters = append(flag.Conditions.ClientFilters, FeatureFlagFilter{
+				Name:       filterName,
+				Parameters: parameters,
+			})
+		}
+		enabled = len(enabledFor) > 0
+
+		if requirementType, ok := v["RequirementType"].(string); ok && flag.Conditions != nil {
+			flag.Conditions.RequirementType = requirementType
+		}
+
+	default:
+		return FeatureFlag{}, fmt.Errorf("feature flag %s must be a boolean or an object", name)
+	}
+
+	err := flag.Validate()
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "invalid feature flag %s", name)
+	}
+
+	return flag, nil
+}
+
+// isJsonContentType reports whether @param contentType is a JSON media type
+// other than the reserved feature flag and Key Vault reference types. As
+// with the Azure CLI, values imported or exported with such a content type
+// are JSON documents rather than strings.
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, featureFlagMediaType) || IsKeyVaultReference(mediaType) {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+////////////////////
+// Export         //
+////////////////////
+
+// ExportOptions control the file ExportSettings writes
+type ExportOptions struct {
+	// Format is SeedFormatRecords, SeedFormatKVSet, or the default profile
+	// formats SeedFormatJSON, SeedFormatYAML and SeedFormatProperties.
+	// Defaults to SeedFormatRecords.
+	Format string
+	// The default profile formats hold a single label, and only the keys
+	// starting with Prefix, which is removed. Keys are nested on Separator,
+	// unless it is empty.
+	Label     string
+	Prefix    string
+	Separator string
+}
+
+func exportKVSet(settings []ConfigSetting, w io.Writer) (int, error) {
+	set := kvSet{Items: []kvSetItem{}}
+	for _, setting := range settings {
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read setting %s", setting.Key)
+		}
+
+		item := kvSetItem{Key: setting.Key, Value: &latest.Value, Tags: latest.Tags}
+		if setting.Label != NullLabel {
+			item.Label = &setting.Label
+		}
+		if latest.ContentType != "" {
+			item.ContentType = &latest.ContentType
+		}
+		if item.Tags == nil {
+			item.Tags = map[string]string{}
+		}
+		set.Items = append(set.Items, item)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(set)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to encode kvset")
+	}
+
+	return len(set.Items), nil
+}
+
+func exportDefaultProfile(settings []ConfigSetting, w io.Writer, opts ExportOptions) (int, error) {
+	doc := map[string]interface{}{}
+	flat := [][2]string{}
+	flags := []FeatureFlag{}
+
+	for _, setting := range settings {
+		if setting.Label != opts.Label {
+			continue
+		}
+
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read setting %s", setting.Key)
+		}
+
+		if IsFeatureFlag(setting.Key, latest.ContentType) {
+			flag, err := ParseFeatureFlag(setting.Key, latest.Value)
+			if err != nil {
+				return 0, err
+			}
+			flags = append(flags, flag)
+			continue
+		}
+
+		key, found := strings.CutPrefix(setting.Key, opts.Prefix)
+		if !found {
+			continue
+		}
+
+		if opts.Format == SeedFormatProperties {
+			flat = append(flat, [2]string{key, latest.Value})
+			continue
+		}
+
+		var value interface{} = latest.Value
+		if isJsonContentType(latest.ContentType) {
+			var decoded interface{}
+			if json.Unmarshal([]byte(latest.Value), &decoded) == nil {
+				value = decoded
+			}
+		}
+
+		err = nestSetting(doc, key, value, opts.Separator)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch opts.Format {
+	case SeedFormatProperties:
+		for _, kv := range flat {
+			_, err := fmt.Fprintf(w, "%s=%s\n", escapeProperty(kv[0], true), escapeProperty(kv[1], false))
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to write properties")
+			}
+		}
+		return len(flat), nil
+
+	case SeedFormatJSON, SeedFormatYAML:
+		count := countLeaves(doc)
+		if len(flags) > 0 {
+			doc[featureManagementSections[0]] = map[string]interface{}{featureFlagsSection: flags}
+			count += len(flags)
+		}
+
+		var err error
+		if opts.Format == SeedFormatJSON {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(doc)
+		} else {
+			// Round trip through JSON so that feature flags use their json field names
+			var encoded []byte
+			encoded, err = json.Marshal(doc)
+			if err == nil {
+				var generic interface{}
+				_ = json.Unmarshal(encoded, &generic)
+				encoder := yaml.NewEncoder(w)
+				encoder.SetIndent(2)
+				err = encoder.Encode(generic)
+			}
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to encode %s", opts.Format)
+		}
+		return count, nil
+	}
+
+	return 0, fmt.Errorf("cannot export to format '%s'", opts.Format)
+}
+
+// nestSetting sets @param key in @param doc, nesting objects on each
+// @param separator in the key.
+func nestSetting(doc map[string]interface{}, key string, value interface{}, separator string) error {
+	segments := []string{key}
+	if separator != "" {
+		segments = strings.Split(key, separator)
+	}
+
+	node := doc
+	for i, segment := range segments {
+		if i == len(segments)-1 {
+			if _, exists := node[segment]; exists {
+				return fmt.Errorf("key %s conflicts with another key when nested on '%s'", key, separator)
+			}
+			node[segment] = value
+			return nil
+		}
+
+		child, exists := node[segment]
+		if !exists {
+			child = map[string]interface{}{}
+			node[segment] = child
+		}
+
+		childMap, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("key %s conflicts with another key when nested on '%s'", key, separator)
+		}
+		node = childMap
+	}
+
+	return nil
+}
+
+func countLeaves(doc map[string]interface{}) int {
+	count := 0
+	for _, value := range doc {
+		if child, ok := value.(map[string]interface{}); ok {
+			count += countLeaves(child)
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case isKey && strings.ContainsRune("=: #!", r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case !isKey && i == 0 && r == ' ':
+			b.WriteString(`\ `)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKVSet = `{
+  "items": [
+    {"key": "App:Name", "value": "demo", "label": null, "content_type": null, "tags": {}},
+    {"key": "App:Name", "value": "demo-prod", "label": "prod", "content_type": "text/plain", "tags": {"owner": "ops"}},
+    {"key": "App:Secret", "value": "{\"uri\":\"https://vault.example/secrets/s1\"}", "label": "prod",
+     "content_type": "application/vnd.microsoft.appconfig.keyvaultref+json;charset=utf-8", "tags": {}},
+    {"key": ".appconfig.featureflag/Beta", "value": "{\"id\":\"Beta\",\"enabled\":true}", "label": "prod",
+     "content_type": "application/vnd.microsoft.appconfig.ff+json;charset=utf-8", "tags": {}}
+  ]
+}`
+
+func TestAzCliKVSet(t *testing.T) {
+	t.Run("kvset round trips", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		// Label and prefix options do not apply to kvset files
+		count, err := ImportSettings(store, strings.NewReader(testKVSet), ImportOptions{
+			Format: SeedFormatKVSet,
+			Label:  "ignored",
+			Prefix: "ignored:",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 4, count)
+
+		setting, err := store.GetLabeledConfigSetting("App:Name", "prod")
+		require.NoError(t, err)
+		require.Equal(t, "ops", setting.Versions[0].Tags["owner"])
+
+		var exported bytes.Buffer
+		count, err = ExportSettings(store, &exported, ExportOptions{Format: SeedFormatKVSet})
+		require.NoError(t, err)
+		require.Equal(t, 4, count)
+
+		var expected, actual kvSet
+		require.NoError(t, json.Unmarshal([]byte(testKVSet), &expected))
+		require.NoError(t, json.Unmarshal(exported.Bytes(), &actual))
+		require.ElementsMatch(t, expected.Items, actual.Items)
+	})
+
+	t.Run("Invalid feature flags are rejected", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = ImportSettings(store, strings.NewReader(`{"items": [{"key": ".appconfig.featureflag/Beta",
+			"value": "{\"id\":\"Beta\"}", "label": null, "content_type": null, "tags": {}}]}`),
+			ImportOptions{Format: SeedFormatKVSet})
+		require.Error(t, err)
+	})
+}
+
+func TestAzCliDefaultProfile(t *testing.T) {
+	t.Run("Feature management sections", func(t *testing.T) {
+		documents := map[string]string{
+			"microsoft schema": `{"feature_management": {"feature_flags": [
+				{"id": "Beta", "enabled": true, "conditions": {"client_filters": [{"name": "Microsoft.Percentage", "parameters": {"Value": 50}}]}},
+				{"id": "Gamma", "enabled": false}
+			]}, "App": {"Name": "demo"}}`,
+			".NET schema": `{"FeatureManagement": {
+				"Beta": {"EnabledFor": [{"Name": "Microsoft.Percentage", "Parameters": {"Value": 50}}]},
+				"Gamma": false
+			}, "App": {"Name": "demo"}}`,
+		}
+
+		for name, document := range documents {
+			store, _, closer, err := makeTestStore(t)
+			require.NoError(t, err, name)
+
+			count, err := ImportSettings(store, strings.NewReader(document), ImportOptions{Format: SeedFormatJSON})
+			require.NoError(t, err, name)
+			require.Equal(t, 3, count, name)
+
+			beta, err := store.GetFeatureFlag("Beta")
+			require.NoError(t, err, name)
+			require.True(t, beta.IsEnabled(), name)
+			require.Equal(t, PercentageFilterName, beta.Conditions.ClientFilters[0].Name, name)
+
+			gamma, err := store.GetFeatureFlag("Gamma")
+			require.NoError(t, err, name)
+			require.False(t, gamma.IsEnabled(), name)
+
+			value, err := store.GetSetting("App:Name")
+			require.NoError(t, err, name)
+			require.Equal(t, "demo", value, name)
+
+			closer()
+		}
+	})
+
+	t.Run("JSON content type keeps values as JSON", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = ImportSettings(store, strings.NewReader(`{"App": {"Name": "demo", "Hosts": ["a", "b"]}}`),
+			ImportOptions{Format: SeedFormatJSON, ContentType: "application/json"})
+		require.NoError(t, err)
+
+		value, err := store.GetSetting("App:Hosts")
+		require.NoError(t, err)
+		require.Equal(t, `["a","b"]`, value)
+
+		value, err = store.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, `"demo"`, value)
+	})
+
+	t.Run("Export a label, nested, with feature flags", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = ImportSettings(store, strings.NewReader(testKVSet), ImportOptions{Format: SeedFormatKVSet})
+		require.NoError(t, err)
+
+		var exported bytes.Buffer
+		count, err := ExportSettings(store, &exported, ExportOptions{
+			Format:    SeedFormatJSON,
+			Label:     "prod",
+			Prefix:    "App:",
+			Separator: ":",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(exported.Bytes(), &doc))
+		require.Equal(t, "demo-prod", doc["Name"])
+		require.Contains(t, doc, "feature_management")
+
+		// The export imports back to the same settings
+		require.NoError(t, store.Reset())
+		_, err = ImportSettings(store, &exported, ImportOptions{Format: SeedFormatJSON, Prefix: "App:", Label: "prod"})
+		require.NoError(t, err)
+
+		setting, err := store.GetLabeledConfigSetting("App:Name", "prod")
+		require.NoError(t, err)
+		require.Equal(t, "demo-prod", setting.Versions[0].Value)
+
+		_, err = store.GetLabeledConfigSetting(FeatureFlagKey("Beta"), "prod")
+		require.NoError(t, err)
+	})
+
+	t.Run("Properties export escapes", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting("app:greeting", "hello\nworld")
+		require.NoError(t, err)
+
+		var exported bytes.Buffer
+		_, err = ExportSettings(store, &exported, ExportOptions{Format: SeedFormatProperties})
+		require.NoError(t, err)
+		require.Equal(t, "app\\:greeting=hello\\nworld\n", exported.String())
+
+		records, err := parseProperties(exported.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, []SettingRecord{{Key: "app:greeting", Value: "hello\nworld"}}, records)
+	})
+}
@@ -0,0 +1,127 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Every change to a setting is numbered, and the number is reported as a
+// sync token, in the same format as App Configuration uses:
+//
+//	<id>=<value>;sn=<sequence number>
+//
+// Every change is also appended to the audit log, from which the sequence
+// carries on when the store is reopened.
+
+const syncTokenId = "aacemu"
+
+type SettingChangeType string
+
+const (
+	SettingModified SettingChangeType = "modified"
+	SettingDeleted  SettingChangeType = "deleted"
+)
+
+// ChangeOperation is the operation that made a change
+type ChangeOperation string
+
+const (
+	ChangeCreate  ChangeOperation = "create"
+	ChangeUpdate  ChangeOperation = "update"
+	ChangeDelete  ChangeOperation = "delete"
+	ChangeLock    ChangeOperation = "lock"
+	ChangeUnlock  ChangeOperation = "unlock"
+	ChangeRestore ChangeOperation = "restore"
+)
+
+// SettingChange describes one change to a setting, as passed to the
+// listeners registered with OnChange
+type SettingChange struct {
+	Type      SettingChangeType
+	Operation ChangeOperation
+	Sequence  int64
+	Key       string
+	Label     string
+	Etag      string
+	// PreviousEtag is the etag of the setting before the change, empty
+	// if it was created
+	PreviousEtag string
+	SyncToken    string
+	Time         time.Time
+	Actor        Actor
+}
+
+type changeListener struct {
+	id int64
+	fn func(SettingChange)
+}
+
+// OnChange registers @param listener to be called after every change to a
+// setting, in the order the changes are made, until the returned function
+// is called. Listeners are called while the store is locked, so must not
+// block or call back into the store.
+func (pcs *persistentConfigStore) OnChange(listener func(SettingChange)) func() {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	pcs.nextListenerId++
+	id := pcs.nextListenerId
+	pcs.listeners = append(pcs.listeners, changeListener{id, listener})
+
+	return func() {
+		pcs.Lock()
+		defer pcs.Unlock()
+
+		pcs.listeners = slices.DeleteFunc(pcs.listeners, func(l changeListener) bool {
+			return l.id == id
+		})
+	}
+}
+
+// SyncToken returns the sync token for the latest change to the store
+func (pcs *persistentConfigStore) SyncToken() string {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return formatSyncToken(pcs.sequence)
+}
+
+// notifyChange numbers a change to @param setting made by @param operation,
+// audits it and passes it to the listeners.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) notifyChange(operation ChangeOperation, setting ConfigSetting, previousEtag string) {
+	pcs.sequence++
+
+	changeType := SettingModified
+	if operation == ChangeDelete {
+		changeType = SettingDeleted
+	}
+
+	change := SettingChange{
+		Type:         changeType,
+		Operation:    operation,
+		Sequence:     pcs.sequence,
+		Key:          setting.Key,
+		Label:        setting.Label,
+		PreviousEtag: previousEtag,
+		SyncToken:    formatSyncToken(pcs.sequence),
+		Time:         time.Now().UTC(),
+		Actor:        pcs.actor,
+	}
+	if latest, err := setting.GetLatest(); err == nil {
+		change.Etag = latest.Uuid
+	}
+
+	pcs.appendAudit(change)
+
+	for _, listener := range pcs.listeners {
+		listener.fn(change)
+	}
+}
+
+func formatSyncToken(sequence int64) string {
+	value := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("0:%d", sequence)))
+	return fmt.Sprintf("%s=%s;sn=%d", syncTokenId, value, sequence)
+}
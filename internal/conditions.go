@@ -0,0 +1,115 @@
+package emulator
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Requests can be made conditional on the etag of the key-value, or list,
+// they address, with If-Match and If-None-Match. Each holds "*", matching
+// whatever exists, or a list of etags, quoted or not. Writes whose
+// condition fails are refused with 412 Precondition Failed; reads are
+// refused the same way when If-Match fails, and answered with 304 Not
+// Modified when If-None-Match matches.
+
+// ErrPreconditionFailed is returned (wrapped) when the If-Match or
+// If-None-Match of a write does not hold
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition is the If-Match and If-None-Match headers of a request, each
+// nil when absent
+type Precondition struct {
+	IfMatch     *string
+	IfNoneMatch *string
+}
+
+// holds returns whether the precondition holds for a resource with
+// @param etag or, if @param exists is false, for one that doesn't exist
+func (cond Precondition) holds(etag string, exists bool) bool {
+	if cond.IfMatch != nil && !(exists && etagsMatch(*cond.IfMatch, etag)) {
+		return false
+	}
+	if cond.IfNoneMatch != nil && exists && etagsMatch(*cond.IfNoneMatch, etag) {
+		return false
+	}
+	return true
+}
+
+// readStatus returns the status of a read of a resource with @param etag:
+// 412 if If-Match does not match it, 304 if If-None-Match does, or 0 to
+// respond as usual
+func (cond Precondition) readStatus(etag string) int {
+	if cond.IfMatch != nil && !etagsMatch(*cond.IfMatch, etag) {
+		return http.StatusPreconditionFailed
+	}
+	if cond.IfNoneMatch != nil && etagsMatch(*cond.IfNoneMatch, etag) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagsMatch returns whether the If-Match or If-None-Match value
+// @param header matches @param etag
+func etagsMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrecondition returns ErrPreconditionFailed, wrapped, unless
+// @param cond holds for the setting identified by @param key and
+// @param label.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) checkPrecondition(key string, label string, cond Precondition) error {
+	if cond.IfMatch == nil && cond.IfNoneMatch == nil {
+		return nil
+	}
+
+	setting, err := pcs.getSetting(key, label)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrSettingNotFound) {
+		return err
+	}
+
+	etag := ""
+	if exists {
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return err
+		}
+		etag = latest.Uuid
+	}
+
+	if !cond.holds(etag, exists) {
+		return errors.Wrap(ErrPreconditionFailed, key)
+	}
+	return nil
+}
+
+// notModifiedResponse answers a read whose If-None-Match matched, with the
+// headers of the resource but no body
+type notModifiedResponse struct {
+	etag      string
+	syncToken string
+}
+
+func (nmr notModifiedResponse) visit(w http.ResponseWriter) error {
+	w.Header().Set("ETag", nmr.etag)
+	w.Header().Set("Sync-Token", nmr.syncToken)
+	w.WriteHeader(http.StatusNotModified)
+	return nil
+}
+
+func (nmr notModifiedResponse) VisitGetKeyValueResponse(w http.ResponseWriter) error {
+	return nmr.visit(w)
+}
+
+func (nmr notModifiedResponse) VisitGetKeyValuesResponse(w http.ResponseWriter) error {
+	return nmr.visit(w)
+}
@@ -11,24 +11,29 @@ import (
 //     Settings     //
 //////////////////////
 
+// NullLabel is the label of settings that were not given one
+const NullLabel = ""
+
+// ConfigSetting is identified by its Key and Label together
 type ConfigSetting struct {
 	Key      string                 `json:"key"`
+	Label    string                 `json:"label"`
 	Versions []ConfigSettingVersion `json:"versions"`
 	Locked   bool                   `json:"locked"`
 }
 
-func NewConfigSettingNow(key string, value string) *ConfigSetting {
+func NewConfigSettingNow(key string, value string, attrs SettingAttributes) *ConfigSetting {
 	setting := ConfigSetting{Key: key}
-	setting.NewVersion(value)
+	setting.NewVersion(value, attrs)
 	return &setting
 }
 
-func (cs *ConfigSetting) NewVersion(value string) error {
+func (cs *ConfigSetting) NewVersion(value string, attrs SettingAttributes) error {
 	// What exactly am I reserving a returned error here for?
 
 	// Actually, *prepend*
 	cs.Versions = append(
-		[]ConfigSettingVersion{NewConfigSettingVersionNow(value)},
+		[]ConfigSettingVersion{NewConfigSettingVersionNow(value, attrs)},
 		cs.Versions...,
 	)
 
@@ -48,17 +53,34 @@ func (cs *ConfigSetting) GetLatest() (ConfigSettingVersion, error) {
 // Setting Versions //
 //////////////////////
 
+// Clover round-trips documents through JSON keyed by Go field names, which
+// only match case-insensitively: json tags must not contain underscores.
 type ConfigSettingVersion struct {
-	Value     string    `json:"value"`
-	Timestamp time.Time `json:"timestamp"`
-	Uuid      string    `json:"uuid"`
+	Value       string            `json:"value"`
+	ContentType string            `json:"contentType"`
+	Tags        map[string]string `json:"tags"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Uuid        string            `json:"uuid"`
+}
+
+// SettingAttributes are the optional, per-version properties of a setting
+type SettingAttributes struct {
+	ContentType string
+	Tags        map[string]string
 }
 
-func NewConfigSettingVersionNow(value string) ConfigSettingVersion {
+func NewConfigSettingVersionNow(value string, attrs SettingAttributes) ConfigSettingVersion {
+	tags := attrs.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
 	return ConfigSettingVersion{
-		Value:     value,
-		Timestamp: time.Now(),
-		Uuid:      uuid.NewString(),
+		Value:       value,
+		ContentType: attrs.ContentType,
+		Tags:        tags,
+		Timestamp:   time.Now(),
+		Uuid:        uuid.NewString(),
 	}
 }
 
@@ -66,7 +88,25 @@ func NewConfigSettingVersionNow(value string) ConfigSettingVersion {
 // Snapshots //
 ///////////////
 
+// Snapshot statuses
+const (
+	SnapshotStatusReady    = "ready"
+	SnapshotStatusArchived = "archived"
+)
+
+// SnapshotFilter selects the settings a snapshot is composed of. Key and
+// Label use the same syntax as the key-value list filters.
+type SnapshotFilter struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
 type ConfigurationSnapshot struct {
-	// Snapshots is a list of single-version copies of this ConfigSetting at the moment the snapshot was
-	Settings []ConfigSetting `json:"snapshots"`
+	Name     string           `json:"name"`
+	Status   string           `json:"status"`
+	Filters  []SnapshotFilter `json:"filters"`
+	Created  time.Time        `json:"created"`
+	Archived time.Time        `json:"archived"`
+	// Settings is a list of single-version copies of each ConfigSetting at the moment the snapshot was taken
+	Settings []ConfigSetting `json:"settings"`
 }
@@ -0,0 +1,282 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Conformance fixtures are recordings of requests made to the real service,
+// in the format written by 'serve --record' (for instance while proxying to
+// a real store). Each fixture file of a directory is replayed against an
+// emulator whose store has been emptied, so a fixture should start from an
+// empty store. Responses are compared as on replay, their etags, sync
+// tokens, request ids and timestamps normalised; the etags the service
+// returned are also replaced in later If-Match and If-None-Match headers
+// with those the emulator returned, and sync tokens are not sent. The
+// responses that match are counted per operation.
+
+// conformanceOperations are the operations of the App Configuration API, by
+// method and route
+var conformanceOperations = map[string]string{
+	"GET /keys":               "GetKeys",
+	"HEAD /keys":              "CheckKeys",
+	"GET /kv":                 "GetKeyValues",
+	"HEAD /kv":                "CheckKeyValues",
+	"GET /kv/{key}":           "GetKeyValue",
+	"HEAD /kv/{key}":          "CheckKeyValue",
+	"PUT /kv/{key}":           "PutKeyValue",
+	"DELETE /kv/{key}":        "DeleteKeyValue",
+	"GET /labels":             "GetLabels",
+	"HEAD /labels":            "CheckLabels",
+	"PUT /locks/{key}":        "PutLock",
+	"DELETE /locks/{key}":     "DeleteLock",
+	"GET /operations":         "GetOperationDetails",
+	"GET /revisions":          "GetRevisions",
+	"HEAD /revisions":         "CheckRevisions",
+	"GET /snapshots":          "GetSnapshots",
+	"HEAD /snapshots":         "CheckSnapshots",
+	"GET /snapshots/{name}":   "GetSnapshot",
+	"HEAD /snapshots/{name}":  "CheckSnapshot",
+	"PUT /snapshots/{name}":   "CreateSnapshot",
+	"PATCH /snapshots/{name}": "UpdateSnapshot",
+}
+
+// conditionalHeaders carry etags, that are replaced on replay
+var conditionalHeaders = []string{"If-Match", "If-None-Match"}
+
+// ConformanceFixture is a recording of requests to the real service
+type ConformanceFixture struct {
+	Name      string
+	Exchanges []RecordedExchange
+}
+
+// ReadConformanceFixtures reads every .jsonl recording in the directory
+// @param dir, in order of name
+func ReadConformanceFixtures(dir string) ([]ConformanceFixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the fixtures in %s", dir)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .jsonl fixtures in %s", dir)
+	}
+	sort.Strings(paths)
+
+	fixtures := make([]ConformanceFixture, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open %s", path)
+		}
+		exchanges, err := ReadRecording(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		fixtures = append(fixtures, ConformanceFixture{Name: filepath.Base(path), Exchanges: exchanges})
+	}
+
+	return fixtures, nil
+}
+
+// ConformanceOptions configure RunConformance
+type ConformanceOptions struct {
+	// Target is the base URL of the emulator to replay against. The
+	// contents of its store are replaced before each fixture.
+	Target string
+	// IgnoredFields are the JSON response body fields, at any depth, not
+	// compared
+	IgnoredFields []string
+	// Client makes the requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// ConformanceResult is the outcome of replaying one exchange of a fixture
+type ConformanceResult struct {
+	ReplayResult
+	Fixture   string
+	Operation string
+}
+
+// ConformanceScore counts the responses of an operation that matched
+type ConformanceScore struct {
+	Operation string `json:"operation"`
+	Matched   int    `json:"matched"`
+	Total     int    `json:"total"`
+}
+
+// Percent is the share of the responses that matched
+func (cs ConformanceScore) Percent() float64 {
+	if cs.Total == 0 {
+		return 0
+	}
+	return 100 * float64(cs.Matched) / float64(cs.Total)
+}
+
+// ConformanceReport is the outcome of replaying every fixture
+type ConformanceReport struct {
+	Results []ConformanceResult
+	// Operations are the scores of each operation, by name
+	Operations []ConformanceScore
+	// Overall is the score of every response
+	Overall ConformanceScore
+}
+
+// RunConformance replays each of @param fixtures against an emptied
+// emulator, and scores how many of the responses match those recorded
+func RunConformance(ctx context.Context, fixtures []ConformanceFixture, opts ConformanceOptions) (ConformanceReport, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	target := strings.TrimSuffix(opts.Target, "/")
+
+	report := ConformanceReport{Results: []ConformanceResult{}, Overall: ConformanceScore{Operation: "overall"}}
+	scores := map[string]*ConformanceScore{}
+	for _, fixture := range fixtures {
+		err := emptyStore(ctx, opts.Client, target)
+		if err != nil {
+			return report, err
+		}
+
+		etags := newEtagPairs()
+		for i, exchange := range fixture.Exchanges {
+			response, err := replayRequest(ctx, opts.Client, target, conformanceRequest(exchange.Request, etags))
+			if err != nil {
+				return report, errors.Wrapf(err, "failed to replay request %d of %s, %s %s",
+					i+1, fixture.Name, exchange.Request.Method, exchange.Request.Path)
+			}
+
+			result := ConformanceResult{
+				ReplayResult: ReplayResult{
+					Exchange:    exchange,
+					Response:    response,
+					Differences: compareResponses(exchange.Response, response, opts.IgnoredFields, etags),
+				},
+				Fixture:   fixture.Name,
+				Operation: conformanceOperation(exchange.Request.Method, exchange.Request.Path),
+			}
+			report.Results = append(report.Results, result)
+
+			score, found := scores[result.Operation]
+			if !found {
+				score = &ConformanceScore{Operation: result.Operation}
+				scores[result.Operation] = score
+			}
+			score.Total++
+			report.Overall.Total++
+			if len(result.Differences) == 0 {
+				score.Matched++
+				report.Overall.Matched++
+			}
+		}
+	}
+
+	for _, score := range scores {
+		report.Operations = append(report.Operations, *score)
+	}
+	sort.Slice(report.Operations, func(i, j int) bool {
+		return report.Operations[i].Operation < report.Operations[j].Operation
+	})
+
+	return report, nil
+}
+
+// emptyStore replaces the store of the emulator at @param target with an
+// empty archive
+func emptyStore(ctx context.Context, client *http.Client, target string) error {
+	archive, err := json.Marshal(Archive{Format: ArchiveFormat, Version: ArchiveVersion})
+	if err != nil {
+		return err
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPut, target+AdminBasePath+"/archive", bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+
+	rs, err := client.Do(rq)
+	if err != nil {
+		return errors.Wrap(err, "failed to empty the emulator's store")
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to empty the emulator's store: status %d", rs.StatusCode)
+	}
+
+	return nil
+}
+
+// conformanceRequest returns @param recorded without its sync tokens, and
+// with the etags of its conditional headers replaced with those paired in
+// @param etags
+func conformanceRequest(recorded RecordedRequest, etags *etagPairs) RecordedRequest {
+	headers := map[string][]string{}
+	for name, values := range recorded.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == "Sync-Token" {
+			continue
+		}
+		values = slices.Clone(values)
+		if slices.Contains(conditionalHeaders, canonical) {
+			for i := range values {
+				values[i] = replaceEtags(values[i], etags)
+			}
+		}
+		headers[name] = values
+	}
+
+	recorded.Headers = headers
+	return recorded
+}
+
+// replaceEtags replaces the etags listed in the conditional header value
+// @param value with those they are paired with in @param etags
+func replaceEtags(value string, etags *etagPairs) string {
+	listed := strings.Split(value, ",")
+	for i, etag := range listed {
+		etag = strings.TrimSpace(etag)
+		replayed, found := etags.replayed[strings.Trim(etag, `"`)]
+		if !found {
+			listed[i] = etag
+			continue
+		}
+		if strings.HasPrefix(etag, `"`) {
+			replayed = `"` + replayed + `"`
+		}
+		listed[i] = replayed
+	}
+	return strings.Join(listed, ", ")
+}
+
+// conformanceOperation names the operation of a request to @param path
+// with @param method
+func conformanceOperation(method string, path string) string {
+	route := path
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(segments) == 2 {
+		switch segments[0] {
+		case "kv", "locks":
+			route = "/" + segments[0] + "/{key}"
+		case "snapshots":
+			route = "/" + segments[0] + "/{name}"
+		}
+	}
+
+	operation, found := conformanceOperations[method+" "+route]
+	if !found {
+		return method + " " + route
+	}
+	return operation
+}
@@ -0,0 +1,191 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	// record writes a fixture named @param name to @param dir, of the
+	// requests @param rqs made to an empty store, as the service would
+	// have responded: with its own etags, sync tokens and timestamps
+	record := func(t *testing.T, dir string, name string, rqs ...*http.Request) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		var recording bytes.Buffer
+		gin.SetMode(gin.TestMode)
+		engine := SetupRestServer(store, WithRecorder(NewTrafficRecorder(&recording)))
+		for _, rq := range rqs {
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(httptest.NewRecorder(), rq)
+		}
+
+		exchanges, err := ReadRecording(&recording)
+		require.NoError(t, err)
+
+		var fixture bytes.Buffer
+		enc := json.NewEncoder(&fixture)
+		etags := []string{}
+		for i, exchange := range exchanges {
+			headers := http.Header(exchange.Response.Headers)
+			if etag := strings.Trim(headers.Get("ETag"), `"`); etag != "" && !slices.Contains(etags, etag) {
+				etags = append(etags, etag)
+			}
+			for _, etag := range etags {
+				headers.Set("ETag", strings.ReplaceAll(headers.Get("ETag"), etag, "service-"+etag))
+				exchange.Response.Body = strings.ReplaceAll(exchange.Response.Body, etag, "service-"+etag)
+			}
+			headers.Set("Sync-Token", fmt.Sprintf("zAJw6V16=MDoyOCMzMzg5MDU=;sn=%d", 338905+i))
+
+			var body map[string]interface{}
+			if json.Unmarshal([]byte(exchange.Response.Body), &body) == nil && body["last_modified"] != nil {
+				body["last_modified"] = "2024-05-01T10:00:00+00:00"
+				rewritten, err := json.Marshal(body)
+				require.NoError(t, err)
+				exchange.Response.Body = string(rewritten)
+			}
+			require.NoError(t, enc.Encode(exchange))
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), fixture.Bytes(), 0644))
+	}
+
+	request := func(method string, target string, body string) *http.Request {
+		return httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+
+	target := func(t *testing.T) string {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		// The store is emptied before each fixture
+		_, err = store.UpdateSetting("App:Leftover", "x")
+		require.NoError(t, err)
+
+		server := httptest.NewServer(SetupRestServer(store))
+		t.Cleanup(func() {
+			server.Close()
+			closer()
+		})
+		return server.URL
+	}
+
+	fixtures := func(t *testing.T) string {
+		dir := t.TempDir()
+		record(t, dir, "01-set.jsonl",
+			request(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "demo"}`),
+			request(http.MethodGet, "/kv/App:Name?api-version=2023-10-01", ""),
+			request(http.MethodGet, "/kv?key=App:*&api-version=2023-10-01", ""),
+		)
+		record(t, dir, "02-lock.jsonl",
+			request(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "demo"}`),
+			request(http.MethodPut, "/locks/App:Name?api-version=2023-10-01", ""),
+			request(http.MethodGet, "/kv/App:Name?api-version=2023-10-01", ""),
+		)
+		return dir
+	}
+
+	t.Run("Fixtures are read in order of name", func(t *testing.T) {
+		dir := fixtures(t)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a fixture"), 0644))
+
+		read, err := ReadConformanceFixtures(dir)
+		require.NoError(t, err)
+		require.Len(t, read, 2)
+		require.Equal(t, "01-set.jsonl", read[0].Name)
+		require.Len(t, read[0].Exchanges, 3)
+		require.Equal(t, "02-lock.jsonl", read[1].Name)
+
+		_, err = ReadConformanceFixtures(t.TempDir())
+		require.Error(t, err)
+	})
+
+	t.Run("Matching responses score every operation in full", func(t *testing.T) {
+		read, err := ReadConformanceFixtures(fixtures(t))
+		require.NoError(t, err)
+
+		report, err := RunConformance(context.Background(), read, ConformanceOptions{Target: target(t)})
+		require.NoError(t, err)
+		require.Len(t, report.Results, 6)
+		for _, result := range report.Results {
+			require.Empty(t, result.Differences, "%s %s %s", result.Fixture, result.Exchange.Request.Method, result.Exchange.Request.Path)
+		}
+		require.Equal(t, []ConformanceScore{
+			{Operation: "GetKeyValue", Matched: 2, Total: 2},
+			{Operation: "GetKeyValues", Matched: 1, Total: 1},
+			{Operation: "PutKeyValue", Matched: 2, Total: 2},
+			{Operation: "PutLock", Matched: 1, Total: 1},
+		}, report.Operations)
+		require.Equal(t, 100.0, report.Overall.Percent())
+	})
+
+	t.Run("Differing responses lower the score of their operation", func(t *testing.T) {
+		read, err := ReadConformanceFixtures(fixtures(t))
+		require.NoError(t, err)
+		read[1].Exchanges[2].Response.Status = http.StatusNotFound
+
+		report, err := RunConformance(context.Background(), read, ConformanceOptions{Target: target(t)})
+		require.NoError(t, err)
+		require.Equal(t, "02-lock.jsonl", report.Results[5].Fixture)
+		require.Equal(t, []string{"status: recorded 404, replayed 200"}, report.Results[5].Differences)
+		require.Equal(t, ConformanceScore{Operation: "GetKeyValue", Matched: 1, Total: 2}, report.Operations[0])
+		require.Equal(t, 50.0, report.Operations[0].Percent())
+		require.Equal(t, ConformanceScore{Operation: "overall", Matched: 5, Total: 6}, report.Overall)
+	})
+
+	t.Run("Etags are replaced, and sync tokens dropped", func(t *testing.T) {
+		etags := newEtagPairs()
+		require.Empty(t, compareResponses(
+			RecordedResponse{Headers: map[string][]string{"Etag": {`"a"`}}, Body: `{"items": [{"etag": "b"}, {"etag": "c"}]}`},
+			RecordedResponse{Headers: map[string][]string{"Etag": {`"x"`}}, Body: `{"items": [{"etag": "y"}, {"etag": "z"}]}`},
+			nil, etags,
+		))
+		require.Equal(t, map[string]string{"a": "x", "b": "y", "c": "z"}, etags.replayed)
+
+		// Bodies that differ can't be paired
+		compareResponses(RecordedResponse{Body: `{"items": [{"etag": "d"}]}`}, RecordedResponse{Body: `{"items": []}`}, nil, etags)
+		require.NotContains(t, etags.replayed, "d")
+
+		rq := conformanceRequest(RecordedRequest{Headers: map[string][]string{
+			"If-Match":      {`"b", "unknown"`},
+			"If-None-Match": {"*"},
+			"Sync-Token":    {"zAJw6V16=NDo1IzA=;sn=1"},
+			"Accept":        {"application/json"},
+		}}, etags)
+		require.Equal(t, map[string][]string{
+			"If-Match":      {`"y", "unknown"`},
+			"If-None-Match": {"*"},
+			"Accept":        {"application/json"},
+		}, rq.Headers)
+	})
+
+	t.Run("Requests are named after their operation", func(t *testing.T) {
+		for _, tc := range []struct {
+			method    string
+			path      string
+			operation string
+		}{
+			{http.MethodGet, "/kv", "GetKeyValues"},
+			{http.MethodHead, "/kv/App%2FName", "CheckKeyValue"},
+			{http.MethodDelete, "/locks/App:Name", "DeleteLock"},
+			{http.MethodPatch, "/snapshots/release", "UpdateSnapshot"},
+			{http.MethodGet, "/revisions", "GetRevisions"},
+			{http.MethodPost, "/kv", "POST /kv"},
+		} {
+			require.Equal(t, tc.operation, conformanceOperation(tc.method, tc.path))
+		}
+	})
+}
@@ -3,59 +3,251 @@ package emulator
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
 	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
 )
 
 var (
-	noddyEtag        = "1234567"
 	emptyString      = ""
 	addressableFalse = false
 	latestLabel      = "latest"
 )
 
-func SetupRestServer(configStore persistentConfigStore) *gin.Engine {
-	restServer := NewRestServer(configStore)
-	restEngine := gin.Default()
+func SetupRestServer(configStore *persistentConfigStore, opts ...RestServerOption) *gin.Engine {
+	restServer := newRestServer(configStore, opts...)
+	metrics := newRequestMetrics(configStore)
+	restEngine := gin.New()
+	restEngine.Use(requestLogMiddleware, metrics.middleware)
+	if restServer.tracer != nil {
+		restEngine.Use(restServer.tracer.middleware)
+	}
+	if restServer.recorder != nil {
+		restEngine.Use(restServer.recorder.middleware)
+	}
+	restEngine.Use(gin.Recovery())
+	if restServer.proxy != nil {
+		restEngine.Use(restServer.proxyGuardMiddleware, restServer.proxy.middleware)
+	}
+	// Keys may contain '/', which clients send escaped: route on the raw path
+	restEngine.UseRawPath = true
 	restServer.RegisterToGin(&restEngine.RouterGroup)
+
+	// Read-only mode covers every route that modifies the store. HMAC
+	// authentication covers the routes App Configuration clients call, but
+	// not the admin API, which is the operator's and is used from a browser
+	// that can't sign requests, nor the secrets API, whose Key Vault clients
+	// send bearer tokens, nor the metrics endpoint.
+	admin := restEngine.Group(AdminBasePath, func(c *gin.Context) {
+		c.Set(principalContextKey, adminPrincipal)
+	}, restServer.readOnlyRouteMiddleware)
+	registerAdminRoutes(admin, configStore)
+	registerFaultRoutes(admin, restServer.faults)
+	if restServer.quotas != nil {
+		registerQuotaRoutes(admin, restServer.quotas)
+	}
+	registerWatchRoutes(restEngine.Group("", restServer.authMiddlewares()...), configStore)
+	registerMetricsRoutes(&restEngine.RouterGroup, metrics)
+	if restServer.keyVault {
+		registerKeyVaultRoutes(restEngine.Group("", restServer.readOnlyRouteMiddleware), configStore)
+	}
+	if restServer.queues != nil {
+		registerQueueRoutes(restEngine.Group(QueuesBasePath, restServer.authMiddlewares()...), restServer.queues)
+	}
 	return restEngine
 }
 
 type appConfigRestServer struct {
-	configStore persistentConfigStore
+	configStore *persistentConfigStore
+	apiVersions apiVersions
+	keyVault    bool
+	queues      *QueueBroker
+	tracer      *Tracer
+	recorder    *TrafficRecorder
+	proxy       *UpstreamProxy
+	faults      *FaultInjector
+	quotas      *QuotaEnforcer
+	readOnly    bool
+	credentials []Credential
+}
+
+// RestServerOption configures optional behaviour of the REST server
+type RestServerOption func(*appConfigRestServer)
+
+// WithApiVersions sets the api-version values the server accepts.
+// Defaults to DefaultApiVersions.
+func WithApiVersions(versions []string) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.apiVersions = newApiVersions(versions)
+	}
+}
+
+// WithReadOnly rejects every operation that would modify the store, as the
+// service does for requests made with a read-only access key.
+func WithReadOnly() RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.readOnly = true
+	}
+}
+
+// WithHmacAuth requires requests to be HMAC signed with one of @param credentials
+func WithHmacAuth(credentials []Credential) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.credentials = credentials
+	}
+}
+
+// WithKeyVault additionally serves a minimal Key Vault secrets API, so that
+// Key Vault references can be resolved against the emulator.
+func WithKeyVault() RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.keyVault = true
+	}
+}
+
+// WithQueues additionally serves the queues of @param broker, as a stand-in
+// for Service Bus.
+func WithQueues(broker *QueueBroker) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.queues = broker
+	}
+}
+
+// WithTracing records a span for each request, and for the store calls it
+// makes, with @param tracer
+func WithTracing(tracer *Tracer) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.tracer = tracer
+	}
+}
+
+// WithRecorder records every request, and its response, with @param recorder
+func WithRecorder(recorder *TrafficRecorder) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.recorder = recorder
+	}
+}
+
+// WithProxy forwards the App Configuration API to an upstream store with
+// @param proxy, instead of serving it from the local store
+func WithProxy(proxy *UpstreamProxy) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.proxy = proxy
+	}
+}
+
+// WithQuotas throttles requests over the limits enforced by @param quotas
+func WithQuotas(quotas *QuotaEnforcer) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		rs.quotas = quotas
+	}
+}
+
+// WithFaultRules starts the server injecting the faults of @param rules.
+// Rules can also be managed at runtime through the admin API.
+func WithFaultRules(rules []FaultRule) RestServerOption {
+	return func(rs *appConfigRestServer) {
+		for _, rule := range rules {
+			_, err := rs.faults.AddRule(rule)
+			if err != nil {
+				slog.Error("ignoring invalid fault rule", "id", rule.Id, "error", err)
+			}
+		}
+	}
 }
 
 // CreateSnapshot implements appconfig.StrictServerInterface.
+// Snapshots are composed synchronously, so the operation has already
+// succeeded when the response is sent.
 func (rs *appConfigRestServer) CreateSnapshot(ctx context.Context, request ogen.CreateSnapshotRequestObject) (ogen.CreateSnapshotResponseObject, error) {
-	panic("unimplemented")
+	body := request.JSONBody
+	if body == nil {
+		body = request.ApplicationVndMicrosoftAppconfigSnapshotPlusJSONBody
+	}
+	if body == nil || len(body.Filters) == 0 {
+		return ogen.CreateSnapshotdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "filters", "at least one filter is required"),
+		}, nil
+	}
+
+	filters := make([]SnapshotFilter, 0, len(body.Filters))
+	for _, f := range body.Filters {
+		filter := SnapshotFilter{Key: f.Key}
+		if f.Label != nil {
+			filter.Label = *f.Label
+		}
+		filters = append(filters, filter)
+	}
+
+	span := startStoreSpan(ctx, "CreateSnapshot")
+	snapshot, err := rs.configStore.CreateSnapshot(request.Name, filters)
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.CreateSnapshotdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	response := snapshotToSnapshot(snapshot, body.CompositionType)
+	return ogen.CreateSnapshot201JSONResponse{
+		Headers: ogen.CreateSnapshot201ResponseHeaders{
+			ETag:              *response.Etag,
+			OperationLocation: operationLocation(ctx, request.Name, request.Params.ApiVersion),
+			SyncToken:         rs.configStore.SyncToken(),
+		},
+		Body: response,
+	}, nil
 }
 
 // DeleteKeyValue implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) DeleteKeyValue(ctx context.Context, request ogen.DeleteKeyValueRequestObject) (ogen.DeleteKeyValueResponseObject, error) {
-	if request.Params.Label != nil {
-		if *request.Params.Label != "" {
-			return nil, fmt.Errorf("label filtering for DeleteKeyValue is unimplemented")
-		}
+	// The response describes the key-value as it was before the delete
+	span := startStoreSpan(ctx, "GetLabeledConfigSetting")
+	setting, err := rs.configStore.GetLabeledConfigSetting(request.Key, labelParam(request.Params.Label))
+	endStoreSpan(span, err)
+	if err == nil || errors.Is(err, ErrSettingNotFound) {
+		// Deleting a key-value that doesn't exist fails its If-Match
+		span = startStoreSpan(ctx, "DeleteLabeledSetting")
+		err = rs.configStore.As(requestActor(ctx)).If(Precondition{IfMatch: request.Params.IfMatch}).
+			DeleteLabeledSetting(request.Key, labelParam(request.Params.Label))
+		endStoreSpan(span, err)
+	}
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.DeleteKeyValuedefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
-	err := rs.configStore.DeleteSetting(request.Key)
+	body, err := settingToKeyValue(setting)
 	if err != nil {
-		// TODO: Wrap err
-		return nil, err
+		return nil, errors.Wrapf(err, "failed to marshal response body")
 	}
 
-	return ogen.DeleteKeyValue200JSONResponse{}, nil
+	return ogen.DeleteKeyValue200JSONResponse{
+		Headers: ogen.DeleteKeyValue200ResponseHeaders{
+			ETag:      *body.Etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: body,
+	}, nil
 }
 
 // DeleteLock implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) DeleteLock(ctx context.Context, request ogen.DeleteLockRequestObject) (ogen.DeleteLockResponseObject, error) {
-	setting, err := rs.configStore.UnlockSetting(request.Key)
+	span := startStoreSpan(ctx, "UnlockLabeledSetting")
+	setting, err := rs.configStore.As(requestActor(ctx)).If(Precondition{IfMatch: request.Params.IfMatch, IfNoneMatch: request.Params.IfNoneMatch}).
+		UnlockLabeledSetting(request.Key, labelParam(request.Params.Label))
+	endStoreSpan(span, err)
 	if err != nil {
-		return ogen.DeleteLock200JSONResponse{}, errors.Wrapf(err, "failed to lock setting %s", request.Key)
+		status, problem := storeErrorProblem(err)
+		return ogen.DeleteLockdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
 	body, err := settingToKeyValue(setting)
@@ -64,25 +256,48 @@ func (rs *appConfigRestServer) DeleteLock(ctx context.Context, request ogen.Dele
 	}
 
 	return ogen.DeleteLock200JSONResponse{
-		Headers: ogen.DeleteLock200ResponseHeaders{},
-		Body:    body,
+		Headers: ogen.DeleteLock200ResponseHeaders{
+			ETag:      *body.Etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: body,
 	}, nil
 }
 
 // PutKeyValue implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) PutKeyValue(ctx context.Context, request ogen.PutKeyValueRequestObject) (ogen.PutKeyValueResponseObject, error) {
 	key := request.Key
-	value := request.JSONBody.Value
-	if value == nil {
-		return ogen.PutKeyValue200JSONResponse{}, fmt.Errorf("nil value for PutKeyValue")
+	kv := putKeyValueBody(request)
+	if kv == nil || kv.Value == nil {
+		return ogen.PutKeyValuedefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "value", "the value is required"),
+		}, nil
+	}
+
+	attrs := SettingAttributes{}
+	if kv.ContentType != nil {
+		attrs.ContentType = *kv.ContentType
+	}
+	if kv.Tags != nil {
+		attrs.Tags = *kv.Tags
 	}
 
-	// lastModified := time.Now()
-	// tags := map[string]string{}
+	title, err := validateSettingValue(key, *kv.Value, attrs.ContentType)
+	if err != nil {
+		return ogen.PutKeyValuedefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, title, "value", err.Error()),
+		}, nil
+	}
 
-	setting, err := rs.configStore.UpdateSetting(key, *value)
+	span := startStoreSpan(ctx, "UpdateLabeledSetting")
+	setting, err := rs.configStore.As(requestActor(ctx)).If(Precondition{IfMatch: request.Params.IfMatch, IfNoneMatch: request.Params.IfNoneMatch}).
+		UpdateLabeledSetting(key, labelParam(request.Params.Label), *kv.Value, attrs)
+	endStoreSpan(span, err)
 	if err != nil {
-		return ogen.PutKeyValue200JSONResponse{}, errors.Wrapf(err, "failed to add new value to setting: %s", key)
+		status, problem := storeErrorProblem(err)
+		return ogen.PutKeyValuedefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
 	body, err := settingToKeyValue(setting)
@@ -91,16 +306,61 @@ func (rs *appConfigRestServer) PutKeyValue(ctx context.Context, request ogen.Put
 	}
 
 	return ogen.PutKeyValue200JSONResponse{
-		Headers: ogen.PutKeyValue200ResponseHeaders{},
-		Body:    body,
+		Headers: ogen.PutKeyValue200ResponseHeaders{
+			ETag:      *body.Etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: body,
 	}, nil
 }
 
+// validateSettingValue checks the values of the key-values that have a
+// reserved content type. On failure, the returned title describes the problem.
+func validateSettingValue(key string, value string, contentType string) (string, error) {
+	if IsFeatureFlag(key, contentType) {
+		_, err := ParseFeatureFlag(key, value)
+		if err != nil {
+			return "Invalid feature flag", err
+		}
+	}
+
+	if IsKeyVaultReference(contentType) {
+		_, err := ParseKeyVaultReference(value)
+		if err != nil {
+			return "Invalid Key Vault reference", err
+		}
+	}
+
+	return "", nil
+}
+
+// putKeyValueBody returns whichever of the request bodies was bound,
+// depending on the Content-Type the client sent.
+func putKeyValueBody(request ogen.PutKeyValueRequestObject) *ogen.KeyValue {
+	for _, body := range []*ogen.KeyValue{
+		request.JSONBody,
+		request.ApplicationVndMicrosoftAppconfigKvPlusJSONBody,
+		request.ApplicationWildcardPlusJSONBody,
+		request.ApplicationJSONPatchPlusJSONBody,
+		request.ApplicationVndMicrosoftAppconfigKvsetPlusJSONBody,
+	} {
+		if body != nil {
+			return body
+		}
+	}
+
+	return nil
+}
+
 // PutLock implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) PutLock(ctx context.Context, request ogen.PutLockRequestObject) (ogen.PutLockResponseObject, error) {
-	setting, err := rs.configStore.LockSetting(request.Key)
+	span := startStoreSpan(ctx, "LockLabeledSetting")
+	setting, err := rs.configStore.As(requestActor(ctx)).If(Precondition{IfMatch: request.Params.IfMatch, IfNoneMatch: request.Params.IfNoneMatch}).
+		LockLabeledSetting(request.Key, labelParam(request.Params.Label))
+	endStoreSpan(span, err)
 	if err != nil {
-		return ogen.PutLock200JSONResponse{}, errors.Wrapf(err, "failed to lock setting %s", request.Key)
+		status, problem := storeErrorProblem(err)
+		return ogen.PutLockdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
 	body, err := settingToKeyValue(setting)
@@ -109,77 +369,162 @@ func (rs *appConfigRestServer) PutLock(ctx context.Context, request ogen.PutLock
 	}
 
 	return ogen.PutLock200JSONResponse{
-		Headers: ogen.PutLock200ResponseHeaders{},
-		Body:    body,
+		Headers: ogen.PutLock200ResponseHeaders{
+			ETag:      *body.Etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: body,
 	}, nil
 }
 
 // UpdateSnapshot implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) UpdateSnapshot(ctx context.Context, request ogen.UpdateSnapshotRequestObject) (ogen.UpdateSnapshotResponseObject, error) {
-	panic("unimplemented")
+	body := request.JSONBody
+	if body == nil {
+		body = request.ApplicationMergePatchPlusJSONBody
+	}
+	if body == nil || body.Status == nil {
+		return ogen.UpdateSnapshotdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "status", "a status is required"),
+		}, nil
+	}
+
+	var snapshot ConfigurationSnapshot
+	var err error
+	span := startStoreSpan(ctx, "UpdateSnapshot")
+	switch *body.Status {
+	case ogen.SnapshotStatusArchived:
+		snapshot, err = rs.configStore.ArchiveSnapshot(request.Name)
+	case ogen.SnapshotStatusReady:
+		snapshot, err = rs.configStore.RecoverSnapshot(request.Name)
+	default:
+		span.End()
+		return ogen.UpdateSnapshotdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body: newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "status",
+				fmt.Sprintf("a snapshot can't be updated to status '%s'", *body.Status)),
+		}, nil
+	}
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.UpdateSnapshotdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	response := snapshotToSnapshot(snapshot, nil)
+	return ogen.UpdateSnapshot200JSONResponse{
+		Headers: ogen.UpdateSnapshot200ResponseHeaders{
+			ETag:      *response.Etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: response,
+	}, nil
 }
 
 // CheckKeyValue implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) CheckKeyValue(ctx context.Context, request ogen.CheckKeyValueRequestObject) (ogen.CheckKeyValueResponseObject, error) {
-	panic("unimplemented")
+	panic(unimplementedPanic)
 }
 
 // CheckKeyValues implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) CheckKeyValues(ctx context.Context, request ogen.CheckKeyValuesRequestObject) (ogen.CheckKeyValuesResponseObject, error) {
-	panic("unimplemented")
+	panic(unimplementedPanic)
 }
 
 // CheckKeys implements appconfig.StrictServerInterface.
+// It responds with the headers of GetKeys.
 func (rs *appConfigRestServer) CheckKeys(ctx context.Context, request ogen.CheckKeysRequestObject) (ogen.CheckKeysResponseObject, error) {
-	panic("unimplemented")
+	response, err := rs.GetKeys(ctx, ogen.GetKeysRequestObject{Params: ogen.GetKeysParams{
+		ApiVersion: request.Params.ApiVersion,
+		Name:       request.Params.Name,
+		After:      request.Params.After,
+	}})
+	switch response := response.(type) {
+	case ogen.GetKeys200JSONResponse:
+		return ogen.CheckKeys200Response{Headers: ogen.CheckKeys200ResponseHeaders(response.Headers)}, nil
+	case ogen.GetKeysdefaultApplicationProblemPlusJSONResponse:
+		return ogen.CheckKeysdefaultJSONResponse{StatusCode: response.StatusCode, Body: response.Body}, nil
+	}
+	return nil, err
 }
 
 // CheckLabels implements appconfig.StrictServerInterface.
+// It responds with the headers of GetLabels.
 func (rs *appConfigRestServer) CheckLabels(ctx context.Context, request ogen.CheckLabelsRequestObject) (ogen.CheckLabelsResponseObject, error) {
-	panic("unimplemented")
+	response, err := rs.GetLabels(ctx, ogen.GetLabelsRequestObject{Params: ogen.GetLabelsParams{
+		ApiVersion: request.Params.ApiVersion,
+		Name:       request.Params.Name,
+		After:      request.Params.After,
+	}})
+	switch response := response.(type) {
+	case ogen.GetLabels200JSONResponse:
+		return ogen.CheckLabels200Response{Headers: ogen.CheckLabels200ResponseHeaders(response.Headers)}, nil
+	case ogen.GetLabelsdefaultApplicationProblemPlusJSONResponse:
+		return ogen.CheckLabelsdefaultJSONResponse{StatusCode: response.StatusCode, Body: response.Body}, nil
+	}
+	return nil, err
 }
 
 // CheckRevisions implements appconfig.StrictServerInterface.
+// It responds with the headers of GetRevisions.
 func (rs *appConfigRestServer) CheckRevisions(ctx context.Context, request ogen.CheckRevisionsRequestObject) (ogen.CheckRevisionsResponseObject, error) {
-	panic("unimplemented")
+	response, err := rs.GetRevisions(ctx, ogen.GetRevisionsRequestObject{Params: ogen.GetRevisionsParams{
+		ApiVersion: request.Params.ApiVersion,
+		Key:        request.Params.Key,
+		Label:      request.Params.Label,
+		After:      request.Params.After,
+	}})
+	switch response := response.(type) {
+	case ogen.GetRevisions200JSONResponse:
+		return ogen.CheckRevisions200Response{Headers: ogen.CheckRevisions200ResponseHeaders(response.Headers)}, nil
+	case ogen.GetRevisionsdefaultApplicationProblemPlusJSONResponse:
+		return ogen.CheckRevisionsdefaultJSONResponse{StatusCode: response.StatusCode, Body: response.Body}, nil
+	}
+	return nil, err
 }
 
 // CheckSnapshot implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) CheckSnapshot(ctx context.Context, request ogen.CheckSnapshotRequestObject) (ogen.CheckSnapshotResponseObject, error) {
-	panic("unimplemented")
+	panic(unimplementedPanic)
 }
 
 // CheckSnapshots implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) CheckSnapshots(ctx context.Context, request ogen.CheckSnapshotsRequestObject) (ogen.CheckSnapshotsResponseObject, error) {
-	panic("unimplemented")
+	panic(unimplementedPanic)
 }
 
 // GetKeyValue implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) GetKeyValue(ctx context.Context, request ogen.GetKeyValueRequestObject) (ogen.GetKeyValueResponseObject, error) {
+	span := startStoreSpan(ctx, "GetLabeledConfigSetting")
+	setting, err := rs.configStore.GetLabeledConfigSetting(request.Key, labelParam(request.Params.Label))
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetKeyValuedefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
 
-	lastModified := time.Now()
-	tags := map[string]string{}
-
-	value, err := rs.configStore.GetSetting(request.Key)
+	response, err := settingToKeyValue(setting)
 	if err != nil {
-		// TODO: Wrap err
-		return nil, err
-	}
-
-	response := ogen.KeyValue{
-		Key:          &request.Key,
-		Value:        &value,
-		Etag:         &noddyEtag,
-		Label:        &latestLabel,
-		LastModified: &lastModified,
-		ContentType:  &emptyString,
-		Locked:       &addressableFalse,
-		Tags:         &tags,
+		return nil, errors.Wrapf(err, "failed to marshal response body")
+	}
+
+	cond := Precondition{IfMatch: request.Params.IfMatch, IfNoneMatch: request.Params.IfNoneMatch}
+	switch cond.readStatus(*response.Etag) {
+	case http.StatusNotModified:
+		return notModifiedResponse{etag: *response.Etag, syncToken: rs.configStore.SyncToken()}, nil
+	case http.StatusPreconditionFailed:
+		status, problem := storeErrorProblem(errors.Wrap(ErrPreconditionFailed, request.Key))
+		return ogen.GetKeyValuedefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
 	resp := ogen.GetKeyValue200JSONResponse{
-		Headers: ogen.GetKeyValue200ResponseHeaders{},
-		Body:    response,
+		Headers: ogen.GetKeyValue200ResponseHeaders{
+			ETag:         *response.Etag,
+			SyncToken:    rs.configStore.SyncToken(),
+			XMsRequestId: responseRequestId(ctx),
+		},
+		Body: response,
 	}
 
 	return resp, nil
@@ -187,108 +532,417 @@ func (rs *appConfigRestServer) GetKeyValue(ctx context.Context, request ogen.Get
 
 // GetKeyValues implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) GetKeyValues(ctx context.Context, request ogen.GetKeyValuesRequestObject) (ogen.GetKeyValuesResponseObject, error) {
-	etag := "1234567"
-	label := "latest"
-	lastModified := time.Now()
-	tags := map[string]string{}
-	nextLink := ""
+	filter, labelFilter, name, err := newKeyValueFilters(request.Params.Key, request.Params.Label)
+	if err != nil {
+		return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", name, err.Error()),
+		}, nil
+	}
 
-	var filter Filter
-	var err error
+	var settings []ConfigSetting
+	if request.Params.Snapshot != nil {
+		// The SDKs send empty key and label filters with the snapshot
+		if (request.Params.Key != nil && *request.Params.Key != "") || (request.Params.Label != nil && *request.Params.Label != "") {
+			return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{
+				StatusCode: http.StatusBadRequest,
+				Body: newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "snapshot",
+					"the snapshot filter can't be combined with key or label filters"),
+			}, nil
+		}
 
-	// Real filter or null filter?
-	if request.Params.Key != nil && *request.Params.Key != "" {
-		filter, err = newFilter(*request.Params.Key)
+		span := startStoreSpan(ctx, "GetSnapshot")
+		snapshot, err := rs.configStore.GetSnapshot(*request.Params.Snapshot)
+		endStoreSpan(span, err)
 		if err != nil {
-			// TODO: Wrap err
-			return nil, err
+			status, problem := storeErrorProblem(err)
+			return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 		}
+		settings = snapshot.Settings
 	} else {
-		filter = nullFilter{}
+		span := startStoreSpan(ctx, "GetSettings")
+		settings, err = rs.configStore.GetSettings()
+		endStoreSpan(span, err)
+		if err != nil {
+			status, problem := storeErrorProblem(err)
+			return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+		}
 	}
 
-	keys, err := rs.configStore.GetKeys()
+	matched := []ConfigSetting{}
+	for _, setting := range settings {
+		if filter.Apply(setting.Key) && labelFilter.Apply(setting.Label) {
+			matched = append(matched, setting)
+		}
+	}
+
+	page, after, err := pageSettings(matched, request.Params.After)
 	if err != nil {
-		// TODO: Wrap err
-		return nil, err
+		return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", afterParam, err.Error()),
+		}, nil
 	}
 
 	values := []ogen.KeyValue{}
+	for _, setting := range page {
+		kv, err := settingToKeyValue(setting)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to marshal response body")
+		}
 
-	for _, key := range keys {
-		if filter.Apply(key) {
-			value, err := rs.configStore.GetSetting(key)
-			if err != nil {
-				// TODO: Wrap err
-				return nil, err
-			}
-
-			kv := ogen.KeyValue{
-				Key:          &key,
-				Value:        &value,
-				Etag:         &etag,
-				Label:        &label,
-				LastModified: &lastModified,
-				ContentType:  &emptyString,
-				Locked:       &addressableFalse,
-				Tags:         &tags,
-			}
+		values = append(values, kv)
+	}
 
-			values = append(values, kv)
-		}
+	etag := listEtag(values)
+	cond := Precondition{IfMatch: request.Params.IfMatch, IfNoneMatch: request.Params.IfNoneMatch}
+	switch cond.readStatus(etag) {
+	case http.StatusNotModified:
+		return notModifiedResponse{etag: etag, syncToken: rs.configStore.SyncToken()}, nil
+	case http.StatusPreconditionFailed:
+		status, problem := storeErrorProblem(ErrPreconditionFailed)
+		return ogen.GetKeyValuesdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
 	}
 
 	resp := ogen.GetKeyValues200JSONResponse{
 		Headers: ogen.GetKeyValues200ResponseHeaders{
 			ETag:      etag,
-			SyncToken: "jtqGc1I4=MDoyOA==;sn=1",
+			SyncToken: rs.configStore.SyncToken(),
 		},
 		Body: ogen.KeyValueListResult{
-			Items:    &values,
-			NextLink: &nextLink,
-			Etag:     &etag,
+			Items: &values,
+			Etag:  &etag,
 		},
 	}
+	if after != "" {
+		link := nextLink(ctx, after)
+		resp.Body.NextLink = &link
+	}
 
 	return resp, nil
 }
 
+// newKeyValueFilters builds the Filters for the optional key and label
+// query parameters @param key and @param label. On failure, it also returns
+// the name of the parameter at fault.
+func newKeyValueFilters(key *string, label *string) (Filter, Filter, string, error) {
+	var keyFilter Filter = nullFilter{}
+	if key != nil && *key != "" {
+		var err error
+		keyFilter, err = newFilter(*key)
+		if err != nil {
+			return nil, nil, "key", err
+		}
+	}
+
+	labelFilter, err := newLabelFilter(label)
+	if err != nil {
+		return nil, nil, "label", err
+	}
+
+	return keyFilter, labelFilter, "", nil
+}
+
 // GetKeys implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) GetKeys(ctx context.Context, request ogen.GetKeysRequestObject) (ogen.GetKeysResponseObject, error) {
-	panic("unimplemented")
+	var filter Filter = nullFilter{}
+	if request.Params.Name != nil && *request.Params.Name != "" {
+		var err error
+		filter, err = newFilter(*request.Params.Name)
+		if err != nil {
+			return ogen.GetKeysdefaultApplicationProblemPlusJSONResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "name", err.Error()),
+			}, nil
+		}
+	}
+
+	span := startStoreSpan(ctx, "GetKeys")
+	keys, err := rs.configStore.GetKeys()
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetKeysdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return !filter.Apply(key) })
+
+	page, after, err := pageItems(keys, request.Params.After, func(key string) pageToken {
+		return pageToken{Key: key}
+	})
+	if err != nil {
+		return ogen.GetKeysdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", afterParam, err.Error()),
+		}, nil
+	}
+
+	items := make([]ogen.Key, 0, len(page))
+	for _, key := range page {
+		items = append(items, ogen.Key{Name: &key})
+	}
+
+	resp := ogen.GetKeys200JSONResponse{
+		Headers: ogen.GetKeys200ResponseHeaders{
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: ogen.KeyListResult{Items: &items},
+	}
+	if after != "" {
+		link := nextLink(ctx, after)
+		resp.Body.NextLink = &link
+	}
+
+	return resp, nil
 }
 
 // GetLabels implements appconfig.StrictServerInterface.
+// The null label is listed without a name.
 func (rs *appConfigRestServer) GetLabels(ctx context.Context, request ogen.GetLabelsRequestObject) (ogen.GetLabelsResponseObject, error) {
-	panic("unimplemented")
+	filter, err := newLabelFilter(request.Params.Name)
+	if err != nil {
+		return ogen.GetLabelsdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "name", err.Error()),
+		}, nil
+	}
+
+	span := startStoreSpan(ctx, "GetSettings")
+	settings, err := rs.configStore.GetSettings()
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetLabelsdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	labels := []string{}
+	for _, setting := range settings {
+		if filter.Apply(setting.Label) {
+			labels = append(labels, setting.Label)
+		}
+	}
+	slices.Sort(labels)
+	labels = slices.Compact(labels)
+
+	page, after, err := pageItems(labels, request.Params.After, func(label string) pageToken {
+		return pageToken{Label: label}
+	})
+	if err != nil {
+		return ogen.GetLabelsdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", afterParam, err.Error()),
+		}, nil
+	}
+
+	items := make([]ogen.Label, 0, len(page))
+	for _, label := range page {
+		item := ogen.Label{}
+		if label != NullLabel {
+			item.Name = &label
+		}
+		items = append(items, item)
+	}
+
+	resp := ogen.GetLabels200JSONResponse{
+		Headers: ogen.GetLabels200ResponseHeaders{
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: ogen.LabelListResult{Items: &items},
+	}
+	if after != "" {
+		link := nextLink(ctx, after)
+		resp.Body.NextLink = &link
+	}
+
+	return resp, nil
 }
 
 // GetOperationDetails implements appconfig.StrictServerInterface.
+// The only long running operation is creating a snapshot, which completes
+// before CreateSnapshot responds.
 func (rs *appConfigRestServer) GetOperationDetails(ctx context.Context, request ogen.GetOperationDetailsRequestObject) (ogen.GetOperationDetailsResponseObject, error) {
-	panic("unimplemented")
+	span := startStoreSpan(ctx, "GetSnapshot")
+	_, err := rs.configStore.GetSnapshot(request.Params.Snapshot)
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetOperationDetailsdefaultJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	return ogen.GetOperationDetails200JSONResponse{
+		Id:     request.Params.Snapshot,
+		Status: ogen.AzureCoreFoundationsOperationStateSucceeded,
+	}, nil
+}
+
+// revision is the version at index in the versions of setting
+type revision struct {
+	setting ConfigSetting
+	index   int
 }
 
 // GetRevisions implements appconfig.StrictServerInterface.
+// The revisions of a key-value are its stored versions, newest first.
+// Those of deleted key-values are not kept.
 func (rs *appConfigRestServer) GetRevisions(ctx context.Context, request ogen.GetRevisionsRequestObject) (ogen.GetRevisionsResponseObject, error) {
-	panic("unimplemented")
+	filter, labelFilter, name, err := newKeyValueFilters(request.Params.Key, request.Params.Label)
+	if err != nil {
+		return ogen.GetRevisionsdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", name, err.Error()),
+		}, nil
+	}
+
+	span := startStoreSpan(ctx, "GetSettings")
+	settings, err := rs.configStore.GetSettings()
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetRevisionsdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	revisions := []revision{}
+	for _, setting := range settings {
+		if !filter.Apply(setting.Key) || !labelFilter.Apply(setting.Label) {
+			continue
+		}
+		for i := range setting.Versions {
+			revisions = append(revisions, revision{setting: setting, index: i})
+		}
+	}
+
+	page, after, err := pageItems(revisions, request.Params.After, func(r revision) pageToken {
+		return pageToken{Key: r.setting.Key, Label: r.setting.Label, Version: len(r.setting.Versions) - 1 - r.index}
+	})
+	if err != nil {
+		return ogen.GetRevisionsdefaultApplicationProblemPlusJSONResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", afterParam, err.Error()),
+		}, nil
+	}
+
+	values := make([]ogen.KeyValue, 0, len(page))
+	for _, r := range page {
+		values = append(values, versionToKeyValue(r.setting, r.setting.Versions[r.index]))
+	}
+
+	etag := listEtag(values)
+	resp := ogen.GetRevisions200JSONResponse{
+		Headers: ogen.GetRevisions200ResponseHeaders{
+			ETag:      etag,
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: ogen.KeyValueListResult{
+			Items: &values,
+			Etag:  &etag,
+		},
+	}
+	if after != "" {
+		link := nextLink(ctx, after)
+		resp.Body.NextLink = &link
+	}
+
+	return resp, nil
 }
 
 // GetSnapshot implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) GetSnapshot(ctx context.Context, request ogen.GetSnapshotRequestObject) (ogen.GetSnapshotResponseObject, error) {
-	panic("unimplemented")
+	span := startStoreSpan(ctx, "GetSnapshot")
+	snapshot, err := rs.configStore.GetSnapshot(request.Name)
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetSnapshotdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	response := snapshotToSnapshot(snapshot, nil)
+	return ogen.GetSnapshot200JSONResponse{
+		Headers: ogen.GetSnapshot200ResponseHeaders{
+			ETag:         *response.Etag,
+			SyncToken:    rs.configStore.SyncToken(),
+			XMsRequestId: responseRequestId(ctx),
+		},
+		Body: response,
+	}, nil
 }
 
 // GetSnapshots implements appconfig.StrictServerInterface.
 func (rs *appConfigRestServer) GetSnapshots(ctx context.Context, request ogen.GetSnapshotsRequestObject) (ogen.GetSnapshotsResponseObject, error) {
-	panic("unimplemented")
+	var nameFilter Filter = nullFilter{}
+	if request.Params.Name != nil && *request.Params.Name != "" {
+		var err error
+		nameFilter, err = newFilter(*request.Params.Name)
+		if err != nil {
+			return ogen.GetSnapshotsdefaultApplicationProblemPlusJSONResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       newError(http.StatusBadRequest, errTypeInvalidArgument, "Invalid request parameter", "name", err.Error()),
+			}, nil
+		}
+	}
+
+	span := startStoreSpan(ctx, "GetSnapshots")
+	snapshots, err := rs.configStore.GetSnapshots()
+	endStoreSpan(span, err)
+	if err != nil {
+		status, problem := storeErrorProblem(err)
+		return ogen.GetSnapshotsdefaultApplicationProblemPlusJSONResponse{StatusCode: status, Body: problem}, nil
+	}
+
+	items := []ogen.Snapshot{}
+	for _, snapshot := range snapshots {
+		if !nameFilter.Apply(snapshot.Name) {
+			continue
+		}
+		if request.Params.Status != nil && !slices.Contains(*request.Params.Status, ogen.GetSnapshotsParamsStatus(snapshot.Status)) {
+			continue
+		}
+		items = append(items, snapshotToSnapshot(snapshot, nil))
+	}
+
+	return ogen.GetSnapshots200JSONResponse{
+		Headers: ogen.GetSnapshots200ResponseHeaders{
+			SyncToken: rs.configStore.SyncToken(),
+		},
+		Body: ogen.SnapshotListResult{Items: &items},
+	}, nil
+}
+
+// operationLocation returns the URL of the operation that created the
+// snapshot @param name, on the host the request in @param ctx, a gin
+// context, was made to
+func operationLocation(ctx context.Context, name string, apiVersion string) string {
+	scheme := "http"
+	host := ""
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		host = c.Request.Host
+	}
+
+	query := url.Values{"snapshot": {name}, apiVersionParam: {apiVersion}}
+	return fmt.Sprintf("%s://%s/operations?%s", scheme, host, query.Encode())
 }
 
 type AppConfigRestServer interface {
 	RegisterToGin(g *gin.RouterGroup)
 }
 
-func NewRestServer(configStore persistentConfigStore) AppConfigRestServer {
-	return &appConfigRestServer{configStore: configStore}
+func NewRestServer(configStore *persistentConfigStore, opts ...RestServerOption) AppConfigRestServer {
+	return newRestServer(configStore, opts...)
+}
+
+func newRestServer(configStore *persistentConfigStore, opts ...RestServerOption) *appConfigRestServer {
+	rs := &appConfigRestServer{
+		configStore: configStore,
+		apiVersions: newApiVersions(DefaultApiVersions),
+		faults:      newFaultInjector(),
+	}
+
+	for _, opt := range opts {
+		opt(rs)
+	}
+
+	return rs
 }
 
 func (rs *appConfigRestServer) RegisterToGin(g *gin.RouterGroup) {
@@ -303,12 +957,18 @@ func (rs *appConfigRestServer) RegisterToGin(g *gin.RouterGroup) {
 	//
 	// We override the templating to generate better binding code.
 
+	middlewares := []ogen.MiddlewareFunc{}
+	if len(rs.credentials) > 0 {
+		middlewares = append(middlewares, ogen.MiddlewareFunc(hmacAuthMiddleware(rs.credentials)))
+	}
+
 	ogen.RegisterHandlersWithOptions(
-		g,
-		ogen.NewStrictHandler(rs, []ogen.StrictMiddlewareFunc{}),
+		g.Group("", keyParamMiddleware),
+		ogen.NewStrictHandler(rs, rs.strictMiddlewares()),
 		ogen.GinServerOptions{
 			// The RouterGroup passed in specifies our BaseURL
-			BaseURL: "",
+			BaseURL:     "",
+			Middlewares: middlewares,
 			// ErrorHandler is only invoked for errors encountered before processing the request
 			ErrorHandler: func(c *gin.Context, err error, i int) {
 				c.String(i, "Unexpected error: %s", err.Error())
@@ -318,5 +978,110 @@ func (rs *appConfigRestServer) RegisterToGin(g *gin.RouterGroup) {
 }
 
 func settingToKeyValue(setting ConfigSetting) (ogen.KeyValue, error) {
+	latest, err := setting.GetLatest()
+	if err != nil {
+		return ogen.KeyValue{}, errors.Wrapf(err, "failed to get latest version of setting %s", setting.Key)
+	}
+
+	return versionToKeyValue(setting, latest), nil
+}
+
+// versionToKeyValue converts @param version of @param setting for a
+// response. Its etag is the version's.
+func versionToKeyValue(setting ConfigSetting, version ConfigSettingVersion) ogen.KeyValue {
+	key := setting.Key
+	label := setting.Label
+	locked := setting.Locked
+	tags := version.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
+	return ogen.KeyValue{
+		Key:          &key,
+		Label:        &label,
+		Value:        &version.Value,
+		Etag:         &version.Uuid,
+		LastModified: &version.Timestamp,
+		ContentType:  &version.ContentType,
+		Locked:       &locked,
+		Tags:         &tags,
+	}
+}
+
+// listEtag is the etag of a list of @param values, which changes whenever
+// one of them is added, removed, changed, locked or unlocked
+func listEtag(values []ogen.KeyValue) string {
+	state := []byte{}
+	for _, kv := range values {
+		state = fmt.Appendf(state, "%s\x00%s\x00%s\x00%t\x00", *kv.Key, *kv.Label, *kv.Etag, *kv.Locked)
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, state).String()
+}
+
+// snapshotRetentionPeriod is the number of seconds an archived snapshot is
+// kept for, the service's default
+const snapshotRetentionPeriod = 30 * 24 * 60 * 60
 
+// snapshotToSnapshot converts @param snapshot for a response. The store
+// doesn't record the composition type, so the one requested is returned
+// when there is one.
+func snapshotToSnapshot(snapshot ConfigurationSnapshot, compositionType *ogen.CompositionTypex) ogen.Snapshot {
+	if compositionType == nil {
+		key := ogen.CompositionTypexKey
+		compositionType = &key
+	}
+
+	filters := make([]ogen.KeyValueFilter, 0, len(snapshot.Filters))
+	for _, f := range snapshot.Filters {
+		filter := ogen.KeyValueFilter{Key: f.Key}
+		if f.Label != "" {
+			label := f.Label
+			filter.Label = &label
+		}
+		filters = append(filters, filter)
+	}
+
+	var size int64
+	for _, setting := range snapshot.Settings {
+		for _, version := range setting.Versions {
+			size += int64(len(setting.Key) + len(setting.Label) + len(version.Value) + len(version.ContentType))
+		}
+	}
+
+	name := snapshot.Name
+	status := ogen.SnapshotStatus(snapshot.Status)
+	created := snapshot.Created
+	itemsCount := int64(len(snapshot.Settings))
+	retentionPeriod := int64(snapshotRetentionPeriod)
+	etag := uuid.NewSHA1(uuid.NameSpaceOID, []byte(name+"\x00"+snapshot.Status+"\x00"+snapshot.Archived.String())).String()
+	tags := map[string]string{}
+
+	response := ogen.Snapshot{
+		Name:            &name,
+		Status:          &status,
+		CompositionType: compositionType,
+		Filters:         filters,
+		Created:         &created,
+		ItemsCount:      &itemsCount,
+		Size:            &size,
+		RetentionPeriod: &retentionPeriod,
+		Etag:            &etag,
+		Tags:            &tags,
+	}
+	if !snapshot.Archived.IsZero() {
+		expires := snapshot.Archived.Add(snapshotRetentionPeriod * time.Second)
+		response.Expires = &expires
+	}
+
+	return response
+}
+
+// labelParam returns the label addressed by an optional label query
+// parameter. Absent, empty and "\0" all address the null label.
+func labelParam(label *string) string {
+	if label == nil || *label == nullLabelFilter {
+		return NullLabel
+	}
+	return *label
 }
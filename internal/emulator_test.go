@@ -0,0 +1,285 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+func TestLabeledKeyValues(t *testing.T) {
+	do := func(t *testing.T, engine http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, target, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	keyValue := func(t *testing.T, rec *httptest.ResponseRecorder) ogen.KeyValue {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var kv ogen.KeyValue
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &kv))
+		return kv
+	}
+
+	keys := func(t *testing.T, rec *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var list ogen.KeyValueListResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		found := []string{}
+		for _, kv := range *list.Items {
+			found = append(found, *kv.Key+"/"+*kv.Label)
+		}
+		return found
+	}
+
+	t.Run("Each label of a key is a separate setting", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		kv := keyValue(t, do(t, engine, http.MethodPut, "/kv/App?label=prod&api-version=2023-10-01", `{"value": "p"}`))
+		require.Equal(t, "prod", *kv.Label)
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "n"}`))
+
+		kv = keyValue(t, do(t, engine, http.MethodGet, "/kv/App?label=prod&api-version=2023-10-01", ""))
+		require.Equal(t, "p", *kv.Value)
+		kv = keyValue(t, do(t, engine, http.MethodGet, "/kv/App?api-version=2023-10-01", ""))
+		require.Equal(t, "n", *kv.Value)
+		require.Equal(t, "", *kv.Label)
+	})
+
+	t.Run("Lists filter on the label", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App?label=prod&api-version=2023-10-01", `{"value": "p"}`))
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "n"}`))
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/Other?label=prod&api-version=2023-10-01", `{"value": "o"}`))
+
+		require.Equal(t, []string{"App/", "App/prod", "Other/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App/prod", "Other/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?label=prod&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App/"}, keys(t, do(t, engine, http.MethodGet, "/kv?label=%00&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App/", "App/prod", "Other/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?label=%00,prod&api-version=2023-10-01", "")))
+	})
+
+	t.Run("Filters match whole keys and labels", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App.Name?label=prod&api-version=2023-10-01", `{"value": "a"}`))
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/AppxName?label=preprod&api-version=2023-10-01", `{"value": "b"}`))
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App.Name.Old?label=prod&api-version=2023-10-01", `{"value": "c"}`))
+
+		require.Equal(t, []string{"App.Name/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?key=App.Name&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App.Name/prod", "App.Name.Old/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?label=prod&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"AppxName/preprod"}, keys(t, do(t, engine, http.MethodGet, "/kv?label=pre*&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App.Name/prod", "App.Name.Old/prod"}, keys(t, do(t, engine, http.MethodGet, "/kv?key=App.*&api-version=2023-10-01", "")))
+		require.Equal(t, []string{"App.Name/prod", "AppxName/preprod"}, keys(t, do(t, engine, http.MethodGet, "/kv?key=App.Name,AppxName&api-version=2023-10-01", "")))
+	})
+
+	t.Run("Locks and deletes address one label", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App?label=prod&api-version=2023-10-01", `{"value": "p"}`))
+		keyValue(t, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "n"}`))
+
+		kv := keyValue(t, do(t, engine, http.MethodPut, "/locks/App?label=prod&api-version=2023-10-01", ""))
+		require.True(t, *kv.Locked)
+		kv = keyValue(t, do(t, engine, http.MethodGet, "/kv/App?api-version=2023-10-01", ""))
+		require.False(t, *kv.Locked)
+		kv = keyValue(t, do(t, engine, http.MethodDelete, "/locks/App?label=prod&api-version=2023-10-01", ""))
+		require.False(t, *kv.Locked)
+
+		rec := do(t, engine, http.MethodDelete, "/kv/App?label=prod&api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, []string{"App/"}, keys(t, do(t, engine, http.MethodGet, "/kv?api-version=2023-10-01", "")))
+	})
+}
+
+func TestKeyValueErrors(t *testing.T) {
+	do := func(t *testing.T, engine http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, target, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	problem := func(t *testing.T, rec *httptest.ResponseRecorder, status int) ogen.Error {
+		require.Equal(t, status, rec.Code, rec.Body.String())
+		require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		var body ogen.Error
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, int32(status), *body.Status)
+		return body
+	}
+
+	t.Run("Missing key-values are not found", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			body := problem(t, do(t, engine, method, "/kv/Missing?api-version=2023-10-01", ""), http.StatusNotFound)
+			require.Equal(t, errTypeNotFound, *body.Type)
+		}
+		for _, method := range []string{http.MethodPut, http.MethodDelete} {
+			problem(t, do(t, engine, method, "/locks/Missing?api-version=2023-10-01", ""), http.StatusNotFound)
+		}
+	})
+
+	t.Run("Locked key-values can't be modified", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		require.Equal(t, http.StatusOK, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "v"}`).Code)
+		require.Equal(t, http.StatusOK, do(t, engine, http.MethodPut, "/locks/App?api-version=2023-10-01", "").Code)
+
+		body := problem(t, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "w"}`), http.StatusConflict)
+		require.Equal(t, errTypeKeyLocked, *body.Type)
+		body = problem(t, do(t, engine, http.MethodDelete, "/kv/App?api-version=2023-10-01", ""), http.StatusConflict)
+		require.Equal(t, errTypeKeyLocked, *body.Type)
+		problem(t, do(t, engine, http.MethodDelete, AdminBasePath+"/settings?key=App", ""), http.StatusConflict)
+
+		require.Equal(t, http.StatusOK, do(t, engine, http.MethodGet, "/kv/App?api-version=2023-10-01", "").Code)
+	})
+
+	t.Run("Invalid filters are refused", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		for _, tc := range []struct {
+			query string
+			name  string
+		}{
+			{"key=a*b", "key"},
+			{"key=*,x", "key"},
+			{"label=a*b", "label"},
+			{"label=*,x", "label"},
+		} {
+			body := problem(t, do(t, engine, http.MethodGet, "/kv?"+tc.query+"&api-version=2023-10-01", ""), http.StatusBadRequest)
+			require.Equal(t, errTypeInvalidArgument, *body.Type)
+			require.Equal(t, tc.name, *body.Name, tc.query)
+		}
+	})
+
+	t.Run("Requests whose preconditions fail are refused", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		conditional := func(method string, target string, header string, etag string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(method, target, strings.NewReader(`{"value": "w"}`))
+			rq.Header.Set("Content-Type", "application/json")
+			rq.Header.Set(header, etag)
+			engine.ServeHTTP(rec, rq)
+			return rec
+		}
+
+		// If-Match fails for key-values that don't exist, even with "*"
+		for _, method := range []string{http.MethodPut, http.MethodDelete} {
+			body := problem(t, conditional(method, "/kv/App?api-version=2023-10-01", "If-Match", "*"), http.StatusPreconditionFailed)
+			require.Equal(t, errTypePreconditionFailed, *body.Type)
+		}
+
+		require.Equal(t, http.StatusOK, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "v"}`).Code)
+		problem(t, conditional(http.MethodPut, "/kv/App?api-version=2023-10-01", "If-None-Match", "*"), http.StatusPreconditionFailed)
+		problem(t, conditional(http.MethodGet, "/kv/App?api-version=2023-10-01", "If-Match", `"stale"`), http.StatusPreconditionFailed)
+		problem(t, conditional(http.MethodPut, "/locks/App?api-version=2023-10-01", "If-Match", `"stale"`), http.StatusPreconditionFailed)
+	})
+
+	t.Run("A value is required", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		body := problem(t, do(t, engine, http.MethodPut, "/kv/App?api-version=2023-10-01", `{"content_type": "text/plain"}`), http.StatusBadRequest)
+		require.Equal(t, errTypeInvalidArgument, *body.Type)
+		require.Equal(t, "value", *body.Name)
+	})
+}
+
+func TestListEtags(t *testing.T) {
+	engine, _, closer := makeTestRestServer(t)
+	defer closer()
+
+	list := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/kv?key=App:*&api-version=2023-10-01", nil)
+		if ifNoneMatch != "" {
+			rq.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+	change := func(method string, target string, body string) {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, target, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	change(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "v"}`)
+	rec := list("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, etag, list("").Header().Get("ETag"), "an unchanged list keeps its etag")
+
+	rec = list(`"` + etag + `"`)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.String())
+	require.Equal(t, etag, rec.Header().Get("ETag"))
+
+	// Changes to key-values outside the list don't change its etag
+	change(http.MethodPut, "/kv/Other?api-version=2023-10-01", `{"value": "v"}`)
+	require.Equal(t, http.StatusNotModified, list(`"`+etag+`"`).Code)
+
+	for _, tc := range []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "w"}`},
+		{http.MethodPut, "/locks/App:Name?api-version=2023-10-01", ""},
+		{http.MethodPut, "/kv/App:Port?api-version=2023-10-01", `{"value": "80"}`},
+	} {
+		change(tc.method, tc.target, tc.body)
+		rec = list(`"` + etag + `"`)
+		require.Equal(t, http.StatusOK, rec.Code, "%s %s changes the etag", tc.method, tc.target)
+		etag = rec.Header().Get("ETag")
+	}
+}
+
+func TestSyncTokens(t *testing.T) {
+	engine, _, closer := makeTestRestServer(t)
+	defer closer()
+
+	// Each change is numbered, and reads return the latest number
+	for _, tc := range []struct {
+		method   string
+		target   string
+		body     string
+		sequence int64
+	}{
+		{http.MethodPut, "/kv/App?api-version=2023-10-01", `{"value": "v"}`, 1},
+		{http.MethodGet, "/kv/App?api-version=2023-10-01", "", 1},
+		{http.MethodPut, "/locks/App?api-version=2023-10-01", "", 2},
+		{http.MethodDelete, "/locks/App?api-version=2023-10-01", "", 3},
+		{http.MethodGet, "/kv?api-version=2023-10-01", "", 3},
+		{http.MethodDelete, "/kv/App?api-version=2023-10-01", "", 4},
+	} {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code, "%s %s", tc.method, tc.target)
+		require.Equal(t, formatSyncToken(tc.sequence), rec.Header().Get("Sync-Token"), "%s %s", tc.method, tc.target)
+	}
+}
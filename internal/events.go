@@ -0,0 +1,123 @@
+package emulator
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Change events are delivered to webhooks and queues in the schemas Event
+// Grid supports, with the payloads App Configuration publishes.
+
+const (
+	EventSchemaEventGrid   = "eventgrid"
+	EventSchemaCloudEvents = "cloudevents"
+
+	EventTypeKeyValueModified = "Microsoft.AppConfiguration.KeyValueModified"
+	EventTypeKeyValueDeleted  = "Microsoft.AppConfiguration.KeyValueDeleted"
+
+	// DefaultEventTopic is the topic (the store's resource id) events are sent from
+	DefaultEventTopic = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/aac-emulator" +
+		"/providers/microsoft.appconfiguration/configurationstores/aac-emulator"
+)
+
+var EventSchemas = []string{EventSchemaEventGrid, EventSchemaCloudEvents}
+
+// EventOptions describe the emulator in change events
+type EventOptions struct {
+	// Topic identifies the store in events (DefaultEventTopic if empty)
+	Topic string
+	// Origin is the emulator's base URL, used in event subjects
+	Origin string
+}
+
+// keyValueEventData is the data of App Configuration key-value events
+type keyValueEventData struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Etag      string `json:"etag"`
+	SyncToken string `json:"syncToken"`
+}
+
+type eventGridEvent struct {
+	Id              string      `json:"id"`
+	Topic           string      `json:"topic"`
+	Subject         string      `json:"subject"`
+	EventType       string      `json:"eventType"`
+	EventTime       time.Time   `json:"eventTime"`
+	Data            interface{} `json:"data"`
+	DataVersion     string      `json:"dataVersion"`
+	MetadataVersion string      `json:"metadataVersion"`
+}
+
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Id              string      `json:"id"`
+	Source          string      `json:"source"`
+	Subject         string      `json:"subject"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// newChangeEvent returns the event for @param change in @param schema, and
+// its content type
+func newChangeEvent(schema string, opts EventOptions, change SettingChange) (interface{}, string) {
+	eventType := EventTypeKeyValueModified
+	if change.Type == SettingDeleted {
+		eventType = EventTypeKeyValueDeleted
+	}
+
+	data := keyValueEventData{
+		Key:       change.Key,
+		Label:     change.Label,
+		Etag:      change.Etag,
+		SyncToken: change.SyncToken,
+	}
+
+	subject := opts.origin() + "/kv/" + url.PathEscape(change.Key)
+	if change.Label != NullLabel {
+		subject += "?label=" + url.QueryEscape(change.Label)
+	}
+
+	if schema == EventSchemaCloudEvents {
+		return cloudEvent{
+			SpecVersion:     "1.0",
+			Id:              uuid.NewString(),
+			Source:          opts.topic(),
+			Subject:         subject,
+			Type:            eventType,
+			Time:            change.Time,
+			DataContentType: "application/json",
+			Data:            data,
+		}, "application/cloudevents+json; charset=utf-8"
+	}
+
+	return eventGridEvent{
+		Id:              uuid.NewString(),
+		Topic:           opts.topic(),
+		Subject:         subject,
+		EventType:       eventType,
+		EventTime:       change.Time,
+		Data:            data,
+		DataVersion:     "1",
+		MetadataVersion: "1",
+	}, "application/json"
+}
+
+func (opts EventOptions) topic() string {
+	if opts.Topic == "" {
+		return DefaultEventTopic
+	}
+	return opts.Topic
+}
+
+func (opts EventOptions) origin() string {
+	if opts.Origin == "" {
+		return "http://localhost"
+	}
+	return strings.TrimSuffix(opts.Origin, "/")
+}
@@ -0,0 +1,356 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+// Faults can be injected into App Configuration operations, so that
+// clients' resiliency can be tested: latency, throttling, errors, reset
+// connections and truncated bodies. Rules are managed at runtime through
+// the admin API:
+//
+//	GET    /_admin/faults       list the rules
+//	POST   /_admin/faults       add a rule
+//	DELETE /_admin/faults/:id   remove a rule
+//	DELETE /_admin/faults       remove every rule
+//
+// The first rule matching a request is applied to it. The admin API itself
+// is never faulted.
+
+const (
+	errTypeTooManyRequests    = "https://azconfig.io/errors/too-many-requests"
+	errTypeServiceUnavailable = "https://azconfig.io/errors/service-unavailable"
+
+	defaultFaultRetryAfter = time.Second
+
+	retryAfterMsHeader    = "retry-after-ms"
+	xMsRetryAfterMsHeader = "x-ms-retry-after-ms"
+)
+
+// FaultRule describes a fault, and the requests it is injected into
+type FaultRule struct {
+	Id string `json:"id"`
+
+	// Operation is the operationId the rule applies to, or all if empty
+	Operation string `json:"operation,omitempty"`
+	// Key is a key filter, as in GetKeyValues, matched against the key
+	// requested; empty matches every request
+	Key string `json:"key,omitempty"`
+	// Probability is the chance, between 0 and 1, that a matching request
+	// is faulted. 0 is treated as 1: every matching request is faulted.
+	Probability float64 `json:"probability,omitempty"`
+	// Times is how many requests are faulted before the rule is removed,
+	// or unlimited if 0
+	Times int `json:"times,omitempty"`
+
+	// LatencyMs delays the request, before any other fault
+	LatencyMs int `json:"latencyMs,omitempty"`
+	// Status responds with an error of this status code. 429 and 503
+	// responses have retry headers.
+	Status int `json:"status,omitempty"`
+	// RetryAfterMs is sent in the retry headers (default 1000)
+	RetryAfterMs int `json:"retryAfterMs,omitempty"`
+	// Reset closes the connection without responding
+	Reset bool `json:"reset,omitempty"`
+	// Truncate sends only the first half of the response body
+	Truncate bool `json:"truncate,omitempty"`
+
+	keyFilter Filter
+}
+
+// validate checks @param fr, and prepares it for matching
+func (fr *FaultRule) validate() error {
+	if fr.Probability < 0 || fr.Probability > 1 {
+		return fmt.Errorf("probability must be between 0 and 1")
+	}
+	if fr.Times < 0 || fr.LatencyMs < 0 || fr.RetryAfterMs < 0 {
+		return fmt.Errorf("times, latencyMs and retryAfterMs must not be negative")
+	}
+	if fr.Status != 0 && (fr.Status < 400 || fr.Status > 599) {
+		return fmt.Errorf("status must be an error status code")
+	}
+
+	faults := 0
+	for _, fault := range []bool{fr.Status != 0, fr.Reset, fr.Truncate} {
+		if fault {
+			faults++
+		}
+	}
+	if faults > 1 {
+		return fmt.Errorf("only one of status, reset and truncate may be given")
+	}
+	if faults == 0 && fr.LatencyMs == 0 {
+		return fmt.Errorf("a rule needs a fault: latencyMs, status, reset or truncate")
+	}
+
+	fr.keyFilter = nullFilter{}
+	if fr.Key != "" {
+		filter, err := newFilter(fr.Key)
+		if err != nil {
+			return err
+		}
+		fr.keyFilter = filter
+	}
+
+	return nil
+}
+
+// matches reports whether the rule applies to a request for
+// @param operation and @param key
+func (fr *FaultRule) matches(operation string, key string) bool {
+	if fr.Operation != "" && fr.Operation != operation {
+		return false
+	}
+	if fr.Key != "" && (key == "" || !fr.keyFilter.Apply(key)) {
+		return false
+	}
+	return fr.Probability == 0 || rand.Float64() < fr.Probability
+}
+
+// ReadFaultRules reads a JSON array of fault rules from @param r
+func ReadFaultRules(r io.Reader) ([]FaultRule, error) {
+	rules := []FaultRule{}
+	err := json.NewDecoder(r).Decode(&rules)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse fault rules")
+	}
+
+	for i := range rules {
+		err = rules[i].validate()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fault rule %d", i+1)
+		}
+	}
+
+	return rules, nil
+}
+
+// A FaultInjector holds the fault rules, and injects them into requests
+type FaultInjector struct {
+	sync.Mutex
+	rules  []FaultRule
+	nextId int
+}
+
+func newFaultInjector() *FaultInjector {
+	return &FaultInjector{rules: []FaultRule{}}
+}
+
+// Rules returns the rules, in the order they are matched
+func (fi *FaultInjector) Rules() []FaultRule {
+	fi.Lock()
+	defer fi.Unlock()
+	return slices.Clone(fi.rules)
+}
+
+// AddRule adds @param rule after the existing rules, returning it with its id
+func (fi *FaultInjector) AddRule(rule FaultRule) (FaultRule, error) {
+	err := rule.validate()
+	if err != nil {
+		return FaultRule{}, err
+	}
+
+	fi.Lock()
+	defer fi.Unlock()
+
+	fi.nextId++
+	if rule.Id == "" {
+		rule.Id = strconv.Itoa(fi.nextId)
+	}
+	if slices.ContainsFunc(fi.rules, func(r FaultRule) bool { return r.Id == rule.Id }) {
+		return FaultRule{}, fmt.Errorf("a rule with id '%s' already exists", rule.Id)
+	}
+	fi.rules = append(fi.rules, rule)
+
+	return rule, nil
+}
+
+// RemoveRule removes the rule with @param id, reporting whether there was one
+func (fi *FaultInjector) RemoveRule(id string) bool {
+	fi.Lock()
+	defer fi.Unlock()
+
+	count := len(fi.rules)
+	fi.rules = slices.DeleteFunc(fi.rules, func(r FaultRule) bool { return r.Id == id })
+	return len(fi.rules) < count
+}
+
+// ClearRules removes every rule
+func (fi *FaultInjector) ClearRules() {
+	fi.Lock()
+	defer fi.Unlock()
+	fi.rules = []FaultRule{}
+}
+
+// match returns the first rule that applies to a request for
+// @param operation and @param key, counting it against the rule's Times
+func (fi *FaultInjector) match(operation string, key string) (FaultRule, bool) {
+	fi.Lock()
+	defer fi.Unlock()
+
+	for i := range fi.rules {
+		rule := &fi.rules[i]
+		if !rule.matches(operation, key) {
+			continue
+		}
+
+		matched := *rule
+		if rule.Times > 0 {
+			rule.Times--
+			if rule.Times == 0 {
+				fi.rules = slices.Delete(fi.rules, i, i+1)
+			}
+		}
+		return matched, true
+	}
+
+	return FaultRule{}, false
+}
+
+// strictMiddleware injects the first matching fault into each operation
+func (fi *FaultInjector) strictMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (interface{}, error) {
+		rule, found := fi.match(operationID, requestKey(c))
+		if !found {
+			return f(c, request)
+		}
+
+		if rule.LatencyMs > 0 {
+			select {
+			case <-time.After(time.Duration(rule.LatencyMs) * time.Millisecond):
+			case <-c.Request.Context().Done():
+				return nil, nil
+			}
+		}
+
+		switch {
+		case rule.Status != 0:
+			writeFaultError(c, rule)
+			return nil, nil
+
+		case rule.Reset:
+			resetConnection(c)
+			return nil, nil
+
+		case rule.Truncate:
+			c.Writer = &truncatingWriter{ResponseWriter: c.Writer}
+		}
+
+		return f(c, request)
+	}
+}
+
+// writeFaultError responds with the error status of @param rule
+func writeFaultError(c *gin.Context, rule FaultRule) {
+	errType, detail := errTypeInternal, "fault injected by the emulator"
+	switch rule.Status {
+	case http.StatusTooManyRequests:
+		errType, detail = errTypeTooManyRequests, "too many requests, throttled by the emulator"
+	case http.StatusServiceUnavailable:
+		errType = errTypeServiceUnavailable
+	}
+
+	if rule.Status == http.StatusTooManyRequests || rule.Status == http.StatusServiceUnavailable {
+		retryAfter := defaultFaultRetryAfter
+		if rule.RetryAfterMs > 0 {
+			retryAfter = time.Duration(rule.RetryAfterMs) * time.Millisecond
+		}
+		setRetryHeaders(c, retryAfter)
+	}
+
+	writeError(c, rule.Status, errType, http.StatusText(rule.Status), "", detail)
+}
+
+// setRetryHeaders tells the client to retry after @param retryAfter, in
+// each of the headers the service sends with throttled responses
+func setRetryHeaders(c *gin.Context, retryAfter time.Duration) {
+	ms := strconv.FormatInt(retryAfter.Milliseconds(), 10)
+	c.Header(retryAfterMsHeader, ms)
+	c.Header(xMsRetryAfterMsHeader, ms)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// resetConnection closes the client's connection without a response. The
+// connection is reset, rather than closed gracefully, where possible.
+func resetConnection(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		// Not a connection that can be taken over, as in tests
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	c.Abort()
+
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// truncatingWriter declares the full length of the response body, but only
+// writes the first half of it. Operations write their body in one Write.
+type truncatingWriter struct {
+	gin.ResponseWriter
+	written bool
+}
+
+func (tw *truncatingWriter) Write(b []byte) (int, error) {
+	if tw.written {
+		return len(b), nil
+	}
+	tw.written = true
+
+	tw.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, err := tw.ResponseWriter.Write(b[:len(b)/2])
+	return len(b), err
+}
+
+func (tw *truncatingWriter) WriteString(s string) (int, error) {
+	return tw.Write([]byte(s))
+}
+
+func registerFaultRoutes(g *gin.RouterGroup, fi *FaultInjector) {
+	faults := g.Group("/faults")
+	faults.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"items": fi.Rules()})
+	})
+	faults.POST("", func(c *gin.Context) {
+		var rule FaultRule
+		err := c.ShouldBindJSON(&rule)
+		if err == nil {
+			rule, err = fi.AddRule(rule)
+		}
+		if err != nil {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid fault rule", "", err.Error())
+			return
+		}
+		c.JSON(http.StatusCreated, rule)
+	})
+	faults.DELETE("", func(c *gin.Context) {
+		fi.ClearRules()
+		c.Status(http.StatusNoContent)
+	})
+	faults.DELETE("/:id", func(c *gin.Context) {
+		if !fi.RemoveRule(c.Param("id")) {
+			writeError(c, http.StatusNotFound, errTypeNotFound, "Not found", "id",
+				fmt.Sprintf("fault rule %s does not exist", c.Param("id")))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
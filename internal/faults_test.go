@@ -0,0 +1,169 @@
+package emulator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	do := func(engine *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, path, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	t.Run("Throttling has retry headers, and is counted", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodPost, AdminBasePath+"/faults", `{"operation": "GetKeyValue", "status": 429, "retryAfterMs": 1500}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var rule FaultRule
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rule))
+		require.Equal(t, "1", rule.Id)
+
+		rec = do(engine, http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "1500", rec.Header().Get(retryAfterMsHeader))
+		require.Equal(t, "1500", rec.Header().Get(xMsRetryAfterMsHeader))
+		require.Equal(t, "2", rec.Header().Get("Retry-After"))
+		require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		require.Contains(t, rec.Body.String(), errTypeTooManyRequests)
+
+		// Other operations are not faulted
+		rec = do(engine, http.MethodGet, "/kv?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(engine, http.MethodGet, MetricsPath, "")
+		require.Contains(t, rec.Body.String(), `aacemu_throttled_requests_total{operation="GetKeyValue"} 1`+"\n")
+	})
+
+	t.Run("Rules match keys, and expire after their times", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "demo")
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodPost, AdminBasePath+"/faults", `{"key": "App:*", "status": 503, "times": 1}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = do(engine, http.MethodGet, "/kv/Other?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(engine, http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.Equal(t, "1000", rec.Header().Get(retryAfterMsHeader))
+		require.Contains(t, rec.Body.String(), errTypeServiceUnavailable)
+
+		rec = do(engine, http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(engine, http.MethodGet, AdminBasePath+"/faults", "")
+		require.JSONEq(t, `{"items": []}`, rec.Body.String())
+	})
+
+	t.Run("Latency delays the response", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := do(engine, http.MethodPost, AdminBasePath+"/faults", `{"latencyMs": 50}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		start := time.Now()
+		rec = do(engine, http.MethodGet, "/kv?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("Truncated and reset responses fail in the client", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+		server := httptest.NewServer(engine)
+		defer server.Close()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodPost, AdminBasePath+"/faults", `{"truncate": true, "times": 1}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		rs, err := http.Get(server.URL + "/kv/App:Name?api-version=2023-10-01")
+		require.NoError(t, err)
+		_, err = io.ReadAll(rs.Body)
+		rs.Body.Close()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+		rec = do(engine, http.MethodPost, AdminBasePath+"/faults", `{"reset": true, "times": 1}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		_, err = http.Get(server.URL + "/kv/App:Name?api-version=2023-10-01")
+		require.Error(t, err)
+
+		rs, err = http.Get(server.URL + "/kv/App:Name?api-version=2023-10-01")
+		require.NoError(t, err)
+		rs.Body.Close()
+		require.Equal(t, http.StatusOK, rs.StatusCode)
+	})
+
+	t.Run("Rules are managed through the admin API", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		for _, body := range []string{
+			`{}`,
+			`{"status": 200}`,
+			`{"status": 500, "reset": true}`,
+			`{"latencyMs": 10, "probability": 2}`,
+			`{"latencyMs": 10, "key": "a*b"}`,
+		} {
+			rec := do(engine, http.MethodPost, AdminBasePath+"/faults", body)
+			require.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+
+		rec := do(engine, http.MethodPost, AdminBasePath+"/faults", `{"id": "slow", "latencyMs": 10}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		rec = do(engine, http.MethodPost, AdminBasePath+"/faults", `{"id": "slow", "latencyMs": 20}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = do(engine, http.MethodPost, AdminBasePath+"/faults", `{"status": 500}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = do(engine, http.MethodDelete, AdminBasePath+"/faults/slow", "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		rec = do(engine, http.MethodDelete, AdminBasePath+"/faults/slow", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(engine, http.MethodGet, AdminBasePath+"/faults", "")
+		var list struct{ Items []FaultRule }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		require.Len(t, list.Items, 1)
+		require.Equal(t, 500, list.Items[0].Status)
+
+		rec = do(engine, http.MethodDelete, AdminBasePath+"/faults", "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		rec = do(engine, http.MethodGet, "/kv?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Rules are read from JSON", func(t *testing.T) {
+		rules, err := ReadFaultRules(strings.NewReader(`[{"operation": "GetKeyValues", "status": 429}]`))
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+
+		_, err = ReadFaultRules(strings.NewReader(`[{"operation": "GetKeyValues"}]`))
+		require.ErrorContains(t, err, "invalid fault rule 1")
+	})
+}
@@ -0,0 +1,330 @@
+package emulator
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Feature flag evaluation follows the behaviour of the official
+// Microsoft.FeatureManagement libraries, including the hashing used for
+// targeting and percentile allocation, so that results are the same as
+// those an application would see.
+
+const (
+	PercentageFilterName = "Microsoft.Percentage"
+	TimeWindowFilterName = "Microsoft.TimeWindow"
+	TargetingFilterName  = "Microsoft.Targeting"
+
+	// Variant assignment reasons, as reported in feature management telemetry
+	ReasonNone                = "None"
+	ReasonDefaultWhenDisabled = "DefaultWhenDisabled"
+	ReasonDefaultWhenEnabled  = "DefaultWhenEnabled"
+	ReasonUser                = "User"
+	ReasonGroup               = "Group"
+	ReasonPercentile          = "Percentile"
+)
+
+// TargetingContext identifies who a feature flag is being evaluated for
+type TargetingContext struct {
+	UserId string   `json:"user_id"`
+	Groups []string `json:"groups"`
+}
+
+type FeatureFlagEvaluation struct {
+	Feature string              `json:"feature"`
+	Enabled bool                `json:"enabled"`
+	Variant *FeatureFlagVariant `json:"variant"`
+	Reason  string              `json:"reason"`
+}
+
+// Filter parameters are bound case-insensitively, as the .NET
+// configuration binder does.
+
+type percentageFilterParameters struct {
+	Value float64
+}
+
+type timeWindowFilterParameters struct {
+	Start string
+	End   string
+}
+
+type targetingFilterParameters struct {
+	Audience struct {
+		Users                    []string
+		Groups                   []targetingGroup
+		DefaultRolloutPercentage float64
+		Exclusion                struct {
+			Users  []string
+			Groups []string
+		}
+	}
+}
+
+type targetingGroup struct {
+	Name              string
+	RolloutPercentage float64
+}
+
+// EvaluateFeatureFlag evaluates the stored feature flag @param id for @param context
+func (pcs *persistentConfigStore) EvaluateFeatureFlag(id string, context TargetingContext) (FeatureFlagEvaluation, error) {
+	flag, err := pcs.GetFeatureFlag(id)
+	if err != nil {
+		return FeatureFlagEvaluation{}, err
+	}
+
+	return flag.Evaluate(context, time.Now())
+}
+
+// Evaluate decides whether the feature flag is enabled for @param context at
+// time @param now, and which variant (if any) is assigned.
+func (ff *FeatureFlag) Evaluate(context TargetingContext, now time.Time) (FeatureFlagEvaluation, error) {
+	evaluation := FeatureFlagEvaluation{
+		Feature: ff.Id,
+		Reason:  ReasonNone,
+	}
+
+	if ff.IsEnabled() {
+		enabled, err := ff.evaluateConditions(context, now)
+		if err != nil {
+			return FeatureFlagEvaluation{}, err
+		}
+		evaluation.Enabled = enabled
+	}
+
+	if len(ff.Variants) == 0 {
+		return evaluation, nil
+	}
+
+	variantName := ""
+	allocation := ff.Allocation
+	if allocation == nil {
+		allocation = &FeatureFlagAllocation{}
+	}
+
+	if !evaluation.Enabled {
+		if allocation.DefaultWhenDisabled != "" {
+			variantName = allocation.DefaultWhenDisabled
+			evaluation.Reason = ReasonDefaultWhenDisabled
+		}
+	} else {
+		variantName, evaluation.Reason = allocation.assign(ff.Id, context)
+		if variantName == "" && allocation.DefaultWhenEnabled != "" {
+			variantName = allocation.DefaultWhenEnabled
+			evaluation.Reason = ReasonDefaultWhenEnabled
+		}
+	}
+
+	if variantName == "" {
+		return evaluation, nil
+	}
+
+	for _, variant := range ff.Variants {
+		if variant.Name == variantName {
+			evaluation.Variant = &variant
+			break
+		}
+	}
+
+	// The assigned variant may override the enabled state, unless the
+	// flag itself is switched off
+	if evaluation.Variant != nil && ff.IsEnabled() {
+		switch evaluation.Variant.StatusOverride {
+		case StatusOverrideEnabled:
+			evaluation.Enabled = true
+		case StatusOverrideDisabled:
+			evaluation.Enabled = false
+		}
+	}
+
+	return evaluation, nil
+}
+
+func (ff *FeatureFlag) evaluateConditions(context TargetingContext, now time.Time) (bool, error) {
+	if ff.Conditions == nil || len(ff.Conditions.ClientFilters) == 0 {
+		return true, nil
+	}
+
+	requireAll := ff.Conditions.RequirementType == RequirementTypeAll
+
+	for _, filter := range ff.Conditions.ClientFilters {
+		enabled, err := evaluateFilter(ff.Id, filter, context, now)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to evaluate filter %s of feature flag %s", filter.Name, ff.Id)
+		}
+
+		if enabled && !requireAll {
+			return true, nil
+		}
+		if !enabled && requireAll {
+			return false, nil
+		}
+	}
+
+	// Either every filter passed (All), or none did (Any)
+	return requireAll, nil
+}
+
+func evaluateFilter(featureName string, filter FeatureFlagFilter, context TargetingContext, now time.Time) (bool, error) {
+	switch filter.Name {
+	case PercentageFilterName, "Percentage", "PercentageFilter":
+		var params percentageFilterParameters
+		if err := bindFilterParameters(filter, &params); err != nil {
+			return false, err
+		}
+		return rand.Float64()*100 < params.Value, nil
+
+	case TimeWindowFilterName, "TimeWindow", "TimeWindowFilter":
+		var params timeWindowFilterParameters
+		if err := bindFilterParameters(filter, &params); err != nil {
+			return false, err
+		}
+		return evaluateTimeWindow(params, now)
+
+	case TargetingFilterName, "Targeting", "TargetingFilter":
+		var params targetingFilterParameters
+		if err := bindFilterParameters(filter, &params); err != nil {
+			return false, err
+		}
+		return evaluateTargeting(featureName, params, context), nil
+	}
+
+	return false, fmt.Errorf("unknown feature filter '%s'", filter.Name)
+}
+
+func bindFilterParameters(filter FeatureFlagFilter, params interface{}) error {
+	b, err := json.Marshal(filter.Parameters)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal filter parameters")
+	}
+
+	err = json.Unmarshal(b, params)
+	if err != nil {
+		return errors.Wrap(err, "invalid filter parameters")
+	}
+
+	return nil
+}
+
+func evaluateTimeWindow(params timeWindowFilterParameters, now time.Time) (bool, error) {
+	if params.Start == "" && params.End == "" {
+		return false, nil
+	}
+
+	if params.Start != "" {
+		start, err := parseFilterTime(params.Start)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid Start")
+		}
+		if now.Before(start) {
+			return false, nil
+		}
+	}
+
+	if params.End != "" {
+		end, err := parseFilterTime(params.End)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid End")
+		}
+		if !now.Before(end) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// parseFilterTime accepts the RFC 1123 format the portal writes, as well as RFC 3339
+func parseFilterTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC1123, time.RFC1123Z, time.RFC3339} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time format: %s", s)
+}
+
+func evaluateTargeting(featureName string, params targetingFilterParameters, context TargetingContext) bool {
+	audience := params.Audience
+
+	// Exclusions take priority over everything else
+	if context.UserId != "" && slices.Contains(audience.Exclusion.Users, context.UserId) {
+		return false
+	}
+	for _, group := range context.Groups {
+		if slices.Contains(audience.Exclusion.Groups, group) {
+			return false
+		}
+	}
+
+	if context.UserId != "" && slices.Contains(audience.Users, context.UserId) {
+		return true
+	}
+
+	for _, group := range audience.Groups {
+		if !slices.Contains(context.Groups, group.Name) {
+			continue
+		}
+
+		contextId := fmt.Sprintf("%s\n%s\n%s", context.UserId, featureName, group.Name)
+		if targetingPercentage(contextId) < group.RolloutPercentage {
+			return true
+		}
+	}
+
+	contextId := fmt.Sprintf("%s\n%s", context.UserId, featureName)
+	return targetingPercentage(contextId) < audience.DefaultRolloutPercentage
+}
+
+// assign picks a variant from the user, group and percentile allocations,
+// in that order. An empty name means no allocation matched.
+func (fa *FeatureFlagAllocation) assign(featureName string, context TargetingContext) (string, string) {
+	if context.UserId != "" {
+		for _, user := range fa.User {
+			if slices.Contains(user.Users, context.UserId) {
+				return user.Variant, ReasonUser
+			}
+		}
+	}
+
+	for _, group := range fa.Group {
+		for _, name := range context.Groups {
+			if slices.Contains(group.Groups, name) {
+				return group.Variant, ReasonGroup
+			}
+		}
+	}
+
+	seed := fa.Seed
+	if seed == "" {
+		seed = "allocation\n" + featureName
+	}
+
+	percentage := targetingPercentage(fmt.Sprintf("%s\n%s", context.UserId, seed))
+	for _, percentile := range fa.Percentile {
+		inRange := percentage >= percentile.From && percentage < percentile.To
+		if inRange || (percentile.To == 100 && percentage == 100) {
+			return percentile.Variant, ReasonPercentile
+		}
+	}
+
+	return "", ReasonNone
+}
+
+// targetingPercentage maps a context id onto [0, 100], using the first
+// four bytes of its SHA-256 hash read as a little-endian uint32.
+func targetingPercentage(contextId string) float64 {
+	hash := sha256.Sum256([]byte(contextId))
+	marker := binary.LittleEndian.Uint32(hash[:4])
+	return float64(marker) / float64(math.MaxUint32) * 100
+}
@@ -0,0 +1,323 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Feature flags are ordinary key-values, stored under a reserved key prefix
+// with a dedicated content type. The value is a JSON document following the
+// Microsoft Feature Management schema.
+const (
+	FeatureFlagKeyPrefix   = ".appconfig.featureflag/"
+	FeatureFlagContentType = "application/vnd.microsoft.appconfig.ff+json;charset=utf-8"
+
+	featureFlagMediaType = "application/vnd.microsoft.appconfig.ff+json"
+
+	RequirementTypeAny = "Any"
+	RequirementTypeAll = "All"
+
+	StatusOverrideNone     = "None"
+	StatusOverrideEnabled  = "Enabled"
+	StatusOverrideDisabled = "Disabled"
+)
+
+type FeatureFlag struct {
+	Id          string                 `json:"id"`
+	Description string                 `json:"description,omitempty"`
+	DisplayName string                 `json:"display_name,omitempty"`
+	Enabled     *bool                  `json:"enabled"`
+	Conditions  *FeatureFlagConditions `json:"conditions,omitempty"`
+	Variants    []FeatureFlagVariant   `json:"variants,omitempty"`
+	Allocation  *FeatureFlagAllocation `json:"allocation,omitempty"`
+	Telemetry   *FeatureFlagTelemetry  `json:"telemetry,omitempty"`
+}
+
+type FeatureFlagConditions struct {
+	RequirementType string              `json:"requirement_type,omitempty"`
+	ClientFilters   []FeatureFlagFilter `json:"client_filters"`
+}
+
+type FeatureFlagFilter struct {
+	Name       string                 `json:"name"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type FeatureFlagVariant struct {
+	Name               string      `json:"name"`
+	ConfigurationValue interface{} `json:"configuration_value,omitempty"`
+	StatusOverride     string      `json:"status_override,omitempty"`
+}
+
+type FeatureFlagAllocation struct {
+	DefaultWhenDisabled string                 `json:"default_when_disabled,omitempty"`
+	DefaultWhenEnabled  string                 `json:"default_when_enabled,omitempty"`
+	User                []UserAllocation       `json:"user,omitempty"`
+	Group               []GroupAllocation      `json:"group,omitempty"`
+	Percentile          []PercentileAllocation `json:"percentile,omitempty"`
+	Seed                string                 `json:"seed,omitempty"`
+}
+
+type UserAllocation struct {
+	Variant string   `json:"variant"`
+	Users   []string `json:"users"`
+}
+
+type GroupAllocation struct {
+	Variant string   `json:"variant"`
+	Groups  []string `json:"groups"`
+}
+
+type PercentileAllocation struct {
+	Variant string  `json:"variant"`
+	From    float64 `json:"from"`
+	To      float64 `json:"to"`
+}
+
+type FeatureFlagTelemetry struct {
+	Enabled  bool              `json:"enabled"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// IsFeatureFlag reports whether a key-value should be treated as a feature flag,
+// either by its key or by its content type.
+func IsFeatureFlag(key string, contentType string) bool {
+	return strings.HasPrefix(key, FeatureFlagKeyPrefix) ||
+		strings.HasPrefix(contentType, featureFlagMediaType)
+}
+
+// FeatureFlagKey returns the key under which the feature flag @param id is stored
+func FeatureFlagKey(id string) string {
+	return FeatureFlagKeyPrefix + id
+}
+
+// ParseFeatureFlag decodes and validates the value of the feature flag stored at @param key
+func ParseFeatureFlag(key string, value string) (FeatureFlag, error) {
+	decoder := json.NewDecoder(bytes.NewBufferString(value))
+	decoder.DisallowUnknownFields()
+
+	var flag FeatureFlag
+	err := decoder.Decode(&flag)
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "invalid feature flag JSON for %s", key)
+	}
+
+	err = flag.Validate()
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "invalid feature flag %s", key)
+	}
+
+	if id, found := strings.CutPrefix(key, FeatureFlagKeyPrefix); found && id != flag.Id {
+		return FeatureFlag{}, fmt.Errorf("feature flag id '%s' does not match key %s", flag.Id, key)
+	}
+
+	return flag, nil
+}
+
+// Validate checks the feature flag against the Feature Management schema
+func (ff *FeatureFlag) Validate() error {
+	if ff.Id == "" {
+		return fmt.Errorf("id is required")
+	}
+	if strings.Contains(ff.Id, "%") {
+		return fmt.Errorf("id must not contain '%%'")
+	}
+	if ff.Enabled == nil {
+		return fmt.Errorf("enabled is required")
+	}
+
+	if ff.Conditions != nil {
+		switch ff.Conditions.RequirementType {
+		case "", RequirementTypeAny, RequirementTypeAll:
+		default:
+			return fmt.Errorf("conditions.requirement_type must be '%s' or '%s'", RequirementTypeAny, RequirementTypeAll)
+		}
+
+		for i, filter := range ff.Conditions.ClientFilters {
+			if filter.Name == "" {
+				return fmt.Errorf("conditions.client_filters[%d].name is required", i)
+			}
+		}
+	}
+
+	variants := map[string]bool{}
+	for i, variant := range ff.Variants {
+		if variant.Name == "" {
+			return fmt.Errorf("variants[%d].name is required", i)
+		}
+		if variants[variant.Name] {
+			return fmt.Errorf("variants[%d].name '%s' is not unique", i, variant.Name)
+		}
+		variants[variant.Name] = true
+
+		switch variant.StatusOverride {
+		case "", StatusOverrideNone, StatusOverrideEnabled, StatusOverrideDisabled:
+		default:
+			return fmt.Errorf("variants[%d].status_override must be one of None, Enabled or Disabled", i)
+		}
+	}
+
+	if ff.Allocation != nil {
+		return ff.Allocation.validate(variants)
+	}
+
+	return nil
+}
+
+func (fa *FeatureFlagAllocation) validate(variants map[string]bool) error {
+	checkVariant := func(field string, name string) error {
+		if name != "" && !variants[name] {
+			return fmt.Errorf("allocation.%s refers to unknown variant '%s'", field, name)
+		}
+		return nil
+	}
+
+	if err := checkVariant("default_when_disabled", fa.DefaultWhenDisabled); err != nil {
+		return err
+	}
+	if err := checkVariant("default_when_enabled", fa.DefaultWhenEnabled); err != nil {
+		return err
+	}
+
+	for i, user := range fa.User {
+		if err := checkVariant(fmt.Sprintf("user[%d].variant", i), user.Variant); err != nil {
+			return err
+		}
+	}
+
+	for i, group := range fa.Group {
+		if err := checkVariant(fmt.Sprintf("group[%d].variant", i), group.Variant); err != nil {
+			return err
+		}
+	}
+
+	for i, percentile := range fa.Percentile {
+		if err := checkVariant(fmt.Sprintf("percentile[%d].variant", i), percentile.Variant); err != nil {
+			return err
+		}
+		if percentile.From < 0 || percentile.To > 100 || percentile.From > percentile.To {
+			return fmt.Errorf("allocation.percentile[%d] must satisfy 0 <= from <= to <= 100", i)
+		}
+	}
+
+	return nil
+}
+
+// MarshalValue renders the feature flag as a key-value value string
+func (ff *FeatureFlag) MarshalValue() (string, error) {
+	b, err := json.Marshal(ff)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to marshal feature flag %s", ff.Id)
+	}
+	return string(b), nil
+}
+
+// IsEnabled is the flag's on/off state, ignoring filters
+func (ff *FeatureFlag) IsEnabled() bool {
+	return ff.Enabled != nil && *ff.Enabled
+}
+
+// GetFeatureFlags returns all feature flags in the store, ordered by key
+func (pcs *persistentConfigStore) GetFeatureFlags() ([]FeatureFlag, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	keys, err := pcs.getKeys()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list feature flag keys")
+	}
+
+	flags := []FeatureFlag{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, FeatureFlagKeyPrefix) {
+			continue
+		}
+
+		flag, err := pcs.getFeatureFlag(key)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+// GetFeatureFlag returns the latest version of the feature flag @param id
+func (pcs *persistentConfigStore) GetFeatureFlag(id string) (FeatureFlag, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.getFeatureFlag(FeatureFlagKey(id))
+}
+
+// PutFeatureFlag validates @param flag and stores it as a new version of its key
+func (pcs *persistentConfigStore) PutFeatureFlag(flag FeatureFlag) (FeatureFlag, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.putFeatureFlag(flag, map[string]string{})
+}
+
+// SetFeatureFlagEnabled creates a new version of the feature flag @param id
+// with its enabled state changed. Everything else about the flag is preserved.
+func (pcs *persistentConfigStore) SetFeatureFlagEnabled(id string, enabled bool) (FeatureFlag, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.setFeatureFlagEnabled(id, enabled)
+}
+
+// setFeatureFlagEnabled does the work of SetFeatureFlagEnabled.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) setFeatureFlagEnabled(id string, enabled bool) (FeatureFlag, error) {
+	key := FeatureFlagKey(id)
+	latest, err := pcs.getSettingLatestVersion(key, NullLabel)
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "feature flag %s not found", id)
+	}
+
+	flag, err := ParseFeatureFlag(key, latest.Value)
+	if err != nil {
+		return FeatureFlag{}, err
+	}
+
+	flag.Enabled = &enabled
+
+	return pcs.putFeatureFlag(flag, latest.Tags)
+}
+
+func (pcs *persistentConfigStore) getFeatureFlag(key string) (FeatureFlag, error) {
+	latest, err := pcs.getSettingLatestVersion(key, NullLabel)
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "feature flag %s not found", key)
+	}
+
+	return ParseFeatureFlag(key, latest.Value)
+}
+
+func (pcs *persistentConfigStore) putFeatureFlag(flag FeatureFlag, tags map[string]string) (FeatureFlag, error) {
+	err := flag.Validate()
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "invalid feature flag %s", flag.Id)
+	}
+
+	value, err := flag.MarshalValue()
+	if err != nil {
+		return FeatureFlag{}, err
+	}
+
+	_, err = pcs.updateSetting(FeatureFlagKey(flag.Id), value, SettingAttributes{
+		ContentType: FeatureFlagContentType,
+		Tags:        tags,
+	})
+	if err != nil {
+		return FeatureFlag{}, errors.Wrapf(err, "failed to store feature flag %s", flag.Id)
+	}
+
+	return flag, nil
+}
@@ -0,0 +1,217 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFeatureFlagValue = `{
+	"id": "Beta",
+	"enabled": true,
+	"conditions": {
+		"client_filters": [
+			{"name": "Microsoft.Percentage", "parameters": {"Value": 50}}
+		]
+	},
+	"variants": [
+		{"name": "Big", "configuration_value": 100},
+		{"name": "Small", "configuration_value": 1}
+	],
+	"allocation": {
+		"default_when_enabled": "Small",
+		"percentile": [{"variant": "Big", "from": 0, "to": 50}]
+	}
+}`
+
+func TestParseFeatureFlag(t *testing.T) {
+	t.Run("Valid feature flag", func(t *testing.T) {
+		flag, err := ParseFeatureFlag(FeatureFlagKey("Beta"), testFeatureFlagValue)
+		require.NoError(t, err)
+		require.Equal(t, "Beta", flag.Id)
+		require.True(t, flag.IsEnabled())
+		require.Len(t, flag.Variants, 2)
+	})
+
+	t.Run("Invalid feature flags", func(t *testing.T) {
+		invalid := map[string]string{
+			"missing enabled":   `{"id": "Beta"}`,
+			"id mismatch":       `{"id": "Alpha", "enabled": true}`,
+			"unknown field":     `{"id": "Beta", "enabled": true, "wibble": 1}`,
+			"unknown variant":   `{"id": "Beta", "enabled": true, "allocation": {"default_when_enabled": "Nope"}}`,
+			"bad percentile":    `{"id": "Beta", "enabled": true, "variants": [{"name": "A"}], "allocation": {"percentile": [{"variant": "A", "from": 60, "to": 40}]}}`,
+			"unnamed filter":    `{"id": "Beta", "enabled": true, "conditions": {"client_filters": [{"name": ""}]}}`,
+			"duplicate variant": `{"id": "Beta", "enabled": true, "variants": [{"name": "A"}, {"name": "A"}]}`,
+		}
+
+		for name, value := range invalid {
+			_, err := ParseFeatureFlag(FeatureFlagKey("Beta"), value)
+			require.Error(t, err, name)
+		}
+	})
+}
+
+func TestFeatureFlagPutKeyValue(t *testing.T) {
+	t.Run("Invalid feature flag is rejected", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		body, err := json.Marshal(map[string]string{
+			"value":        `{"id": "Beta"}`,
+			"content_type": FeatureFlagContentType,
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/.appconfig.featureflag%2FBeta?api-version=2023-10-01",
+			strings.NewReader(string(body)))
+		rq.Header.Set("Content-Type", "application/vnd.microsoft.appconfig.kv+json")
+		engine.ServeHTTP(rec, rq)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestFeatureFlagAdmin(t *testing.T) {
+	t.Run("Create, disable and list feature flag", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, AdminBasePath+"/featureflags/Beta",
+			strings.NewReader(testFeatureFlagValue))
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPost, AdminBasePath+"/featureflags/Beta/disable", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// The toggle is a new version of the same key, with the content type preserved
+		setting, err := store.GetConfigSetting(FeatureFlagKey("Beta"))
+		require.NoError(t, err)
+		require.Len(t, setting.Versions, 2)
+		require.Equal(t, FeatureFlagContentType, setting.Versions[0].ContentType)
+
+		flags, err := store.GetFeatureFlags()
+		require.NoError(t, err)
+		require.Len(t, flags, 1)
+		require.False(t, flags[0].IsEnabled())
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPost, AdminBasePath+"/featureflags/Missing/enable", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestFeatureFlagEvaluation(t *testing.T) {
+	enabled := true
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Targeting percentage matches the feature management libraries", func(t *testing.T) {
+		// Reference values computed from SHA-256 of the context ids
+		require.InDelta(t, 55.258553, targetingPercentage("Jeff\nBeta"), 0.000001)
+		require.InDelta(t, 64.099963, targetingPercentage("Alice\nallocation\nBeta"), 0.000001)
+	})
+
+	t.Run("Targeting filter", func(t *testing.T) {
+		flag := FeatureFlag{
+			Id:      "Beta",
+			Enabled: &enabled,
+			Conditions: &FeatureFlagConditions{
+				ClientFilters: []FeatureFlagFilter{{
+					Name: TargetingFilterName,
+					Parameters: map[string]interface{}{
+						"Audience": map[string]interface{}{
+							"Users":                    []string{"Alice"},
+							"Groups":                   []map[string]interface{}{{"Name": "Ring0", "RolloutPercentage": 100}},
+							"DefaultRolloutPercentage": 56,
+							"Exclusion":                map[string]interface{}{"Users": []string{"Mallory"}},
+						},
+					},
+				}},
+			},
+		}
+
+		cases := map[string]struct {
+			context  TargetingContext
+			expected bool
+		}{
+			"listed user":          {TargetingContext{UserId: "Alice"}, true},
+			"group member":         {TargetingContext{UserId: "Bob", Groups: []string{"Ring0"}}, true},
+			"excluded user":        {TargetingContext{UserId: "Mallory", Groups: []string{"Ring0"}}, false},
+			"inside default roll":  {TargetingContext{UserId: "Jeff"}, true},
+			"anonymous, no groups": {TargetingContext{}, false},
+		}
+
+		for name, tc := range cases {
+			evaluation, err := flag.Evaluate(tc.context, now)
+			require.NoError(t, err, name)
+			require.Equal(t, tc.expected, evaluation.Enabled, name)
+		}
+	})
+
+	t.Run("Time window filter", func(t *testing.T) {
+		flag := FeatureFlag{
+			Id:      "Beta",
+			Enabled: &enabled,
+			Conditions: &FeatureFlagConditions{
+				ClientFilters: []FeatureFlagFilter{{
+					Name: TimeWindowFilterName,
+					Parameters: map[string]interface{}{
+						"Start": "Sat, 01 Jun 2024 00:00:00 GMT",
+						"End":   "Sun, 02 Jun 2024 00:00:00 GMT",
+					},
+				}},
+			},
+		}
+
+		evaluation, err := flag.Evaluate(TargetingContext{}, now)
+		require.NoError(t, err)
+		require.True(t, evaluation.Enabled)
+
+		evaluation, err = flag.Evaluate(TargetingContext{}, now.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.False(t, evaluation.Enabled)
+	})
+
+	t.Run("Variant allocation", func(t *testing.T) {
+		flag, err := ParseFeatureFlag(FeatureFlagKey("Beta"), `{
+			"id": "Beta",
+			"enabled": true,
+			"variants": [{"name": "On"}, {"name": "Off", "status_override": "Disabled"}],
+			"allocation": {
+				"default_when_enabled": "On",
+				"default_when_disabled": "Off",
+				"user": [{"variant": "Off", "users": ["Bob"]}],
+				"percentile": [{"variant": "On", "from": 0, "to": 50}, {"variant": "Off", "from": 50, "to": 100}]
+			}
+		}`)
+		require.NoError(t, err)
+
+		evaluation, err := flag.Evaluate(TargetingContext{UserId: "Bob"}, now)
+		require.NoError(t, err)
+		require.Equal(t, ReasonUser, evaluation.Reason)
+		require.False(t, evaluation.Enabled)
+
+		// Alice hashes to 64.1, which is in the second percentile range
+		evaluation, err = flag.Evaluate(TargetingContext{UserId: "Alice"}, now)
+		require.NoError(t, err)
+		require.Equal(t, ReasonPercentile, evaluation.Reason)
+		require.Equal(t, "Off", evaluation.Variant.Name)
+
+		disabled := false
+		flag.Enabled = &disabled
+		evaluation, err = flag.Evaluate(TargetingContext{UserId: "Alice"}, now)
+		require.NoError(t, err)
+		require.Equal(t, ReasonDefaultWhenDisabled, evaluation.Reason)
+		require.False(t, evaluation.Enabled)
+	})
+}
@@ -51,7 +51,8 @@ func newFilter(filterString string) (filter, error) {
 			return filter{}, fmt.Errorf("filters can only use * at the end")
 		}
 
-		r, err := regexp.Compile(fmt.Sprintf("%s./*", strings.Trim(filterString, "*")))
+		// A prefix match
+		r, err := regexp.Compile("^" + regexp.QuoteMeta(strings.TrimSuffix(filterString, "*")))
 		if err != nil {
 			return filter{}, fmt.Errorf("error compiling filter regexp")
 		}
@@ -65,12 +66,9 @@ func newFilter(filterString string) (filter, error) {
 	for _, sub := range subs {
 		// Allow for foolish callers using whitespace, we're generous
 		trimSub := strings.Trim(sub, " ")
-		if strings.Contains(trimSub, "*") {
-
-		}
 
 		// Must be an exact match
-		r, err := regexp.Compile(trimSub)
+		r, err := regexp.Compile("^" + regexp.QuoteMeta(trimSub) + "$")
 		if err != nil {
 			return filter{}, fmt.Errorf("error compiling filter regexp")
 		}
@@ -79,3 +77,36 @@ func newFilter(filterString string) (filter, error) {
 
 	return f, nil
 }
+
+// nullLabelFilter is how clients ask for settings without a label
+const nullLabelFilter = "\x00"
+
+// exactFilter matches only the one string it holds
+type exactFilter string
+
+var _ Filter = (*exactFilter)(nil)
+
+func (ef exactFilter) Apply(s string) bool {
+	return string(ef) == s
+}
+
+// newLabelFilter builds the Filter for an optional label query parameter.
+// When absent every label matches; "\0", alone or in a CSV, matches the
+// null label.
+func newLabelFilter(filterString *string) (Filter, error) {
+	if filterString == nil || *filterString == "" || *filterString == "*" {
+		return nullFilter{}, nil
+	}
+	if *filterString == nullLabelFilter {
+		return exactFilter(NullLabel), nil
+	}
+
+	subs := strings.Split(*filterString, ",")
+	for i, sub := range subs {
+		if strings.Trim(sub, " ") == nullLabelFilter {
+			subs[i] = NullLabel
+		}
+	}
+
+	return newFilter(strings.Join(subs, ","))
+}
@@ -0,0 +1,410 @@
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+)
+
+// A DirectorySync reconciles the store to match a directory of YAML (or
+// JSON) files, such as a checkout of a configuration repository:
+//
+//	config/
+//	  app.yaml          settings with the null label
+//	  prod/app.yaml     settings with the label "prod"
+//
+// Files use the Azure CLI default profile, so they may be nested and may
+// hold a feature management section. Settings that differ get a new
+// version. Settings the directory produced, and that it no longer holds, are
+// deleted from the store; other settings, such as those seeded or written
+// through the API, are left alone. Which settings the directory produced is
+// kept in the store, so that files removed while the emulator was stopped
+// are deleted when it starts again. Locked settings are neither updated nor
+// deleted.
+
+// SYNC_COLLECTION_NAME holds, for each synced directory, the settings it
+// has produced
+const SYNC_COLLECTION_NAME = "synced"
+
+// directorySyncDebounce gives editors and git checkouts time to finish
+// writing before the store is reconciled
+const directorySyncDebounce = 200 * time.Millisecond
+
+type DirectorySync struct {
+	sync.Mutex
+	store     *persistentConfigStore
+	dir       string
+	separator string
+}
+
+// SyncResult counts the changes made by one reconciliation
+type SyncResult struct {
+	Created int
+	Updated int
+	Deleted int
+}
+
+// NewDirectorySync syncs @param store to the files in @param dir, nesting
+// keys on @param separator (DefaultSeedSeparator if empty).
+func NewDirectorySync(store *persistentConfigStore, dir string, separator string) *DirectorySync {
+	if separator == "" {
+		separator = DefaultSeedSeparator
+	}
+
+	return &DirectorySync{
+		store:     store,
+		dir:       dir,
+		separator: separator,
+	}
+}
+
+// Reconcile makes the store match the directory. If any file is invalid,
+// or holds a key-value the store would refuse, the store is left untouched.
+func (ds *DirectorySync) Reconcile() (result SyncResult, err error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	desired, err := ds.desiredSettings()
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	settings, err := ds.store.GetSettings()
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	managed, err := ds.store.GetSyncedSettings(ds.syncedDir())
+	if err != nil {
+		return SyncResult{}, err
+	}
+	// The changes made are recorded even if a later one fails
+	defer func() {
+		setErr := ds.store.SetSyncedSettings(ds.syncedDir(), managed)
+		if err == nil {
+			err = setErr
+		}
+	}()
+
+	existing := map[settingId]bool{}
+
+	for _, setting := range settings {
+		id := settingId{setting.Key, setting.Label}
+		existing[id] = true
+
+		record, wanted := desired[id]
+		if !wanted {
+			if !managed[id] {
+				continue
+			}
+			if setting.Locked {
+				slog.Warn("not deleting locked setting", "key", setting.Key, "label", setting.Label)
+				continue
+			}
+
+			err = ds.store.DeleteLabeledSetting(setting.Key, setting.Label)
+			if err != nil {
+				return result, errors.Wrapf(err, "failed to delete %s", id)
+			}
+			delete(managed, id)
+			result.Deleted++
+			continue
+		}
+		managed[id] = true
+
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return result, err
+		}
+		if latest.Value == record.Value && latest.ContentType == record.ContentType &&
+			maps.Equal(latest.Tags, record.Tags) {
+			continue
+		}
+
+		if setting.Locked {
+			slog.Warn("not syncing locked setting", "key", setting.Key, "label", setting.Label)
+			continue
+		}
+
+		_, err = ds.store.UpdateLabeledSetting(record.Key, record.Label, record.Value, SettingAttributes{
+			ContentType: record.ContentType,
+			Tags:        record.Tags,
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "failed to update %s", id)
+		}
+		result.Updated++
+	}
+
+	ids := slices.SortedFunc(maps.Keys(desired), func(a, b settingId) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	for _, id := range ids {
+		if existing[id] {
+			continue
+		}
+
+		record := desired[id]
+		_, err = ds.store.UpdateLabeledSetting(record.Key, record.Label, record.Value, SettingAttributes{
+			ContentType: record.ContentType,
+			Tags:        record.Tags,
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "failed to create %s", id)
+		}
+		managed[id] = true
+		result.Created++
+	}
+
+	return result, nil
+}
+
+// Watch reconciles the store whenever a file in the directory changes,
+// until @param ctx is done. Failed reconciliations are logged, and retried
+// on the next change.
+func (ds *DirectorySync) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create file watcher")
+	}
+	defer watcher.Close()
+
+	err = ds.watchDirs(watcher)
+	if err != nil {
+		return err
+	}
+
+	// A nil channel blocks until the first change arms the timer
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !isHiddenPath(event.Name) {
+					_ = ds.watchDirs(watcher)
+				}
+			}
+			debounce = time.After(directorySyncDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("file watcher error", "dir", ds.dir, "error", err)
+
+		case <-debounce:
+			debounce = nil
+			result, err := ds.Reconcile()
+			if err != nil {
+				slog.Error("failed to sync directory", "dir", ds.dir, "error", err)
+				continue
+			}
+			slog.Info("synced directory", "dir", ds.dir,
+				"created", result.Created, "updated", result.Updated, "deleted", result.Deleted)
+		}
+	}
+}
+
+// syncedDir identifies the directory in the store, by its absolute path
+func (ds *DirectorySync) syncedDir() string {
+	dir, err := filepath.Abs(ds.dir)
+	if err != nil {
+		return filepath.Clean(ds.dir)
+	}
+	return dir
+}
+
+// watchDirs adds the directory and every label subdirectory to @param watcher
+func (ds *DirectorySync) watchDirs(watcher *fsnotify.Watcher) error {
+	return filepath.WalkDir(ds.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != ds.dir && isHiddenPath(d.Name()) {
+			return filepath.SkipDir
+		}
+
+		err = watcher.Add(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to watch %s", path)
+		}
+		return nil
+	})
+}
+
+type settingId struct {
+	Key   string
+	Label string
+}
+
+func (id settingId) String() string {
+	if id.Label == NullLabel {
+		return id.Key
+	}
+	return fmt.Sprintf("%s (label %s)", id.Key, id.Label)
+}
+
+// desiredSettings reads every file in the directory
+func (ds *DirectorySync) desiredSettings() (map[settingId]SettingRecord, error) {
+	desired := map[settingId]SettingRecord{}
+	sources := map[settingId]string{}
+
+	err := filepath.WalkDir(ds.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != ds.dir && isHiddenPath(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		format, err := seedFormatOf(path)
+		if err != nil || (format != SeedFormatYAML && format != SeedFormatJSON) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ds.dir, path)
+		if err != nil {
+			return err
+		}
+
+		// The first directory below the root names the label
+		label := NullLabel
+		if dir, _, found := strings.Cut(filepath.ToSlash(rel), "/"); found {
+			label = dir
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		opts := ImportOptions{Format: format, Separator: ds.separator, Label: label}
+		records, err := parseSeed(data, opts)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", rel)
+		}
+
+		for _, record := range records {
+			record = opts.apply(record)
+			if record.Tags == nil {
+				record.Tags = map[string]string{}
+			}
+
+			if IsFeatureFlag(record.Key, record.ContentType) {
+				_, err = ParseFeatureFlag(record.Key, record.Value)
+				if err != nil {
+					return errors.Wrapf(err, "invalid %s", rel)
+				}
+			}
+			err = ValidateKeyValue(record.Key, record.Label, record.Value, SettingAttributes{
+				ContentType: record.ContentType,
+				Tags:        record.Tags,
+			})
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", rel)
+			}
+
+			id := settingId{record.Key, record.Label}
+			if source, duplicate := sources[id]; duplicate {
+				return fmt.Errorf("%s is defined in both %s and %s", id, source, rel)
+			}
+			sources[id] = rel
+			desired[id] = record
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return desired, nil
+}
+
+func isHiddenPath(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), ".")
+}
+
+// syncedSettings is the document of the settings a synced directory has
+// produced
+type syncedSettings struct {
+	Dir      string
+	Settings []settingId
+}
+
+// GetSyncedSettings returns the settings the synced directory @param dir
+// has produced
+func (pcs *persistentConfigStore) GetSyncedSettings(dir string) (map[settingId]bool, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	doc, err := pcs.cdb.Query(SYNC_COLLECTION_NAME).Where(clover.Field("Dir").Eq(dir)).FindFirst()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find the settings synced from %s", dir)
+	}
+
+	ids := map[settingId]bool{}
+	if doc == nil {
+		return ids, nil
+	}
+
+	var synced syncedSettings
+	err = doc.Unmarshal(&synced)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal the settings synced from %s", dir)
+	}
+	for _, id := range synced.Settings {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// SetSyncedSettings records @param ids as the settings the synced directory
+// @param dir has produced
+func (pcs *persistentConfigStore) SetSyncedSettings(dir string, ids map[settingId]bool) error {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	err := pcs.cdb.Query(SYNC_COLLECTION_NAME).Where(clover.Field("Dir").Eq(dir)).Delete()
+	if err != nil {
+		return errors.Wrapf(err, "failed to replace the settings synced from %s", dir)
+	}
+
+	synced := syncedSettings{Dir: dir, Settings: []settingId{}}
+	for id := range ids {
+		synced.Settings = append(synced.Settings, id)
+	}
+	_, err = pcs.cdb.InsertOne(SYNC_COLLECTION_NAME, clover.NewDocumentOf(synced))
+	if err != nil {
+		return errors.Wrapf(err, "failed to record the settings synced from %s", dir)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package emulator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeSyncFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestDirectorySync(t *testing.T) {
+	t.Run("Reconcile creates, updates and deletes per label", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo\n  Port: 8080\n")
+		writeSyncFile(t, filepath.Join(dir, "prod", "app.yaml"), "App:\n  Name: demo-prod\n")
+		writeSyncFile(t, filepath.Join(dir, ".git", "config.yaml"), "ignored: true\n")
+		writeSyncFile(t, filepath.Join(dir, "README.md"), "not settings\n")
+
+		_, err = store.UpdateSetting("Unmanaged", "not the directory's")
+		require.NoError(t, err)
+
+		sync := NewDirectorySync(store, dir, "")
+		result, err := sync.Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{Created: 3}, result)
+
+		setting, err := store.GetLabeledConfigSetting("App:Name", "prod")
+		require.NoError(t, err)
+		require.Equal(t, "demo-prod", setting.Versions[0].Value)
+
+		// Reconciling an unchanged directory makes no new versions
+		result, err = sync.Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{}, result)
+
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo2\n")
+		result, err = sync.Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{Updated: 1, Deleted: 1}, result)
+
+		setting, err = store.GetConfigSetting("App:Name")
+		require.NoError(t, err)
+		require.Len(t, setting.Versions, 2)
+		require.Equal(t, "demo2", setting.Versions[0].Value)
+
+		// Only settings the directory produced are deleted
+		value, err := store.GetSetting("Unmanaged")
+		require.NoError(t, err)
+		require.Equal(t, "not the directory's", value)
+	})
+
+	t.Run("Locked settings are neither updated nor deleted", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo\n  Port: 8080\n")
+
+		sync := NewDirectorySync(store, dir, "")
+		_, err = sync.Reconcile()
+		require.NoError(t, err)
+
+		_, err = store.LockLabeledSetting("App:Name", NullLabel)
+		require.NoError(t, err)
+		_, err = store.LockLabeledSetting("App:Port", NullLabel)
+		require.NoError(t, err)
+
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo2\n")
+		result, err := sync.Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{}, result)
+
+		value, err := store.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "demo", value)
+		_, err = store.GetSetting("App:Port")
+		require.NoError(t, err)
+
+		// Once unlocked, the next reconciliation catches up
+		_, err = store.UnlockLabeledSetting("App:Port", NullLabel)
+		require.NoError(t, err)
+		result, err = sync.Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{Deleted: 1}, result)
+	})
+
+	t.Run("Invalid files leave the store untouched", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		writeSyncFile(t, filepath.Join(dir, "a.yaml"), "App:\n  Name: demo\n")
+		writeSyncFile(t, filepath.Join(dir, "b.yaml"), "App:\n  Name: duplicate\n")
+
+		_, err = store.UpdateSetting("Existing", "kept")
+		require.NoError(t, err)
+
+		_, err = NewDirectorySync(store, dir, "").Reconcile()
+		require.ErrorContains(t, err, "defined in both")
+
+		value, err := store.GetSetting("Existing")
+		require.NoError(t, err)
+		require.Equal(t, "kept", value)
+	})
+
+	t.Run("Key-values the store would refuse leave the store untouched", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo\n  Port: 8080\n")
+
+		sync := NewDirectorySync(store, dir, "")
+		_, err = sync.Reconcile()
+		require.NoError(t, err)
+
+		// Port is removed and Name changed, but Rate% is not a valid key
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo2\n  Rate%: 5\n")
+		_, err = sync.Reconcile()
+		require.ErrorContains(t, err, "must not contain '%'")
+
+		value, err := store.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "demo", value)
+		_, err = store.GetSetting("App:Port")
+		require.NoError(t, err)
+	})
+
+	t.Run("Files removed while stopped are deleted on the next start", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: demo\n")
+		writeSyncFile(t, filepath.Join(dir, "db.yaml"), "Db:\n  Host: localhost\n")
+
+		_, err = NewDirectorySync(store, dir, "").Reconcile()
+		require.NoError(t, err)
+
+		require.NoError(t, os.Remove(filepath.Join(dir, "db.yaml")))
+
+		result, err := NewDirectorySync(store, dir, "").Reconcile()
+		require.NoError(t, err)
+		require.Equal(t, SyncResult{Deleted: 1}, result)
+
+		_, err = store.GetSetting("Db:Host")
+		require.ErrorIs(t, err, ErrSettingNotFound)
+		value, err := store.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "demo", value)
+	})
+
+	t.Run("Watch applies file changes", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		dir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan error)
+		go func() {
+			done <- NewDirectorySync(store, dir, "").Watch(ctx)
+		}()
+
+		// Give the watcher time to start
+		time.Sleep(100 * time.Millisecond)
+		writeSyncFile(t, filepath.Join(dir, "app.yaml"), "App:\n  Name: watched\n")
+
+		require.Eventually(t, func() bool {
+			value, err := store.GetSetting("App:Name")
+			return err == nil && value == "watched"
+		}, 5*time.Second, 50*time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
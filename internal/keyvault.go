@@ -0,0 +1,272 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+)
+
+// Key Vault references are key-values whose value points at a Key Vault
+// secret. Optionally, the emulator can stand in for Key Vault itself: a
+// minimal secrets API is served from the same engine, with secrets kept in
+// their own collection of the store.
+
+const (
+	KeyVaultRefContentType = "application/vnd.microsoft.appconfig.keyvaultref+json;charset=utf-8"
+
+	keyVaultRefMediaType = "application/vnd.microsoft.appconfig.keyvaultref+json"
+)
+
+var secretNameRegexp = regexp.MustCompile(`^[0-9a-zA-Z-]{1,127}$`)
+
+// KeyVaultReference is the value of a Key Vault reference key-value
+type KeyVaultReference struct {
+	Uri string `json:"uri"`
+
+	// Parsed from Uri. SecretVersion is empty for a reference to the latest version.
+	SecretName    string `json:"-"`
+	SecretVersion string `json:"-"`
+}
+
+// IsKeyVaultReference reports whether @param contentType marks a Key Vault reference
+func IsKeyVaultReference(contentType string) bool {
+	return strings.HasPrefix(contentType, keyVaultRefMediaType)
+}
+
+// ParseKeyVaultReference decodes and validates a Key Vault reference value
+func ParseKeyVaultReference(value string) (KeyVaultReference, error) {
+	var ref KeyVaultReference
+	err := json.Unmarshal([]byte(value), &ref)
+	if err != nil {
+		return KeyVaultReference{}, errors.Wrap(err, "invalid Key Vault reference JSON")
+	}
+
+	if ref.Uri == "" {
+		return KeyVaultReference{}, fmt.Errorf("uri is required")
+	}
+
+	u, err := url.Parse(ref.Uri)
+	if err != nil {
+		return KeyVaultReference{}, errors.Wrap(err, "invalid uri")
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return KeyVaultReference{}, fmt.Errorf("uri must be an absolute http(s) URL")
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || len(segments) > 3 || segments[0] != "secrets" {
+		return KeyVaultReference{}, fmt.Errorf("uri must be of the form https://{vault}/secrets/{name}[/{version}]")
+	}
+	if !secretNameRegexp.MatchString(segments[1]) {
+		return KeyVaultReference{}, fmt.Errorf("invalid secret name '%s'", segments[1])
+	}
+
+	ref.SecretName = segments[1]
+	if len(segments) == 3 {
+		ref.SecretVersion = segments[2]
+	}
+
+	return ref, nil
+}
+
+////////////////////
+// Secrets store  //
+////////////////////
+
+// SecretAttributes follows the Key Vault secret attributes object
+type SecretAttributes struct {
+	Enabled       bool   `json:"enabled"`
+	Created       int64  `json:"created"`
+	Updated       int64  `json:"updated"`
+	RecoveryLevel string `json:"recoveryLevel"`
+}
+
+// SecretBundle follows the Key Vault secret bundle returned by the secrets API
+type SecretBundle struct {
+	Id          string            `json:"id"`
+	Value       string            `json:"value"`
+	ContentType string            `json:"contentType,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Attributes  SecretAttributes  `json:"attributes"`
+}
+
+type setSecretParameters struct {
+	Value       *string           `json:"value"`
+	ContentType string            `json:"contentType"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// SetSecret stores a new version of secret @param name. Secrets reuse the
+// ConfigSetting document shape, one document per secret name.
+func (pcs *persistentConfigStore) SetSecret(name string, value string, attrs SettingAttributes) (ConfigSettingVersion, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	version := NewConfigSettingVersionNow(value, attrs)
+	// Key Vault versions are 32 hex characters
+	version.Uuid = strings.ReplaceAll(uuid.NewString(), "-", "")
+
+	query := pcs.cdb.Query(SECRET_COLLECTION_NAME).Where(clover.Field("Key").Eq(name))
+	doc, err := query.FindFirst()
+	if err != nil {
+		return ConfigSettingVersion{}, errors.Wrapf(err, "failed to find secret %s", name)
+	}
+
+	secret := ConfigSetting{Key: name}
+	if doc != nil {
+		err = doc.Unmarshal(&secret)
+		if err != nil {
+			return ConfigSettingVersion{}, errors.Wrapf(err, "failed to unmarshal secret %s", name)
+		}
+
+		err = query.DeleteById(doc.ObjectId())
+		if err != nil {
+			return ConfigSettingVersion{}, errors.Wrapf(err, "failed to replace secret %s", name)
+		}
+	}
+
+	secret.Versions = append([]ConfigSettingVersion{version}, secret.Versions...)
+
+	_, err = pcs.cdb.InsertOne(SECRET_COLLECTION_NAME, clover.NewDocumentOf(secret))
+	if err != nil {
+		return ConfigSettingVersion{}, errors.Wrapf(err, "failed to store secret %s", name)
+	}
+
+	return version, nil
+}
+
+// GetSecret returns version @param version of secret @param name, or the
+// latest version if @param version is empty.
+func (pcs *persistentConfigStore) GetSecret(name string, version string) (ConfigSettingVersion, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	doc, err := pcs.cdb.Query(SECRET_COLLECTION_NAME).Where(clover.Field("Key").Eq(name)).FindFirst()
+	if err != nil {
+		return ConfigSettingVersion{}, errors.Wrapf(err, "failed to find secret %s", name)
+	}
+	if doc == nil {
+		return ConfigSettingVersion{}, errors.Wrapf(ErrSettingNotFound, "secret %s", name)
+	}
+
+	var secret ConfigSetting
+	err = doc.Unmarshal(&secret)
+	if err != nil {
+		return ConfigSettingVersion{}, errors.Wrapf(err, "failed to unmarshal secret %s", name)
+	}
+
+	if version == "" {
+		return secret.GetLatest()
+	}
+
+	for _, v := range secret.Versions {
+		if v.Uuid == version {
+			return v, nil
+		}
+	}
+
+	return ConfigSettingVersion{}, errors.Wrapf(ErrSettingNotFound, "secret %s version %s", name, version)
+}
+
+////////////////////
+// Secrets API    //
+////////////////////
+
+type keyVaultServer struct {
+	configStore *persistentConfigStore
+}
+
+func registerKeyVaultRoutes(g *gin.RouterGroup, configStore *persistentConfigStore) {
+	kvs := keyVaultServer{configStore: configStore}
+
+	g.PUT("/secrets/:name", kvs.setSecret)
+	g.GET("/secrets/:name", kvs.getSecret)
+	g.GET("/secrets/:name/:version", kvs.getSecret)
+}
+
+func (kvs *keyVaultServer) setSecret(c *gin.Context) {
+	name := c.Param("name")
+	if !secretNameRegexp.MatchString(name) {
+		writeKeyVaultError(c, http.StatusBadRequest, "BadParameter", "invalid secret name")
+		return
+	}
+
+	var params setSecretParameters
+	err := c.ShouldBindJSON(&params)
+	if err != nil || params.Value == nil {
+		writeKeyVaultError(c, http.StatusBadRequest, "BadParameter", "the request body must contain a value")
+		return
+	}
+
+	span := startStoreSpan(c, "SetSecret")
+	version, err := kvs.configStore.SetSecret(name, *params.Value, SettingAttributes{
+		ContentType: params.ContentType,
+		Tags:        params.Tags,
+	})
+	endStoreSpan(span, err)
+	if err != nil {
+		writeKeyVaultError(c, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, secretBundle(c, name, version))
+}
+
+func (kvs *keyVaultServer) getSecret(c *gin.Context) {
+	name := c.Param("name")
+	span := startStoreSpan(c, "GetSecret")
+	version, err := kvs.configStore.GetSecret(name, c.Param("version"))
+	endStoreSpan(span, err)
+	if errors.Is(err, ErrSettingNotFound) {
+		writeKeyVaultError(c, http.StatusNotFound, "SecretNotFound",
+			fmt.Sprintf("A secret with (name/id) %s was not found in this key vault.", name))
+		return
+	}
+	if err != nil {
+		writeKeyVaultError(c, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, secretBundle(c, name, version))
+}
+
+func secretBundle(c *gin.Context, name string, version ConfigSettingVersion) SecretBundle {
+	scheme := "https"
+	if c.Request.TLS == nil {
+		scheme = "http"
+	}
+
+	timestamp := version.Timestamp.Truncate(time.Second).Unix()
+
+	return SecretBundle{
+		Id:          fmt.Sprintf("%s://%s/secrets/%s/%s", scheme, c.Request.Host, name, version.Uuid),
+		Value:       version.Value,
+		ContentType: version.ContentType,
+		Tags:        version.Tags,
+		Attributes: SecretAttributes{
+			Enabled:       true,
+			Created:       timestamp,
+			Updated:       timestamp,
+			RecoveryLevel: "Recoverable+Purgeable",
+		},
+	}
+}
+
+// writeKeyVaultError writes an error in Key Vault's (not App Configuration's) format
+func writeKeyVaultError(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": gin.H{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
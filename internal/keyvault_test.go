@@ -0,0 +1,85 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyVaultReference(t *testing.T) {
+	t.Run("Valid references", func(t *testing.T) {
+		ref, err := ParseKeyVaultReference(`{"uri": "https://myvault.vault.azure.net/secrets/db-password"}`)
+		require.NoError(t, err)
+		require.Equal(t, "db-password", ref.SecretName)
+		require.Empty(t, ref.SecretVersion)
+
+		ref, err = ParseKeyVaultReference(`{"uri": "https://myvault.vault.azure.net/secrets/db-password/0123456789abcdef0123456789abcdef"}`)
+		require.NoError(t, err)
+		require.Equal(t, "0123456789abcdef0123456789abcdef", ref.SecretVersion)
+	})
+
+	t.Run("Invalid references", func(t *testing.T) {
+		for _, value := range []string{
+			`not json`,
+			`{}`,
+			`{"uri": "myvault/secrets/db-password"}`,
+			`{"uri": "https://myvault.vault.azure.net/keys/db-password"}`,
+			`{"uri": "https://myvault.vault.azure.net/secrets/db_password"}`,
+		} {
+			_, err := ParseKeyVaultReference(value)
+			require.Error(t, err, value)
+		}
+	})
+}
+
+func TestKeyVaultSecrets(t *testing.T) {
+	t.Run("Set and get secret versions", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithKeyVault())
+
+		setSecret := func(value string) SecretBundle {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodPut, "/secrets/db-password?api-version=7.4",
+				strings.NewReader(`{"value": "`+value+`"}`))
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			var bundle SecretBundle
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &bundle))
+			return bundle
+		}
+
+		first := setSecret("hunter2")
+		setSecret("correct-horse")
+
+		getSecret := func(path string) (int, SecretBundle) {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodGet, path+"?api-version=7.4", nil)
+			engine.ServeHTTP(rec, rq)
+
+			var bundle SecretBundle
+			json.Unmarshal(rec.Body.Bytes(), &bundle)
+			return rec.Code, bundle
+		}
+
+		code, latest := getSecret("/secrets/db-password")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "correct-horse", latest.Value)
+
+		version := first.Id[strings.LastIndex(first.Id, "/")+1:]
+		code, old := getSecret("/secrets/db-password/" + version)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "hunter2", old.Value)
+
+		code, _ = getSecret("/secrets/missing")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+}
@@ -0,0 +1,90 @@
+package emulator
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The service refuses key-values that break its limits on size and on the
+// characters of keys; so does the emulator, wherever settings are written,
+// so that a configuration it accepts will also be accepted in production.
+
+const (
+	// maxKeyValueSize bounds the size of a key-value: its key, label,
+	// value, content type and tags
+	maxKeyValueSize = 10 * 1024
+	// maxTags bounds the tags of a key-value
+	maxTags = 20
+
+	errTypePayloadTooLarge = "https://azconfig.io/errors/payload-too-large"
+)
+
+// A KeyValueError describes a key-value the service would refuse
+type KeyValueError struct {
+	// Status is the status code of the service's response: 400 for an
+	// invalid key-value, or 413 for one that is too large
+	Status int
+	// Name is the part of the key-value at fault: key, label, value or tags
+	Name   string
+	Detail string
+}
+
+func (kve *KeyValueError) Error() string {
+	return kve.Detail
+}
+
+// title summarises the error, as in the service's response
+func (kve *KeyValueError) title() string {
+	if kve.Status == http.StatusRequestEntityTooLarge {
+		return "Key-value too large"
+	}
+	return "Invalid key-value"
+}
+
+// errType is the type of the service's response
+func (kve *KeyValueError) errType() string {
+	if kve.Status == http.StatusRequestEntityTooLarge {
+		return errTypePayloadTooLarge
+	}
+	return errTypeInvalidArgument
+}
+
+// ValidateKeyValue checks a key-value against the service's limits,
+// returning a *KeyValueError if it breaks one
+func ValidateKeyValue(key string, label string, value string, attrs SettingAttributes) error {
+	switch {
+	case key == "":
+		return &KeyValueError{Status: http.StatusBadRequest, Name: "key", Detail: "the key must not be empty"}
+	case key == "." || key == "..":
+		return &KeyValueError{Status: http.StatusBadRequest, Name: "key", Detail: fmt.Sprintf("'%s' is not a valid key", key)}
+	case strings.Contains(key, "%"):
+		return &KeyValueError{Status: http.StatusBadRequest, Name: "key", Detail: "the key must not contain '%'"}
+	}
+
+	if len(attrs.Tags) > maxTags {
+		return &KeyValueError{Status: http.StatusBadRequest, Name: "tags",
+			Detail: fmt.Sprintf("a key-value may have at most %d tags, not %d", maxTags, len(attrs.Tags))}
+	}
+	for name := range attrs.Tags {
+		if name == "" {
+			return &KeyValueError{Status: http.StatusBadRequest, Name: "tags", Detail: "tag names must not be empty"}
+		}
+	}
+
+	if size := keyValueSize(key, label, value, attrs); size > maxKeyValueSize {
+		return &KeyValueError{Status: http.StatusRequestEntityTooLarge, Name: "value",
+			Detail: fmt.Sprintf("the key-value is %d bytes, over the limit of %d", size, maxKeyValueSize)}
+	}
+
+	return nil
+}
+
+// keyValueSize is the storage taken by a key-value
+func keyValueSize(key string, label string, value string, attrs SettingAttributes) int64 {
+	size := len(key) + len(label) + len(value) + len(attrs.ContentType)
+	for name, tag := range attrs.Tags {
+		size += len(name) + len(tag)
+	}
+	return int64(size)
+}
@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueLimits(t *testing.T) {
+	t.Run("Keys, tags and sizes are validated", func(t *testing.T) {
+		manyTags := map[string]string{}
+		for i := 0; i <= maxTags; i++ {
+			manyTags[fmt.Sprintf("tag%d", i)] = "x"
+		}
+
+		for _, tc := range []struct {
+			name   string
+			key    string
+			value  string
+			attrs  SettingAttributes
+			status int
+			part   string
+		}{
+			{name: "empty key", key: "", status: http.StatusBadRequest, part: "key"},
+			{name: "dot key", key: ".", status: http.StatusBadRequest, part: "key"},
+			{name: "dot dot key", key: "..", status: http.StatusBadRequest, part: "key"},
+			{name: "percent in key", key: "App:50%", status: http.StatusBadRequest, part: "key"},
+			{name: "too many tags", key: "App", attrs: SettingAttributes{Tags: manyTags}, status: http.StatusBadRequest, part: "tags"},
+			{name: "empty tag name", key: "App", attrs: SettingAttributes{Tags: map[string]string{"": "x"}}, status: http.StatusBadRequest, part: "tags"},
+			{name: "too large", key: "App", value: strings.Repeat("x", maxKeyValueSize), status: http.StatusRequestEntityTooLarge, part: "value"},
+			{name: "valid", key: "App:.config/a..b", value: strings.Repeat("x", maxKeyValueSize-len("App:.config/a..b"))},
+		} {
+			err := ValidateKeyValue(tc.key, NullLabel, tc.value, tc.attrs)
+			if tc.status == 0 {
+				require.NoError(t, err, tc.name)
+				continue
+			}
+
+			var kvErr *KeyValueError
+			require.ErrorAs(t, err, &kvErr, tc.name)
+			require.Equal(t, tc.status, kvErr.Status, tc.name)
+			require.Equal(t, tc.part, kvErr.Name, tc.name)
+		}
+	})
+
+	t.Run("The store refuses invalid key-values", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting("..", "value")
+		require.Error(t, err)
+		_, err = store.CreateSetting("App%", "value")
+		require.Error(t, err)
+
+		settings, err := store.GetSettings()
+		require.NoError(t, err)
+		require.Empty(t, settings)
+	})
+
+	t.Run("PutKeyValue responds with the service's errors", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		put := func(path string, body string) (int, map[string]interface{}) {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(rec, rq)
+
+			var problem map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &problem)
+			return rec.Code, problem
+		}
+
+		status, problem := put("/kv/App%2525?api-version=2023-10-01", `{"value": "x"}`)
+		require.Equal(t, http.StatusBadRequest, status)
+		require.Equal(t, errTypeInvalidArgument, problem["type"])
+		require.Equal(t, "key", problem["name"])
+
+		status, problem = put("/kv/App?api-version=2023-10-01", fmt.Sprintf(`{"value": "%s"}`, strings.Repeat("x", maxKeyValueSize)))
+		require.Equal(t, http.StatusRequestEntityTooLarge, status)
+		require.Equal(t, errTypePayloadTooLarge, problem["type"])
+
+		status, _ = put("/kv/App?api-version=2023-10-01", `{"value": "x"}`)
+		require.Equal(t, http.StatusOK, status)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, AdminBasePath+"/settings?key=..", strings.NewReader(`{"value": "x"}`))
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,202 @@
+package emulator
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics endpoint serves Prometheus metrics, so that emulators shared
+// by CI jobs can be monitored:
+//
+//	GET /metrics
+//
+// Requests are counted and timed per operation (the operationId for App
+// Configuration requests, the route for everything else) and status code.
+// Store operations are timed, and the number of keys, key-values, revisions
+// and snapshots are reported as gauges, computed when scraped.
+
+const (
+	MetricsPath = "/metrics"
+
+	metricsNamespace = "aacemu"
+
+	// unmatchedOperation is the operation of requests that match no route
+	unmatchedOperation = "unmatched"
+)
+
+// Bucket upper bounds, in seconds
+var (
+	requestDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	storeDurationBuckets   = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25}
+)
+
+// storeMetrics are the metrics kept by the store itself
+type storeMetrics struct {
+	operationDuration *prometheus.HistogramVec
+}
+
+func newStoreMetrics() *storeMetrics {
+	return &storeMetrics{
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Time taken by reads, scans, writes and deletes of the Clover DB store.",
+			Buckets:   storeDurationBuckets,
+		}, []string{"operation"}),
+	}
+}
+
+// timeStore starts timing the store @param operation, returning the
+// function that stops it:
+//
+//	defer pcs.timeStore("read")()
+func (pcs *persistentConfigStore) timeStore(operation string) func() {
+	start := time.Now()
+	return func() {
+		pcs.metrics.operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// requestMetrics are the metrics kept by the REST server
+type requestMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	throttled       *prometheus.CounterVec
+}
+
+func newRequestMetrics(configStore *persistentConfigStore) *requestMetrics {
+	rm := &requestMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Requests served, by operation and status code.",
+		}, []string{"operation", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve requests, by operation and status code.",
+			Buckets:   requestDurationBuckets,
+		}, []string{"operation", "code"}),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "throttled_requests_total",
+			Help:      "Requests rejected with 429 Too Many Requests, by operation.",
+		}, []string{"operation"}),
+	}
+
+	rm.registry.MustRegister(
+		rm.requests,
+		rm.requestDuration,
+		rm.throttled,
+		configStore.metrics.operationDuration,
+		newContentsCollector(configStore),
+	)
+
+	return rm
+}
+
+// middleware counts and times each request
+func (rm *requestMetrics) middleware(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	operation := requestOperation(c)
+	status := c.Writer.Status()
+	code := strconv.Itoa(status)
+
+	rm.requests.WithLabelValues(operation, code).Inc()
+	rm.requestDuration.WithLabelValues(operation, code).Observe(time.Since(start).Seconds())
+	if status == http.StatusTooManyRequests {
+		rm.throttled.WithLabelValues(operation).Inc()
+	}
+}
+
+// requestOperation names the operation of the request in @param c: its
+// operationId if it is an App Configuration request, otherwise its route
+func requestOperation(c *gin.Context) string {
+	if operation := c.GetString(operationContextKey); operation != "" {
+		return operation
+	}
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return unmatchedOperation
+}
+
+// contentsCollector reports the contents of the store, read when scraped
+type contentsCollector struct {
+	configStore *persistentConfigStore
+
+	keys      *prometheus.Desc
+	keyValues *prometheus.Desc
+	revisions *prometheus.Desc
+	snapshots *prometheus.Desc
+}
+
+func newContentsCollector(configStore *persistentConfigStore) *contentsCollector {
+	return &contentsCollector{
+		configStore: configStore,
+		keys: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "keys"),
+			"Distinct keys in the store, whatever their label.", nil, nil),
+		keyValues: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "key_values"),
+			"Key-values in the store.", nil, nil),
+		revisions: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "revisions"),
+			"Revisions of all key-values in the store.", nil, nil),
+		snapshots: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "snapshots"),
+			"Snapshots in the store, by status.", []string{"status"}, nil),
+	}
+}
+
+// Describe sends the descriptions of the gauges to @param ch
+func (cc *contentsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.keys
+	ch <- cc.keyValues
+	ch <- cc.revisions
+	ch <- cc.snapshots
+}
+
+// Collect reads the store and sends the gauges to @param ch
+func (cc *contentsCollector) Collect(ch chan<- prometheus.Metric) {
+	settings, err := cc.configStore.GetSettings()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(cc.keyValues, err)
+		return
+	}
+	snapshots, err := cc.configStore.GetSnapshots()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(cc.snapshots, err)
+		return
+	}
+
+	keys := map[string]bool{}
+	revisions := 0
+	for _, setting := range settings {
+		keys[setting.Key] = true
+		revisions += len(setting.Versions)
+	}
+	ch <- prometheus.MustNewConstMetric(cc.keys, prometheus.GaugeValue, float64(len(keys)))
+	ch <- prometheus.MustNewConstMetric(cc.keyValues, prometheus.GaugeValue, float64(len(settings)))
+	ch <- prometheus.MustNewConstMetric(cc.revisions, prometheus.GaugeValue, float64(revisions))
+
+	counts := map[string]int{SnapshotStatusReady: 0, SnapshotStatusArchived: 0}
+	for _, snapshot := range snapshots {
+		counts[snapshot.Status]++
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(cc.snapshots, prometheus.GaugeValue, float64(count), status)
+	}
+}
+
+func registerMetricsRoutes(g *gin.RouterGroup, rm *requestMetrics) {
+	handler := promhttp.HandlerFor(rm.registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError})
+	g.GET(MetricsPath, gin.WrapH(handler))
+}
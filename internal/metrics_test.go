@@ -0,0 +1,71 @@
+package emulator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Requests, store operations and contents are reported", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := store.UpdateSetting("App:Name", "1")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("App:Name", "2")
+		require.NoError(t, err)
+		_, err = store.UpdateLabeledSetting("App:Name", "prod", "3", SettingAttributes{})
+		require.NoError(t, err)
+		_, err = store.CreateSnapshot("release", []SnapshotFilter{{Key: "App:*"}})
+		require.NoError(t, err)
+
+		for _, path := range []string{"/kv/App:Name?api-version=2023-10-01", "/kv/App:Name?api-version=1999-01-01", "/nowhere"} {
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"), rec.Header().Get("Content-Type"))
+
+		body := rec.Body.String()
+		require.Contains(t, body, "# TYPE aacemu_http_requests_total counter\n")
+		require.Contains(t, body, `aacemu_http_requests_total{code="200",operation="GetKeyValue"} 1`+"\n")
+		require.Contains(t, body, `aacemu_http_requests_total{code="400",operation="GetKeyValue"} 1`+"\n")
+		require.Contains(t, body, `aacemu_http_requests_total{code="404",operation="unmatched"} 1`+"\n")
+		require.Contains(t, body, `aacemu_http_request_duration_seconds_bucket{code="200",operation="GetKeyValue",le="+Inf"} 1`+"\n")
+		require.Contains(t, body, `aacemu_store_operation_duration_seconds_count{operation="write"} 3`+"\n")
+		require.Contains(t, body, "aacemu_keys 1\n")
+		require.Contains(t, body, "aacemu_key_values 2\n")
+		require.Contains(t, body, "aacemu_revisions 3\n")
+		require.Contains(t, body, `aacemu_snapshots{status="ready"} 1`+"\n")
+		require.Contains(t, body, `aacemu_snapshots{status="archived"} 0`+"\n")
+	})
+
+	t.Run("Scans of the store are timed", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := store.UpdateSetting("App:Name", "1")
+		require.NoError(t, err)
+
+		// scans is the number of timed scans of the store
+		scans := func() uint64 {
+			var m dto.Metric
+			require.NoError(t, store.metrics.operationDuration.WithLabelValues("scan").(prometheus.Metric).Write(&m))
+			return m.GetHistogram().GetSampleCount()
+		}
+
+		before := scans()
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kv?api-version=2023-10-01", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Greater(t, scans(), before)
+	})
+}
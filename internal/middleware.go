@@ -0,0 +1,331 @@
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+const (
+	clientRequestIdHeader = "x-ms-client-request-id"
+	requestIdHeader       = "x-ms-request-id"
+	apiVersionParam       = "api-version"
+
+	// Keys of the values middlewares share through the gin context
+	requestIdContextKey = "aacemu.requestId"
+	principalContextKey = "aacemu.principal"
+	operationContextKey = "aacemu.operation"
+	keyContextKey       = "aacemu.key"
+
+	// Principals of requests that are not authenticated
+	anonymousPrincipal = "anonymous"
+	adminPrincipal     = "admin"
+
+	// Error types as reported by the real service
+	errTypeInvalidArgument = "https://azconfig.io/errors/invalid-argument"
+	errTypeNotImplemented  = "https://azconfig.io/errors/not-implemented"
+	errTypeInternal        = "https://azconfig.io/errors/internal-error"
+	errTypeForbidden       = "https://azconfig.io/errors/forbidden"
+
+	// unimplementedPanic is the value handlers panic with for operations the
+	// emulator does not (yet) support. It is translated into a 501.
+	unimplementedPanic = "unimplemented"
+)
+
+// strictMiddlewares returns the middleware chain passed to
+// NewStrictHandler. The strict handler wraps middlewares in order, so the
+// LAST entry is the outermost: recovery has to come last to catch panics
+// from everything inside it.
+func (rs *appConfigRestServer) strictMiddlewares() []ogen.StrictMiddlewareFunc {
+	middlewares := []ogen.StrictMiddlewareFunc{
+		rs.readOnlyMiddleware,
+		rs.apiVersionMiddleware,
+	}
+	if rs.quotas != nil {
+		// Requests that are faulted never reach the store, so don't count
+		middlewares = append(middlewares, rs.quotas.strictMiddleware)
+	}
+	return append(middlewares,
+		rs.faults.strictMiddleware,
+		requestIdMiddleware,
+		recoveryMiddleware,
+	)
+}
+
+// recoveryMiddleware turns a panic in an operation into an error response.
+// Operations that panic with unimplementedPanic get a 501, anything else a 500.
+func recoveryMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (response interface{}, err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			if r == unimplementedPanic {
+				writeError(c, http.StatusNotImplemented, errTypeNotImplemented,
+					"Not implemented", "",
+					fmt.Sprintf("operation %s is not implemented by the emulator", operationID),
+				)
+			} else {
+				writeError(c, http.StatusInternalServerError, errTypeInternal,
+					"Internal server error", "",
+					fmt.Sprintf("operation %s failed: %v", operationID, r),
+				)
+			}
+
+			// Response has already been written, make sure the
+			// strict handler doesn't attempt to write another.
+			response, err = nil, nil
+		}()
+
+		return f(c, request)
+	}
+}
+
+// requestIdMiddleware echoes the client's request id back, and stamps
+// every response with a server-generated request id, if requestLogMiddleware
+// has not already. It also records the operation, for the request log.
+func requestIdMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (interface{}, error) {
+		if clientRequestId := c.GetHeader(clientRequestIdHeader); clientRequestId != "" {
+			c.Header(clientRequestIdHeader, clientRequestId)
+		}
+		if c.GetString(requestIdContextKey) == "" {
+			requestId := uuid.NewString()
+			c.Set(requestIdContextKey, requestId)
+			c.Header(requestIdHeader, requestId)
+		}
+		c.Set(operationContextKey, operationID)
+
+		return f(c, request)
+	}
+}
+
+// keyParamMiddleware escapes the key path parameter again, once gin has
+// unescaped it, as the generated bindings unescape it too: otherwise a key
+// holding an escape sequence, such as "a%2Fb", or a '+', would not be read
+// back as sent. The key as sent is kept in the context.
+func keyParamMiddleware(c *gin.Context) {
+	for i, param := range c.Params {
+		if param.Key == "key" {
+			c.Set(keyContextKey, param.Value)
+			c.Params[i].Value = url.QueryEscape(param.Value)
+		}
+	}
+}
+
+// requestKey returns the key the request in @param c names, in its path or
+// its query, or "" if it names none
+func requestKey(c *gin.Context) string {
+	if key := c.GetString(keyContextKey); key != "" {
+		return key
+	}
+	return c.Query("key")
+}
+
+// requestLogMiddleware assigns each request its id, and logs it once it
+// completes. Request and response bodies, which hold values, are never logged.
+func requestLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	requestId := uuid.NewString()
+	c.Set(requestIdContextKey, requestId)
+	c.Header(requestIdHeader, requestId)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	} else if status >= http.StatusBadRequest {
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", requestId),
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("principal", requestActor(c).Principal),
+	}
+	if clientRequestId := c.GetHeader(clientRequestIdHeader); clientRequestId != "" {
+		attrs = append(attrs, slog.String("client_request_id", clientRequestId))
+	}
+	if operation := c.GetString(operationContextKey); operation != "" {
+		attrs = append(attrs, slog.String("operation", operation))
+	}
+	if key := requestKey(c); key != "" {
+		attrs = append(attrs, slog.String("key", key))
+	}
+	if label, found := c.GetQuery("label"); found {
+		attrs = append(attrs, slog.String("label", label))
+	}
+
+	slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// requestActor returns who the request in @param ctx, a gin context, is
+// made by
+func requestActor(ctx context.Context) Actor {
+	principal, _ := ctx.Value(principalContextKey).(string)
+	if principal == "" {
+		principal = anonymousPrincipal
+	}
+	requestId, _ := ctx.Value(requestIdContextKey).(string)
+
+	return Actor{Principal: principal, RequestId: requestId}
+}
+
+// responseRequestId returns the id of the request in @param ctx, a gin
+// context, for the responses whose headers declare x-ms-request-id. The
+// generated code writes that header itself, so it has to be the id the
+// request was logged with.
+func responseRequestId(ctx context.Context) uuid.UUID {
+	requestId, _ := uuid.Parse(requestActor(ctx).RequestId)
+	return requestId
+}
+
+// apiVersionMiddleware rejects requests for an api-version we don't support,
+// or that use features not available in the requested api-version.
+// The generated bindings have already checked the parameter is present.
+func (rs *appConfigRestServer) apiVersionMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (interface{}, error) {
+		apiVersion := c.Query(apiVersionParam)
+		err := rs.apiVersions.checkRequest(apiVersion, operationID, c.Request.URL.Query())
+		if err != nil {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument,
+				"Invalid request parameter 'api-version'", apiVersionParam,
+				err.Error(),
+			)
+			return nil, nil
+		}
+
+		return f(c, request)
+	}
+}
+
+// mutatingOperations are the operation IDs that modify the store
+var mutatingOperations = []string{
+	"PutKeyValue",
+	"DeleteKeyValue",
+	"PutLock",
+	"DeleteLock",
+	"CreateSnapshot",
+	"UpdateSnapshot",
+}
+
+// readOnlyMiddleware rejects mutating operations when the server is read-only
+func (rs *appConfigRestServer) readOnlyMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (interface{}, error) {
+		if rs.readOnly && slices.Contains(mutatingOperations, operationID) {
+			writeError(c, http.StatusForbidden, errTypeForbidden,
+				"Forbidden", "",
+				fmt.Sprintf("operation %s is not permitted, the emulator is read-only", operationID),
+			)
+			return nil, nil
+		}
+
+		return f(c, request)
+	}
+}
+
+// storeMutatingRoutes are the routes outside the App Configuration API that
+// modify the store, by method and route. The others, such as those of
+// fault injection, quotas and queues, and feature flag evaluation, are
+// allowed when the server is read-only.
+var storeMutatingRoutes = []string{
+	http.MethodPut + " " + AdminBasePath + "/featureflags/:id",
+	http.MethodPost + " " + AdminBasePath + "/featureflags/:id/enable",
+	http.MethodPost + " " + AdminBasePath + "/featureflags/:id/disable",
+	http.MethodPut + " " + AdminBasePath + "/archive",
+	http.MethodPut + " " + AdminBasePath + "/settings",
+	http.MethodDelete + " " + AdminBasePath + "/settings",
+	http.MethodPost + " " + AdminBasePath + "/settings/lock",
+	http.MethodPost + " " + AdminBasePath + "/settings/unlock",
+	http.MethodPost + " " + AdminBasePath + "/snapshots",
+	http.MethodPost + " " + AdminBasePath + "/snapshots/:name/archive",
+	http.MethodPost + " " + AdminBasePath + "/snapshots/:name/recover",
+	http.MethodPut + " /secrets/:name",
+}
+
+// readOnlyRouteMiddleware is readOnlyMiddleware for the routes outside the
+// App Configuration API, which have no operation IDs
+func (rs *appConfigRestServer) readOnlyRouteMiddleware(c *gin.Context) {
+	if !rs.readOnly || !slices.Contains(storeMutatingRoutes, c.Request.Method+" "+c.FullPath()) {
+		return
+	}
+
+	writeError(c, http.StatusForbidden, errTypeForbidden,
+		"Forbidden", "",
+		fmt.Sprintf("%s %s is not permitted, the emulator is read-only", c.Request.Method, c.FullPath()),
+	)
+}
+
+// proxyGuardMiddleware authenticates the requests the upstream proxy
+// forwards, and rejects those that would modify state when the server is
+// read-only. Forwarded requests never reach the strict middlewares, nor the
+// routes' own. The App Configuration API only modifies state with methods
+// other than GET and HEAD.
+func (rs *appConfigRestServer) proxyGuardMiddleware(c *gin.Context) {
+	if isLocalPath(c.Request.URL.Path) {
+		return
+	}
+
+	for _, middleware := range rs.authMiddlewares() {
+		middleware(c)
+		if c.IsAborted() {
+			return
+		}
+	}
+
+	if rs.readOnly && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		writeError(c, http.StatusForbidden, errTypeForbidden,
+			"Forbidden", "",
+			fmt.Sprintf("%s %s is not permitted, the emulator is read-only", c.Request.Method, c.Request.URL.Path),
+		)
+	}
+}
+
+// authMiddlewares are the gin middlewares that authenticate requests, if
+// the server requires it
+func (rs *appConfigRestServer) authMiddlewares() []gin.HandlerFunc {
+	if len(rs.credentials) == 0 {
+		return nil
+	}
+	return []gin.HandlerFunc{hmacAuthMiddleware(rs.credentials)}
+}
+
+// writeError writes an App Configuration error body (application/problem+json)
+// and aborts the gin context.
+func writeError(c *gin.Context, status int, errType string, title string, name string, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(status, newError(status, errType, title, name, detail))
+}
+
+// newError builds an App Configuration error body. @param name is the
+// offending parameter, and is omitted if empty.
+func newError(status int, errType string, title string, name string, detail string) ogen.Error {
+	status32 := int32(status)
+	body := ogen.Error{
+		Type:   &errType,
+		Title:  &title,
+		Detail: &detail,
+		Status: &status32,
+	}
+	if name != "" {
+		body.Name = &name
+	}
+
+	return body
+}
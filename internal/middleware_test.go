@@ -0,0 +1,141 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+func makeTestRestServer(t *testing.T) (*gin.Engine, *persistentConfigStore, func()) {
+	store, _, closer, err := makeTestStore(t)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	return SetupRestServer(store), store, closer
+}
+
+func TestStrictMiddlewares(t *testing.T) {
+	t.Run("Unimplemented operation returns 501", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodHead, "/kv/App:Name?api-version=2023-10-01", nil)
+		engine.ServeHTTP(rec, rq)
+
+		require.Equal(t, http.StatusNotImplemented, rec.Code)
+		require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+		var body ogen.Error
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, errTypeNotImplemented, *body.Type)
+	})
+
+	t.Run("Unsupported api-version returns 400", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/kv?api-version=1999-01-01", nil)
+		engine.ServeHTTP(rec, rq)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var body ogen.Error
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, apiVersionParam, *body.Name)
+	})
+
+	t.Run("Request ids are returned", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		clientRequestId := "5e1f3b0a-8b5e-4d37-9a47-0c1b0d1f6a6e"
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01",
+			strings.NewReader(`{"value": "testvalue_1_1"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		rq.Header.Set(clientRequestIdHeader, clientRequestId)
+		engine.ServeHTTP(rec, rq)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, clientRequestId, rec.Header().Get(clientRequestIdHeader))
+		require.NotEmpty(t, rec.Header().Get(requestIdHeader))
+	})
+
+	t.Run("GetKeyValue returns the request id it is logged with", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+		_, err := store.UpdateSetting("testsetting1", "testvalue_1_1")
+		require.NoError(t, err)
+
+		var log bytes.Buffer
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&log, nil)))
+		defer slog.SetDefault(defaultLogger)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/kv/testsetting1?api-version=2023-10-01", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(log.Bytes(), &entry))
+		require.Len(t, rec.Header().Values(requestIdHeader), 1)
+		require.Equal(t, entry["request_id"], rec.Header().Get(requestIdHeader))
+		require.NotEqual(t, uuid.Nil.String(), rec.Header().Get(requestIdHeader))
+	})
+}
+
+func TestApiVersions(t *testing.T) {
+	t.Run("Versions are ordered", func(t *testing.T) {
+		require.Negative(t, compareApiVersions(ApiVersion1_0, ApiVersion2022_11_01_Prev))
+		require.Negative(t, compareApiVersions("2023-10-01-preview", ApiVersion2023_10_01))
+		require.Positive(t, compareApiVersions(ApiVersion2024_09_01, ApiVersion2023_10_01))
+		require.Zero(t, compareApiVersions(ApiVersion2023_10_01, ApiVersion2023_10_01))
+	})
+
+	t.Run("Snapshots are gated by api-version", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/snapshots?api-version=1.0", nil)
+		engine.ServeHTTP(rec, rq)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Configured versions replace the defaults", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		engine := SetupRestServer(store, WithApiVersions([]string{ApiVersion1_0}))
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=2023-10-01",
+			strings.NewReader(`{"value": "testvalue_1_1"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = httptest.NewRecorder()
+		rq = httptest.NewRequest(http.MethodPut, "/kv/testsetting1?api-version=1.0",
+			strings.NewReader(`{"value": "testvalue_1_1"}`))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+}
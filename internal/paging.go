@@ -0,0 +1,113 @@
+package emulator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lists of key-values are returned in pages, as the service does. A page
+// that is not the last links to the next with @nextLink, the request's own
+// path and query with an After parameter added. After is a continuation
+// token, naming the last key-value of the page; the next page starts with
+// the key-value that follows it. Lists of revisions, keys and labels are
+// paged the same way.
+
+const (
+	// keyValuesPageSize is the most key-values in a page, as for the service
+	keyValuesPageSize = 100
+
+	afterParam = "After"
+)
+
+// pageToken is the position, decoded from an After token, of the last
+// item of the previous page. Revisions are also placed by Version, the
+// index of the revision counted from the oldest, as lists of revisions have
+// the newest first.
+type pageToken struct {
+	Key     string `json:"k"`
+	Label   string `json:"l"`
+	Version int    `json:"v,omitempty"`
+}
+
+// follows returns whether @param pt comes after @param last in a list
+func (pt pageToken) follows(last pageToken) bool {
+	if pt.Key != last.Key {
+		return pt.Key > last.Key
+	}
+	if pt.Label != last.Label {
+		return pt.Label > last.Label
+	}
+	return pt.Version < last.Version
+}
+
+func (pt pageToken) encode() string {
+	token, _ := json.Marshal(pt)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func decodePageToken(after string) (pageToken, error) {
+	var pt pageToken
+	token, err := base64.RawURLEncoding.DecodeString(after)
+	if err == nil {
+		err = json.Unmarshal(token, &pt)
+	}
+	if err != nil {
+		return pageToken{}, fmt.Errorf("invalid continuation token '%s'", after)
+	}
+	return pt, nil
+}
+
+// pageSettings returns the page of @param settings, ordered by key and then
+// label, that follows the one ended by @param after, if it is not nil. It
+// also returns the token of the next page, or "" if this is the last.
+func pageSettings(settings []ConfigSetting, after *string) ([]ConfigSetting, string, error) {
+	return pageItems(settings, after, func(setting ConfigSetting) pageToken {
+		return pageToken{Key: setting.Key, Label: setting.Label}
+	})
+}
+
+// pageItems is pageSettings for any list of @param items, ordered by the
+// position @param position returns for each
+func pageItems[T any](items []T, after *string, position func(T) pageToken) ([]T, string, error) {
+	if after != nil && *after != "" {
+		pt, err := decodePageToken(*after)
+		if err != nil {
+			return nil, "", err
+		}
+
+		start := len(items)
+		for i, item := range items {
+			if position(item).follows(pt) {
+				start = i
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	if len(items) <= keyValuesPageSize {
+		return items, "", nil
+	}
+
+	page := items[:keyValuesPageSize]
+	return page, position(page[len(page)-1]).encode(), nil
+}
+
+// nextLink returns the link to the page after @param after, of the list
+// requested in @param ctx, a gin context: the request's path and query,
+// with After replaced
+func nextLink(ctx context.Context, after string) string {
+	c, ok := ctx.(*gin.Context)
+	if !ok {
+		return ""
+	}
+
+	query := c.Request.URL.Query()
+	query.Set(afterParam, after)
+	return (&url.URL{Path: c.Request.URL.Path, RawPath: c.Request.URL.RawPath, RawQuery: query.Encode()}).String()
+}
@@ -0,0 +1,326 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+// In proxy mode the App Configuration API is forwarded to an upstream
+// store, rather than served from the local one. Requests are re-signed with
+// the upstream's credential if one is given, and the key-values in the
+// upstream's responses are captured into the local store, so that the
+// emulator can later serve them offline. The emulator's own endpoints,
+// such as the admin API, are still served locally.
+
+// proxyPrincipal is recorded in the audit log for captured changes
+const proxyPrincipal = "proxy"
+
+// localPathPrefixes are served by the emulator, even in proxy mode
+var localPathPrefixes = []string{AdminBasePath, QueuesBasePath, WatchPath, MetricsPath, "/secrets/"}
+
+// hopByHopHeaders are not forwarded, in either direction
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+	"Host",
+}
+
+// ProxyOptions configure an UpstreamProxy
+type ProxyOptions struct {
+	// Endpoint is the base URL of the upstream store
+	Endpoint string
+	// Credential re-signs requests, if set. Otherwise the client's
+	// Authorization header is forwarded as is.
+	Credential *Credential
+	// Client makes the upstream requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// An UpstreamProxy forwards requests to an upstream store, capturing the
+// key-values in its responses into the local store
+type UpstreamProxy struct {
+	configStore *persistentConfigStore
+	opts        ProxyOptions
+}
+
+// NewUpstreamProxy returns a proxy, configured by @param opts, that
+// captures into @param configStore
+func NewUpstreamProxy(configStore *persistentConfigStore, opts ProxyOptions) (*UpstreamProxy, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid upstream endpoint '%s'", opts.Endpoint)
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	return &UpstreamProxy{configStore: configStore, opts: opts}, nil
+}
+
+// isLocalPath returns whether @param path is served by the emulator, even
+// in proxy mode
+func isLocalPath(path string) bool {
+	for _, prefix := range localPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware forwards each request for the App Configuration API upstream
+func (up *UpstreamProxy) middleware(c *gin.Context) {
+	if isLocalPath(c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	status, body, err := up.forward(c)
+	if err != nil {
+		writeError(c, http.StatusBadGateway, errTypeInternal, "Bad gateway", "", err.Error())
+		return
+	}
+
+	err = up.capture(c, status, body)
+	if err != nil {
+		slog.Warn("failed to capture upstream response", "path", c.Request.URL.Path, "error", err)
+	}
+}
+
+// forward makes the request in @param c upstream, and writes the upstream's
+// response, which it returns
+func (up *UpstreamProxy) forward(c *gin.Context) (int, []byte, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to read request body")
+	}
+
+	target := up.opts.Endpoint + c.Request.URL.EscapedPath()
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+
+	rq, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to create upstream request")
+	}
+	copyHeaders(rq.Header, c.Request.Header)
+	if up.opts.Credential != nil {
+		err = SignHmacRequest(rq, body, *up.opts.Credential)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "failed to sign upstream request")
+		}
+	}
+
+	rs, err := up.opts.Client.Do(rq)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "upstream request failed")
+	}
+	defer rs.Body.Close()
+
+	responseBody, err := io.ReadAll(rs.Body)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to read upstream response")
+	}
+
+	for name := range rs.Header {
+		c.Writer.Header().Del(name)
+	}
+	copyHeaders(c.Writer.Header(), rs.Header)
+	c.Writer.WriteHeader(rs.StatusCode)
+	c.Writer.Write(responseBody)
+
+	return rs.StatusCode, responseBody, nil
+}
+
+// copyHeaders adds the headers in @param from to @param to, other than
+// hop-by-hop headers
+func copyHeaders(to http.Header, from http.Header) {
+	for name, values := range from {
+		if hopByHop(name) {
+			continue
+		}
+		for _, value := range values {
+			to.Add(name, value)
+		}
+	}
+}
+
+func hopByHop(name string) bool {
+	for _, header := range hopByHopHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// capture records the key-values in the upstream response to the request
+// in @param c, with @param status and @param body, in the local store.
+// Responses to requests that select fields are not captured, as the fields
+// left out would overwrite the local copy with empty values. Nor are those
+// to requests for past or snapshot key-values, which would overwrite it with
+// stale ones.
+func (up *UpstreamProxy) capture(c *gin.Context, status int, body []byte) error {
+	if status != http.StatusOK || selectsFields(c.Request.URL.Query()) || readsPast(c.Request) {
+		return nil
+	}
+
+	path := c.Request.URL.Path
+	store := up.configStore.As(Actor{Principal: proxyPrincipal, RequestId: c.GetString(requestIdContextKey)})
+
+	if c.Request.Method == http.MethodDelete && strings.HasPrefix(path, "/kv/") {
+		key, err := url.PathUnescape(strings.TrimPrefix(c.Request.URL.EscapedPath(), "/kv/"))
+		if err != nil {
+			return err
+		}
+		label := c.Query("label")
+		if label == nullLabelFilter {
+			label = NullLabel
+		}
+
+		err = store.DeleteLabeledSetting(key, label)
+		if errors.Is(err, ErrSettingNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	kvs := []ogen.KeyValue{}
+	switch {
+	case path == "/kv":
+		var list ogen.KeyValueListResult
+		err := json.Unmarshal(body, &list)
+		if err != nil {
+			return errors.Wrap(err, "failed to unmarshal key-values")
+		}
+		if list.Items != nil {
+			kvs = *list.Items
+		}
+
+	case strings.HasPrefix(path, "/kv/"), strings.HasPrefix(path, "/locks/"):
+		var kv ogen.KeyValue
+		err := json.Unmarshal(body, &kv)
+		if err != nil {
+			return errors.Wrap(err, "failed to unmarshal key-value")
+		}
+		kvs = append(kvs, kv)
+
+	default:
+		return nil
+	}
+
+	for _, kv := range kvs {
+		if kv.Key == nil || kv.Value == nil {
+			continue
+		}
+
+		attrs := SettingAttributes{}
+		if kv.ContentType != nil {
+			attrs.ContentType = *kv.ContentType
+		}
+		if kv.Tags != nil {
+			attrs.Tags = *kv.Tags
+		}
+		label := NullLabel
+		if kv.Label != nil {
+			label = *kv.Label
+		}
+
+		err := store.CaptureSetting(*kv.Key, label, *kv.Value, attrs, kv.Locked != nil && *kv.Locked)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectsFields returns whether the request with @param query asks for
+// only some fields of the key-values, with $Select
+func selectsFields(query url.Values) bool {
+	for name, values := range query {
+		if strings.EqualFold(name, "$select") && slices.ContainsFunc(values, func(v string) bool { return v != "" }) {
+			return true
+		}
+	}
+	return false
+}
+
+// readsPast returns whether @param rq asks for the key-values as they were
+// at some time, with Accept-Datetime, or in a snapshot
+func readsPast(rq *http.Request) bool {
+	return rq.Header.Get("Accept-Datetime") != "" || rq.URL.Query().Get("snapshot") != ""
+}
+
+// CaptureSetting is persistentConfigStore.captureSetting
+func (as ActorStore) CaptureSetting(key string, label string, value string, attrs SettingAttributes, locked bool) error {
+	defer as.lock()()
+	return as.pcs.captureSetting(key, label, value, attrs, locked)
+}
+
+// captureSetting makes the setting identified by @param key and
+// @param label match a key-value seen upstream, or read from a seed,
+// adding a version only if its value, content type or tags differ from the
+// latest. A locked setting is unlocked to be updated, and then locked or
+// not as @param locked says.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) captureSetting(key string, label string, value string, attrs SettingAttributes, locked bool) error {
+	setting, err := pcs.getSetting(key, label)
+	if err != nil && !errors.Is(err, ErrSettingNotFound) {
+		return err
+	}
+
+	if err == nil {
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return err
+		}
+
+		if latest.Value == value && latest.ContentType == attrs.ContentType && maps.Equal(latest.Tags, attrs.Tags) {
+			if setting.Locked != locked {
+				_, err = pcs.setSettingLocked(key, label, locked)
+			}
+			return err
+		}
+
+		if setting.Locked {
+			_, err = pcs.setSettingLocked(key, label, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = pcs.updateLabeledSetting(key, label, value, attrs)
+	if err != nil {
+		return err
+	}
+	if locked {
+		_, err = pcs.setSettingLocked(key, label, true)
+	}
+
+	return err
+}
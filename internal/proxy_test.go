@@ -0,0 +1,236 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	credential := Credential{Id: "upstream-id", Secret: base64.StdEncoding.EncodeToString([]byte("upstream-secret"))}
+
+	// setup starts an HMAC authenticated upstream, and a proxy to it
+	setup := func(t *testing.T, opts ...RestServerOption) (*persistentConfigStore, *persistentConfigStore, *gin.Engine, func()) {
+		upstreamStore, _, upstreamCloser, err := makeTestStore(t)
+		require.NoError(t, err)
+		gin.SetMode(gin.TestMode)
+		upstream := httptest.NewServer(SetupRestServer(upstreamStore, WithHmacAuth([]Credential{credential})))
+
+		localStore, _, localCloser, err := makeTestStore(t)
+		require.NoError(t, err)
+		proxy, err := NewUpstreamProxy(localStore, ProxyOptions{Endpoint: upstream.URL, Credential: &credential})
+		require.NoError(t, err)
+
+		return upstreamStore, localStore, SetupRestServer(localStore, append(opts, WithProxy(proxy))...), func() {
+			upstream.Close()
+			upstreamCloser()
+			localCloser()
+		}
+	}
+
+	do := func(engine *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, path, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	t.Run("Responses are captured, then served offline", func(t *testing.T) {
+		upstreamStore, localStore, engine, stop := setup(t)
+		defer stop()
+
+		_, err := upstreamStore.UpdateLabeledSetting("App:Name", "prod", "demo", SettingAttributes{ContentType: "text/plain"})
+		require.NoError(t, err)
+		_, err = upstreamStore.UpdateSetting("App:Fixed", "1")
+		require.NoError(t, err)
+		_, err = upstreamStore.LockSetting("App:Fixed")
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			rec := do(engine, http.MethodGet, "/kv?key=App:*&label=*&api-version=2023-10-01", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+
+		settings, err := localStore.GetSettings()
+		require.NoError(t, err)
+		require.Len(t, settings, 2)
+		require.Equal(t, "App:Fixed", settings[0].Key)
+		require.True(t, settings[0].Locked)
+		require.Equal(t, "App:Name", settings[1].Key)
+		require.Equal(t, "prod", settings[1].Label)
+		require.Len(t, settings[1].Versions, 1, "an unchanged key-value is not captured again")
+		require.Equal(t, "text/plain", settings[1].Versions[0].ContentType)
+
+		entries, err := localStore.GetAuditLog(nullFilter{}, nullFilter{}, 1)
+		require.NoError(t, err)
+		require.Equal(t, proxyPrincipal, entries[0].Principal)
+
+		offline := SetupRestServer(localStore)
+		rec := do(offline, http.MethodGet, "/kv/App:Name?label=prod&api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var kv map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &kv))
+		require.Equal(t, "demo", kv["value"])
+	})
+
+	t.Run("Writes are forwarded and captured", func(t *testing.T) {
+		upstreamStore, localStore, engine, stop := setup(t)
+		defer stop()
+
+		rec := do(engine, http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "written"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		value, err := upstreamStore.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "written", value)
+		value, err = localStore.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "written", value)
+
+		rec = do(engine, http.MethodDelete, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		_, err = localStore.GetSetting("App:Name")
+		require.ErrorIs(t, err, ErrSettingNotFound)
+	})
+
+	t.Run("Responses to requests selecting fields are not captured", func(t *testing.T) {
+		upstreamStore, localStore, engine, stop := setup(t)
+		defer stop()
+
+		_, err := upstreamStore.UpdateLabeledSetting("App:Name", NullLabel, "demo", SettingAttributes{
+			ContentType: "text/plain",
+			Tags:        map[string]string{"team": "core"},
+		})
+		require.NoError(t, err)
+		_, err = upstreamStore.UpdateSetting("App:Other", "1")
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		for _, path := range []string{
+			"/kv?key=App:*&$Select=key,value&api-version=2023-10-01",
+			"/kv/App:Name?$select=key,value&api-version=2023-10-01",
+		} {
+			rec = do(engine, http.MethodGet, path, "")
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+
+		settings, err := localStore.GetSettings()
+		require.NoError(t, err)
+		require.Len(t, settings, 1, "App:Other was only seen in a projected response")
+		require.Len(t, settings[0].Versions, 1)
+		require.Equal(t, "text/plain", settings[0].Versions[0].ContentType)
+		require.Equal(t, map[string]string{"team": "core"}, settings[0].Versions[0].Tags)
+	})
+
+	t.Run("Responses to requests for past or snapshot key-values are not captured", func(t *testing.T) {
+		upstreamStore, localStore, engine, stop := setup(t)
+		defer stop()
+
+		_, err := upstreamStore.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+		_, err = upstreamStore.CreateSnapshot("release", []SnapshotFilter{{Key: "App:*"}})
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodGet, "/kv?snapshot=release&api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		for _, path := range []string{
+			"/kv?key=App:*&api-version=2023-10-01",
+			"/kv/App:Name?api-version=2023-10-01",
+		} {
+			rec = httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodGet, path, nil)
+			rq.Header.Set("Accept-Datetime", time.Now().UTC().Format(http.TimeFormat))
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		settings, err := localStore.GetSettings()
+		require.NoError(t, err)
+		require.Empty(t, settings)
+	})
+
+	t.Run("Requests are authenticated, and read-only mode applied, before forwarding", func(t *testing.T) {
+		local := Credential{Id: "local-id", Secret: base64.StdEncoding.EncodeToString([]byte("local-secret"))}
+		upstreamStore, _, engine, stop := setup(t, WithHmacAuth([]Credential{local}), WithReadOnly())
+		defer stop()
+
+		_, err := upstreamStore.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		signed := func(method string, path string, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(method, path, strings.NewReader(body))
+			rq.Header.Set("Content-Type", "application/json")
+			signRequest(t, rq, body, local)
+			engine.ServeHTTP(rec, rq)
+			return rec
+		}
+
+		rec := do(engine, http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = signed(http.MethodGet, "/kv/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = signed(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", `{"value": "written"}`)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		rec = signed(http.MethodPut, "/locks/App:Name?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+
+		value, err := upstreamStore.GetSetting("App:Name")
+		require.NoError(t, err)
+		require.Equal(t, "demo", value)
+		locked, err := upstreamStore.GetLabeledConfigSetting("App:Name", NullLabel)
+		require.NoError(t, err)
+		require.False(t, locked.Locked)
+	})
+
+	t.Run("Upstream errors are passed through, and not captured", func(t *testing.T) {
+		_, localStore, engine, stop := setup(t)
+		defer stop()
+
+		rec := do(engine, http.MethodGet, "/kv?api-version=1999-01-01", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+		// The emulator's own endpoints are still served locally
+		rec = do(engine, http.MethodGet, AdminBasePath+"/settings", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		settings, err := localStore.GetSettings()
+		require.NoError(t, err)
+		require.Empty(t, settings)
+	})
+
+	t.Run("Unreachable upstreams are a bad gateway", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		proxy, err := NewUpstreamProxy(store, ProxyOptions{Endpoint: "http://127.0.0.1:1"})
+		require.NoError(t, err)
+
+		rec := do(SetupRestServer(store, WithProxy(proxy)), http.MethodGet, "/kv?api-version=2023-10-01", "")
+		require.Equal(t, http.StatusBadGateway, rec.Code)
+	})
+
+	t.Run("Connection strings", func(t *testing.T) {
+		endpoint, parsed, err := ParseConnectionString("Endpoint=https://demo.azconfig.io;Id=abc;Secret=c2VjcmV0==")
+		require.NoError(t, err)
+		require.Equal(t, "https://demo.azconfig.io", endpoint)
+		require.Equal(t, Credential{Id: "abc", Secret: "c2VjcmV0=="}, parsed)
+
+		_, _, err = ParseConnectionString("Endpoint=https://demo.azconfig.io")
+		require.Error(t, err)
+	})
+}
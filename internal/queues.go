@@ -0,0 +1,417 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// The queue broker stands in for Service Bus queues, so apps that receive
+// change events through Event Grid and Service Bus can be tested locally.
+// Queues speak the subset of the Service Bus REST API that receivers use,
+// rooted at /_queues/<queue> instead of https://<namespace>/<queue>:
+//
+//	POST   /messages                     send a message
+//	POST   /messages/head?timeout=<s>    peek-lock the next message
+//	DELETE /messages/head?timeout=<s>    receive and delete the next message
+//	DELETE /messages/<id>/<lock token>   complete a locked message
+//	PUT    /messages/<id>/<lock token>   abandon a locked message
+//	POST   /messages/<id>/<lock token>   renew the lock on a message
+//
+// Queues are created on first use, and live only in memory. A message
+// abandoned (or whose lock expires) MaxDeliveryCount times is moved to
+// the queue's dead-letter list.
+
+const (
+	QueuesBasePath = "/_queues"
+
+	defaultQueueLockDuration     = 60 * time.Second
+	defaultQueueMaxDeliveryCount = 10
+	// maxQueueReceiveTimeout bounds how long a receive waits for a message
+	maxQueueReceiveTimeout = 60 * time.Second
+)
+
+// QueueSubscription subscribes a queue to changes to settings whose keys
+// start with KeyPrefix (all settings if empty)
+type QueueSubscription struct {
+	Queue     string
+	Schema    string
+	KeyPrefix string
+}
+
+// QueueOptions configure the queues of a QueueBroker
+type QueueOptions struct {
+	// LockDuration is how long a peek-locked message stays locked (default 60s)
+	LockDuration time.Duration
+	// MaxDeliveryCount is how many times a message is delivered before it
+	// is dead-lettered (default 10)
+	MaxDeliveryCount int
+}
+
+// queueMessage is a message and its broker properties
+type queueMessage struct {
+	MessageId      string    `json:"MessageId"`
+	SequenceNumber int64     `json:"SequenceNumber"`
+	DeliveryCount  int       `json:"DeliveryCount"`
+	EnqueuedTime   time.Time `json:"EnqueuedTimeUtc"`
+	LockToken      string    `json:"LockToken,omitempty"`
+	LockedUntil    time.Time `json:"LockedUntilUtc,omitempty"`
+
+	body        []byte
+	contentType string
+}
+
+type queue struct {
+	messages     []*queueMessage
+	deadLetters  []*queueMessage
+	nextSequence int64
+	// arrived is closed, and replaced, when a message is sent
+	arrived chan struct{}
+}
+
+// QueueInfo summarises a queue
+type QueueInfo struct {
+	Name        string `json:"name"`
+	Active      int    `json:"active"`
+	Locked      int    `json:"locked"`
+	DeadLetters int    `json:"dead_letters"`
+}
+
+type QueueBroker struct {
+	sync.Mutex
+	opts   QueueOptions
+	queues map[string]*queue
+}
+
+func NewQueueBroker(opts QueueOptions) *QueueBroker {
+	if opts.LockDuration <= 0 {
+		opts.LockDuration = defaultQueueLockDuration
+	}
+	if opts.MaxDeliveryCount <= 0 {
+		opts.MaxDeliveryCount = defaultQueueMaxDeliveryCount
+	}
+
+	return &QueueBroker{
+		opts:   opts,
+		queues: map[string]*queue{},
+	}
+}
+
+// SubscribeChanges sends each change to @param store to the queues of
+// @param subscriptions, as Event Grid would deliver it to Service Bus:
+// one event per message.
+func (qb *QueueBroker) SubscribeChanges(store *persistentConfigStore, subscriptions []QueueSubscription, opts EventOptions) error {
+	for _, sub := range subscriptions {
+		if sub.Queue == "" || strings.Contains(sub.Queue, "/") {
+			return fmt.Errorf("invalid queue name '%s'", sub.Queue)
+		}
+		if !slices.Contains(EventSchemas, sub.Schema) {
+			return fmt.Errorf("unknown event schema '%s'", sub.Schema)
+		}
+	}
+
+	store.OnChange(func(change SettingChange) {
+		for _, sub := range subscriptions {
+			if !strings.HasPrefix(change.Key, sub.KeyPrefix) {
+				continue
+			}
+
+			event, contentType := newChangeEvent(sub.Schema, opts, change)
+			body, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("failed to encode change event", "queue", sub.Queue, "key", change.Key, "error", err)
+				continue
+			}
+			qb.Send(sub.Queue, body, contentType, "")
+		}
+	})
+
+	return nil
+}
+
+// Send adds a message to @param name, with @param messageId or a new id if
+// empty, and returns its sequence number
+func (qb *QueueBroker) Send(name string, body []byte, contentType string, messageId string) int64 {
+	qb.Lock()
+	defer qb.Unlock()
+
+	q := qb.getQueue(name)
+	q.nextSequence++
+	if messageId == "" {
+		messageId = uuid.NewString()
+	}
+
+	q.messages = append(q.messages, &queueMessage{
+		MessageId:      messageId,
+		SequenceNumber: q.nextSequence,
+		EnqueuedTime:   time.Now().UTC(),
+		body:           body,
+		contentType:    contentType,
+	})
+
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+
+	return q.nextSequence
+}
+
+// Queues summarises every queue, ordered by name
+func (qb *QueueBroker) Queues() []QueueInfo {
+	qb.Lock()
+	defer qb.Unlock()
+
+	infos := []QueueInfo{}
+	for _, name := range slices.Sorted(maps.Keys(qb.queues)) {
+		q := qb.queues[name]
+		qb.expireLocks(q)
+
+		info := QueueInfo{Name: name, DeadLetters: len(q.deadLetters)}
+		for _, m := range q.messages {
+			if m.LockToken != "" {
+				info.Locked++
+			} else {
+				info.Active++
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// receive returns the next unlocked message on @param name, waiting up to
+// @param timeout for one to arrive. With @param lock the message is
+// peek-locked, otherwise it is removed. Returns nil if none arrives.
+func (qb *QueueBroker) receive(name string, lock bool, timeout time.Duration, done <-chan struct{}) *queueMessage {
+	deadline := time.After(timeout)
+
+	for {
+		qb.Lock()
+		q := qb.getQueue(name)
+		qb.expireLocks(q)
+
+		for i, m := range q.messages {
+			if m.LockToken != "" {
+				continue
+			}
+
+			m.DeliveryCount++
+			if lock {
+				m.LockToken = uuid.NewString()
+				m.LockedUntil = time.Now().UTC().Add(qb.opts.LockDuration)
+			} else {
+				q.messages = slices.Delete(q.messages, i, i+1)
+			}
+
+			received := *m
+			qb.Unlock()
+			return &received
+		}
+
+		// Nothing closes arrived when a lock expires, so wake up when the
+		// next one does
+		var expired <-chan time.Time
+		if next, found := nextLockExpiry(q); found {
+			expired = time.After(time.Until(next))
+		}
+
+		arrived := q.arrived
+		qb.Unlock()
+
+		select {
+		case <-arrived:
+		case <-expired:
+		case <-deadline:
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// nextLockExpiry returns when the earliest lock on a message of @param q
+// expires, if any message is locked.
+// This non-exported function DOES NOT manage the Mutex.
+func nextLockExpiry(q *queue) (time.Time, bool) {
+	var next time.Time
+	for _, m := range q.messages {
+		if m.LockToken != "" && (next.IsZero() || m.LockedUntil.Before(next)) {
+			next = m.LockedUntil
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// settle completes, abandons or renews the lock on the message @param id
+// (a message id or sequence number) locked with @param lockToken
+func (qb *QueueBroker) settle(name string, id string, lockToken string, settle func(*queue, int)) bool {
+	qb.Lock()
+	defer qb.Unlock()
+
+	q := qb.getQueue(name)
+	qb.expireLocks(q)
+
+	i := slices.IndexFunc(q.messages, func(m *queueMessage) bool {
+		return m.LockToken == lockToken && (m.MessageId == id || strconv.FormatInt(m.SequenceNumber, 10) == id)
+	})
+	if lockToken == "" || i < 0 {
+		return false
+	}
+
+	settle(q, i)
+	return true
+}
+
+// getQueue returns the queue @param name, creating it if needed.
+// This non-exported function DOES NOT manage the Mutex.
+func (qb *QueueBroker) getQueue(name string) *queue {
+	q, found := qb.queues[name]
+	if !found {
+		q = &queue{arrived: make(chan struct{})}
+		qb.queues[name] = q
+	}
+	return q
+}
+
+// expireLocks unlocks messages whose lock has expired, dead-lettering
+// those delivered too many times.
+// This non-exported function DOES NOT manage the Mutex.
+func (qb *QueueBroker) expireLocks(q *queue) {
+	now := time.Now()
+	for i := 0; i < len(q.messages); i++ {
+		m := q.messages[i]
+		if m.LockToken == "" || m.LockedUntil.After(now) {
+			continue
+		}
+		if qb.unlock(q, i) {
+			i--
+		}
+	}
+}
+
+// unlock releases the lock on message @param i, dead-lettering it if it
+// has been delivered too many times. Returns whether it was dead-lettered.
+// This non-exported function DOES NOT manage the Mutex.
+func (qb *QueueBroker) unlock(q *queue, i int) bool {
+	m := q.messages[i]
+	m.LockToken = ""
+	m.LockedUntil = time.Time{}
+
+	if m.DeliveryCount >= qb.opts.MaxDeliveryCount {
+		q.messages = slices.Delete(q.messages, i, i+1)
+		q.deadLetters = append(q.deadLetters, m)
+		slog.Warn("dead-lettered message", "message", m.MessageId, "deliveries", m.DeliveryCount)
+		return true
+	}
+
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+	return false
+}
+
+func registerQueueRoutes(g *gin.RouterGroup, broker *QueueBroker) {
+	g.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"items": broker.Queues()})
+	})
+
+	messages := g.Group("/:queue/messages")
+	messages.POST("", broker.sendMessage)
+	messages.POST("/head", broker.receiveMessage(true))
+	messages.DELETE("/head", broker.receiveMessage(false))
+	messages.DELETE("/:id/:lock", broker.settleMessage(func(q *queue, i int) {
+		q.messages = slices.Delete(q.messages, i, i+1)
+	}))
+	messages.PUT("/:id/:lock", broker.settleMessage(func(q *queue, i int) {
+		broker.unlock(q, i)
+	}))
+	messages.POST("/:id/:lock", broker.settleMessage(func(q *queue, i int) {
+		q.messages[i].LockedUntil = time.Now().UTC().Add(broker.opts.LockDuration)
+	}))
+}
+
+func (qb *QueueBroker) sendMessage(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid message", "", err.Error())
+		return
+	}
+
+	var properties struct {
+		MessageId string
+	}
+	if header := c.GetHeader("BrokerProperties"); header != "" {
+		err = json.Unmarshal([]byte(header), &properties)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid broker properties",
+				"BrokerProperties", err.Error())
+			return
+		}
+	}
+
+	qb.Send(c.Param("queue"), body, c.ContentType(), properties.MessageId)
+	c.Status(http.StatusCreated)
+}
+
+func (qb *QueueBroker) receiveMessage(lock bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := time.Duration(0)
+		if t := c.Query("timeout"); t != "" {
+			seconds, err := strconv.Atoi(t)
+			if err != nil || seconds < 0 {
+				writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid timeout", "timeout",
+					"timeout must be a whole number of seconds")
+				return
+			}
+			timeout = min(time.Duration(seconds)*time.Second, maxQueueReceiveTimeout)
+		}
+
+		name := c.Param("queue")
+		m := qb.receive(name, lock, timeout, c.Request.Context().Done())
+		if m == nil {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		properties, err := json.Marshal(m)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.Header("BrokerProperties", string(properties))
+
+		status := http.StatusOK
+		if lock {
+			status = http.StatusCreated
+			c.Header("Location", fmt.Sprintf("%s/%s/messages/%d/%s",
+				strings.TrimSuffix(c.FullPath(), "/:queue/messages/head"), name, m.SequenceNumber, m.LockToken))
+		}
+
+		contentType := m.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.Data(status, contentType, m.body)
+	}
+}
+
+func (qb *QueueBroker) settleMessage(settle func(*queue, int)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !qb.settle(c.Param("queue"), c.Param("id"), c.Param("lock"), settle) {
+			writeError(c, http.StatusNotFound, errTypeNotFound, "Message not found", "",
+				"no message is locked with that id and lock token")
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+}
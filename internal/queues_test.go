@@ -0,0 +1,148 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueues(t *testing.T) {
+	setup := func(t *testing.T, opts QueueOptions) (*gin.Engine, *persistentConfigStore, *QueueBroker, func()) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		broker := NewQueueBroker(opts)
+		err = broker.SubscribeChanges(store, []QueueSubscription{
+			{Queue: "changes", Schema: EventSchemaEventGrid, KeyPrefix: "App:"},
+		}, EventOptions{})
+		require.NoError(t, err)
+
+		gin.SetMode(gin.TestMode)
+		return SetupRestServer(store, WithQueues(broker)), store, broker, closer
+	}
+
+	do := func(engine *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(method, QueuesBasePath+path, strings.NewReader(body)))
+		return rec
+	}
+
+	brokerProperties := func(t *testing.T, rec *httptest.ResponseRecorder) queueMessage {
+		var properties queueMessage
+		require.NoError(t, json.Unmarshal([]byte(rec.Header().Get("BrokerProperties")), &properties))
+		return properties
+	}
+
+	t.Run("Change events can be peek-locked and completed", func(t *testing.T) {
+		engine, store, _, closer := setup(t, QueueOptions{})
+		defer closer()
+
+		_, err := store.UpdateSetting("Other", "not queued")
+		require.NoError(t, err)
+		setting, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		rec := do(engine, http.MethodPost, "/changes/messages/head?timeout=1", "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &event))
+		require.Equal(t, EventTypeKeyValueModified, event["eventType"])
+		require.Equal(t, map[string]interface{}{
+			"key":       "App:Name",
+			"label":     "",
+			"etag":      setting.Versions[0].Uuid,
+			"syncToken": formatSyncToken(2),
+		}, event["data"])
+
+		properties := brokerProperties(t, rec)
+		require.Equal(t, 1, properties.DeliveryCount)
+		require.Equal(t,
+			QueuesBasePath+"/changes/messages/1/"+properties.LockToken, rec.Header().Get("Location"))
+
+		// The locked message is not delivered again
+		rec = do(engine, http.MethodPost, "/changes/messages/head", "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = do(engine, http.MethodDelete, "/changes/messages/"+properties.MessageId+"/"+properties.LockToken, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(engine, http.MethodDelete, "/changes/messages/"+properties.MessageId+"/"+properties.LockToken, "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Abandoned messages are redelivered, then dead-lettered", func(t *testing.T) {
+		engine, _, broker, closer := setup(t, QueueOptions{MaxDeliveryCount: 2})
+		defer closer()
+
+		rec := do(engine, http.MethodPost, "/work/messages", "hello")
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		for delivery := 1; delivery <= 2; delivery++ {
+			rec = do(engine, http.MethodPost, "/work/messages/head", "")
+			require.Equal(t, http.StatusCreated, rec.Code)
+			require.Equal(t, "hello", rec.Body.String())
+			properties := brokerProperties(t, rec)
+			require.Equal(t, delivery, properties.DeliveryCount)
+
+			rec = do(engine, http.MethodPut, "/work/messages/1/"+properties.LockToken, "")
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+
+		rec = do(engine, http.MethodPost, "/work/messages/head", "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, []QueueInfo{{Name: "work", DeadLetters: 1}}, broker.Queues())
+	})
+
+	t.Run("Expired locks are released", func(t *testing.T) {
+		engine, _, _, closer := setup(t, QueueOptions{LockDuration: 10 * time.Millisecond})
+		defer closer()
+
+		do(engine, http.MethodPost, "/work/messages", "hello")
+		rec := do(engine, http.MethodPost, "/work/messages/head", "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		time.Sleep(20 * time.Millisecond)
+		rec = do(engine, http.MethodPost, "/work/messages/head", "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, 2, brokerProperties(t, rec).DeliveryCount)
+	})
+
+	t.Run("A waiting receive gets a message whose lock expires", func(t *testing.T) {
+		engine, _, _, closer := setup(t, QueueOptions{LockDuration: 50 * time.Millisecond})
+		defer closer()
+
+		do(engine, http.MethodPost, "/work/messages", "hello")
+		rec := do(engine, http.MethodPost, "/work/messages/head", "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		start := time.Now()
+		rec = do(engine, http.MethodPost, "/work/messages/head?timeout=5", "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, 2, brokerProperties(t, rec).DeliveryCount)
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Receive and delete waits for a message", func(t *testing.T) {
+		engine, _, broker, closer := setup(t, QueueOptions{})
+		defer closer()
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			broker.Send("work", []byte("late"), "text/plain", "")
+		}()
+
+		rec := do(engine, http.MethodDelete, "/work/messages/head?timeout=5", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "late", rec.Body.String())
+		require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+
+		require.Equal(t, []QueueInfo{{Name: "work"}}, broker.Queues())
+	})
+}
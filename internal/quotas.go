@@ -0,0 +1,296 @@
+package emulator
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	ogen "urbanwizardry.com/aac-emulator/gen/appconfig"
+)
+
+// The emulator can enforce the request and storage limits of an App
+// Configuration pricing tier, so that capacity planning tests see the
+// throttling a real store would. Requests over a quota get the service's
+// 429, with its retry headers; only App Configuration operations count,
+// not the emulator's own endpoints. The usage can be inspected, and reset,
+// through the admin API:
+//
+//	GET    /_admin/quotas   the tier, its limits and the usage
+//	DELETE /_admin/quotas   reset the request counts
+
+// Pricing tiers of App Configuration stores
+const (
+	TierFree      = "free"
+	TierDeveloper = "developer"
+	TierStandard  = "standard"
+	TierPremium   = "premium"
+)
+
+// Tiers are the supported values of QuotaOptions.Tier
+var Tiers = []string{TierFree, TierDeveloper, TierStandard, TierPremium}
+
+const (
+	quotaExceededTitle = "Resource utilization has surpassed the assigned quota"
+
+	megabyte = 1024 * 1024
+)
+
+// QuotaLimits are the limits of a tier. A limit of 0 is not enforced.
+type QuotaLimits struct {
+	RequestsPerHour int `json:"requestsPerHour,omitempty"`
+	RequestsPerDay  int `json:"requestsPerDay,omitempty"`
+	// BurstPerSecond is the sustained rate of requests allowed, and the
+	// most that can be made at once
+	BurstPerSecond int `json:"burstPerSecond,omitempty"`
+	// StorageBytes bounds the total size of the key-values: the keys,
+	// labels, values, content types and tags of their latest versions
+	StorageBytes int64 `json:"storageBytes,omitempty"`
+}
+
+// TierLimits are the limits of each tier. The request quotas and storage
+// follow the service's published limits; it does not publish its burst
+// limits, so those are the emulator's own.
+var TierLimits = map[string]QuotaLimits{
+	TierFree:      {RequestsPerDay: 1000, BurstPerSecond: 10, StorageBytes: 10 * megabyte},
+	TierDeveloper: {RequestsPerHour: 6000, BurstPerSecond: 50, StorageBytes: 500 * megabyte},
+	TierStandard:  {RequestsPerHour: 30000, BurstPerSecond: 300, StorageBytes: 1024 * megabyte},
+	TierPremium:   {BurstPerSecond: 1000, StorageBytes: 4 * 1024 * megabyte},
+}
+
+// QuotaOptions configure a QuotaEnforcer
+type QuotaOptions struct {
+	// Tier is the tier whose limits are enforced
+	Tier string
+	// Limits override the limits of the tier, where not 0
+	Limits QuotaLimits
+}
+
+// QuotaUsage is the usage counted against the limits
+type QuotaUsage struct {
+	RequestsThisHour int   `json:"requestsThisHour"`
+	RequestsToday    int   `json:"requestsToday"`
+	StorageBytes     int64 `json:"storageBytes"`
+}
+
+// quotaWindow counts the requests made in a fixed window of time
+type quotaWindow struct {
+	start time.Time
+	count int
+}
+
+// A QuotaEnforcer throttles requests over the limits of a tier
+type QuotaEnforcer struct {
+	sync.Mutex
+	configStore *persistentConfigStore
+	tier        string
+	limits      QuotaLimits
+	now         func() time.Time
+
+	hour     quotaWindow
+	day      quotaWindow
+	tokens   float64
+	refilled time.Time
+}
+
+// NewQuotaEnforcer returns an enforcer of the limits in @param opts, that
+// measures the storage used by @param configStore
+func NewQuotaEnforcer(configStore *persistentConfigStore, opts QuotaOptions) (*QuotaEnforcer, error) {
+	limits, found := TierLimits[opts.Tier]
+	if !found {
+		return nil, fmt.Errorf("unknown tier '%s'", opts.Tier)
+	}
+	if opts.Limits.RequestsPerHour < 0 || opts.Limits.RequestsPerDay < 0 ||
+		opts.Limits.BurstPerSecond < 0 || opts.Limits.StorageBytes < 0 {
+		return nil, fmt.Errorf("quota limits must not be negative")
+	}
+
+	if opts.Limits.RequestsPerHour != 0 {
+		limits.RequestsPerHour = opts.Limits.RequestsPerHour
+	}
+	if opts.Limits.RequestsPerDay != 0 {
+		limits.RequestsPerDay = opts.Limits.RequestsPerDay
+	}
+	if opts.Limits.BurstPerSecond != 0 {
+		limits.BurstPerSecond = opts.Limits.BurstPerSecond
+	}
+	if opts.Limits.StorageBytes != 0 {
+		limits.StorageBytes = opts.Limits.StorageBytes
+	}
+
+	qe := &QuotaEnforcer{configStore: configStore, tier: opts.Tier, limits: limits, now: time.Now}
+	qe.Reset()
+	return qe, nil
+}
+
+// Reset forgets the requests made so far
+func (qe *QuotaEnforcer) Reset() {
+	qe.Lock()
+	defer qe.Unlock()
+
+	now := qe.now()
+	qe.hour = quotaWindow{start: now.Truncate(time.Hour)}
+	qe.day = quotaWindow{start: now.UTC().Truncate(24 * time.Hour)}
+	qe.tokens = float64(qe.limits.BurstPerSecond)
+	qe.refilled = now
+}
+
+// Usage returns the usage counted against the limits
+func (qe *QuotaEnforcer) Usage() (QuotaUsage, error) {
+	storage, err := qe.storageUsed("", "")
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	qe.Lock()
+	defer qe.Unlock()
+	qe.advance(qe.now())
+
+	return QuotaUsage{
+		RequestsThisHour: qe.hour.count,
+		RequestsToday:    qe.day.count,
+		StorageBytes:     storage,
+	}, nil
+}
+
+// advance starts new windows, and refills the burst allowance, up to @param now.
+// This non-exported function DOES NOT manage the Mutex.
+func (qe *QuotaEnforcer) advance(now time.Time) {
+	if hour := now.Truncate(time.Hour); hour.After(qe.hour.start) {
+		qe.hour = quotaWindow{start: hour}
+	}
+	if day := now.UTC().Truncate(24 * time.Hour); day.After(qe.day.start) {
+		qe.day = quotaWindow{start: day}
+	}
+
+	burst := float64(qe.limits.BurstPerSecond)
+	qe.tokens = min(burst, qe.tokens+now.Sub(qe.refilled).Seconds()*burst)
+	qe.refilled = now
+}
+
+// admit counts a request against the limits, unless it is over one of
+// them. Otherwise it returns how long until the request would be admitted,
+// and a description of the limit.
+func (qe *QuotaEnforcer) admit() (time.Duration, string, bool) {
+	qe.Lock()
+	defer qe.Unlock()
+
+	now := qe.now()
+	qe.advance(now)
+
+	if qe.limits.RequestsPerDay > 0 && qe.day.count >= qe.limits.RequestsPerDay {
+		return qe.day.start.Add(24 * time.Hour).Sub(now),
+			fmt.Sprintf("the %s tier allows %d requests per day", qe.tier, qe.limits.RequestsPerDay), false
+	}
+	if qe.limits.RequestsPerHour > 0 && qe.hour.count >= qe.limits.RequestsPerHour {
+		return qe.hour.start.Add(time.Hour).Sub(now),
+			fmt.Sprintf("the %s tier allows %d requests per hour", qe.tier, qe.limits.RequestsPerHour), false
+	}
+	if qe.limits.BurstPerSecond > 0 && qe.tokens < 1 {
+		wait := time.Duration((1 - qe.tokens) / float64(qe.limits.BurstPerSecond) * float64(time.Second))
+		return max(wait, time.Millisecond),
+			fmt.Sprintf("the %s tier allows bursts of %d requests per second", qe.tier, qe.limits.BurstPerSecond), false
+	}
+
+	qe.hour.count++
+	qe.day.count++
+	if qe.limits.BurstPerSecond > 0 {
+		qe.tokens--
+	}
+	return 0, "", true
+}
+
+// storageUsed returns the size of the key-values in the store, other than
+// the one identified by @param key and @param label
+func (qe *QuotaEnforcer) storageUsed(key string, label string) (int64, error) {
+	settings, err := qe.configStore.GetSettings()
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, setting := range settings {
+		if setting.Key == key && setting.Label == label {
+			continue
+		}
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return 0, err
+		}
+		used += keyValueSize(setting.Key, setting.Label, latest.Value, SettingAttributes{ContentType: latest.ContentType, Tags: latest.Tags})
+	}
+
+	return used, nil
+}
+
+// checkStorage reports whether the key-value written by @param request
+// fits in the storage limit, and if not describes the limit
+func (qe *QuotaEnforcer) checkStorage(request ogen.PutKeyValueRequestObject) (string, bool, error) {
+	kv := putKeyValueBody(request)
+	if qe.limits.StorageBytes == 0 || kv == nil || kv.Value == nil {
+		return "", true, nil
+	}
+
+	label := labelParam(request.Params.Label)
+	used, err := qe.storageUsed(request.Key, label)
+	if err != nil {
+		return "", false, err
+	}
+
+	attrs := SettingAttributes{}
+	if kv.ContentType != nil {
+		attrs.ContentType = *kv.ContentType
+	}
+	if kv.Tags != nil {
+		attrs.Tags = *kv.Tags
+	}
+	if used+keyValueSize(request.Key, label, *kv.Value, attrs) <= qe.limits.StorageBytes {
+		return "", true, nil
+	}
+
+	return fmt.Sprintf("the %s tier allows %d bytes of storage", qe.tier, qe.limits.StorageBytes), false, nil
+}
+
+// strictMiddleware throttles the operations over the limits
+func (qe *QuotaEnforcer) strictMiddleware(f ogen.StrictHandlerFunc, operationID string) ogen.StrictHandlerFunc {
+	return func(c *gin.Context, request interface{}) (interface{}, error) {
+		retryAfter, limit, admitted := qe.admit()
+		if !admitted {
+			setRetryHeaders(c, retryAfter)
+			writeError(c, http.StatusTooManyRequests, errTypeTooManyRequests, quotaExceededTitle, "", limit)
+			return nil, nil
+		}
+
+		if put, ok := request.(ogen.PutKeyValueRequestObject); ok {
+			limit, fits, err := qe.checkStorage(put)
+			if err != nil {
+				return nil, err
+			}
+			if !fits {
+				// Retrying won't help until something is deleted
+				writeError(c, http.StatusTooManyRequests, errTypeTooManyRequests, quotaExceededTitle, "", limit)
+				return nil, nil
+			}
+		}
+
+		return f(c, request)
+	}
+}
+
+func registerQuotaRoutes(g *gin.RouterGroup, qe *QuotaEnforcer) {
+	quotas := g.Group("/quotas")
+	quotas.GET("", func(c *gin.Context) {
+		usage, err := qe.Usage()
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, errTypeInternal, "Internal server error", "", err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tier": qe.tier, "limits": qe.limits, "usage": usage})
+	})
+	quotas.DELETE("", func(c *gin.Context) {
+		qe.Reset()
+		c.Status(http.StatusNoContent)
+	})
+}
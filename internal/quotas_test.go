@@ -0,0 +1,144 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	// setup returns a server enforcing @param opts, and a clock it can be
+	// moved on with
+	setup := func(t *testing.T, opts QuotaOptions) (*gin.Engine, *persistentConfigStore, *time.Time, func()) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		quotas, err := NewQuotaEnforcer(store, opts)
+		require.NoError(t, err)
+		now := time.Date(2024, 5, 1, 10, 59, 30, 0, time.UTC)
+		quotas.now = func() time.Time { return now }
+		quotas.Reset()
+
+		gin.SetMode(gin.TestMode)
+		return SetupRestServer(store, WithQuotas(quotas)), store, &now, closer
+	}
+
+	do := func(engine *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, path, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	list := func(engine *gin.Engine) *httptest.ResponseRecorder {
+		return do(engine, http.MethodGet, "/kv?api-version=2023-10-01", "")
+	}
+
+	t.Run("Hourly quotas throttle until the next hour", func(t *testing.T) {
+		engine, _, now, closer := setup(t, QuotaOptions{Tier: TierStandard, Limits: QuotaLimits{RequestsPerHour: 2}})
+		defer closer()
+
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+
+		rec := list(engine)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "30000", rec.Header().Get(retryAfterMsHeader))
+		require.Equal(t, "30000", rec.Header().Get(xMsRetryAfterMsHeader))
+		require.Equal(t, "30", rec.Header().Get("Retry-After"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, errTypeTooManyRequests, body["type"])
+		require.Equal(t, quotaExceededTitle, body["title"])
+		require.Equal(t, "the standard tier allows 2 requests per hour", body["detail"])
+
+		*now = now.Add(30 * time.Second)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+	})
+
+	t.Run("The free tier has a daily quota", func(t *testing.T) {
+		engine, _, now, closer := setup(t, QuotaOptions{Tier: TierFree, Limits: QuotaLimits{RequestsPerDay: 1}})
+		defer closer()
+
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		*now = now.Add(time.Hour)
+		rec := list(engine)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "43230", rec.Header().Get("Retry-After"))
+
+		*now = now.Add(13 * time.Hour)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+	})
+
+	t.Run("Bursts are throttled", func(t *testing.T) {
+		engine, _, now, closer := setup(t, QuotaOptions{Tier: TierPremium, Limits: QuotaLimits{BurstPerSecond: 2}})
+		defer closer()
+
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		rec := list(engine)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "500", rec.Header().Get(retryAfterMsHeader))
+		require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+		*now = now.Add(500 * time.Millisecond)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		require.Equal(t, http.StatusTooManyRequests, list(engine).Code)
+	})
+
+	t.Run("Writes over the storage limit are refused", func(t *testing.T) {
+		engine, store, _, closer := setup(t, QuotaOptions{Tier: TierStandard, Limits: QuotaLimits{StorageBytes: 20}})
+		defer closer()
+
+		_, err := store.UpdateSetting("Key", "12345")
+		require.NoError(t, err)
+
+		// Replacing a value only counts the difference
+		rec := do(engine, http.MethodPut, "/kv/Key?api-version=2023-10-01", `{"value": "1234567890"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(engine, http.MethodPut, "/kv/Other?api-version=2023-10-01", `{"value": "1234567890"}`)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Contains(t, rec.Body.String(), "the standard tier allows 20 bytes of storage")
+		require.Empty(t, rec.Header().Get(retryAfterMsHeader))
+
+		rec = do(engine, http.MethodPut, "/kv/Other?api-version=2023-10-01", `{"value": "1"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Usage is reported, and reset, through the admin API", func(t *testing.T) {
+		engine, store, _, closer := setup(t, QuotaOptions{Tier: TierDeveloper, Limits: QuotaLimits{RequestsPerHour: 1}})
+		defer closer()
+
+		_, err := store.UpdateLabeledSetting("Key", "prod", "value", SettingAttributes{Tags: map[string]string{"a": "b"}})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+		require.Equal(t, http.StatusTooManyRequests, list(engine).Code)
+
+		rec := do(engine, http.MethodGet, AdminBasePath+"/quotas", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{
+			"tier": "developer",
+			"limits": {"requestsPerHour": 1, "burstPerSecond": 50, "storageBytes": 524288000},
+			"usage": {"requestsThisHour": 1, "requestsToday": 1, "storageBytes": 14}
+		}`, rec.Body.String())
+
+		rec = do(engine, http.MethodDelete, AdminBasePath+"/quotas", "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, http.StatusOK, list(engine).Code)
+	})
+
+	t.Run("Unknown tiers and negative limits are rejected", func(t *testing.T) {
+		_, err := NewQuotaEnforcer(nil, QuotaOptions{Tier: "basic"})
+		require.Error(t, err)
+		_, err = NewQuotaEnforcer(nil, QuotaOptions{Tier: TierFree, Limits: QuotaLimits{BurstPerSecond: -1}})
+		require.Error(t, err)
+	})
+}
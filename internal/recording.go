@@ -0,0 +1,482 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Traffic can be recorded to a JSONL file, one request and its response
+// per line, and the recording replayed against an emulator later, to check
+// that it still responds the same way. Headers that carry secrets are not
+// recorded, so a recording can be committed as a regression test; the
+// signature of an HMAC signed request is one of them, so recordings are
+// replayed unauthenticated.
+//
+// On replay, the status, the headers the API documents and the body of
+// each response are compared with those recorded. Some values differ every
+// time a change is made, or from one server to another, and are normalised
+// rather than compared as they are:
+//   - etags, of ETag headers and etag fields, are paired with those replayed
+//     the first time they are seen; from then on, a recorded etag must be
+//     replayed as the etag it is paired with, and no other
+//   - sync tokens, of Sync-Token headers, must be as many, and each of the
+//     form <id>=<value>;sn=<sequence number>
+//   - request ids, of x-ms-request-id headers, must be UUIDs if recorded so
+//   - timestamps, of last_modified, created and expires fields, must be
+//     RFC 3339 timestamps if recorded so
+
+// redactedHeaders are not recorded
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// unrecordedPaths are not recorded: their responses stream, or change
+// from one request to the next
+var unrecordedPaths = []string{WatchPath, MetricsPath}
+
+// exactHeaders are compared as they are
+var exactHeaders = []string{"Content-Type", clientRequestIdHeader}
+
+// timestampFields are the fields of JSON response bodies that hold
+// timestamps
+var timestampFields = []string{"last_modified", "created", "expires"}
+
+// syncTokenPattern matches each of the sync tokens of a Sync-Token header
+var syncTokenPattern = regexp.MustCompile(`^[^=;,\s]+=[^;,\s]+;sn=[0-9]+$`)
+
+// RecordedRequest is a request as recorded. Path is escaped, as sent.
+type RecordedRequest struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   string              `json:"query,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response as recorded
+type RecordedResponse struct {
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// RecordedExchange is one line of a recording
+type RecordedExchange struct {
+	Time     time.Time        `json:"time"`
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// A TrafficRecorder writes every request made to the server, and its
+// response, to a recording
+type TrafficRecorder struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+// NewTrafficRecorder returns a recorder that writes to @param w
+func NewTrafficRecorder(w io.Writer) *TrafficRecorder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &TrafficRecorder{enc: enc}
+}
+
+// recordingWriter keeps a copy of the response body written through it
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) WriteString(s string) (int, error) {
+	rw.body.WriteString(s)
+	return rw.ResponseWriter.WriteString(s)
+}
+
+// middleware records each request and its response
+func (tr *TrafficRecorder) middleware(c *gin.Context) {
+	if slices.Contains(unrecordedPaths, c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	exchange := RecordedExchange{
+		Time: time.Now().UTC(),
+		Request: RecordedRequest{
+			Method:  c.Request.Method,
+			Path:    c.Request.URL.EscapedPath(),
+			Query:   c.Request.URL.RawQuery,
+			Headers: recordedHeaders(c.Request.Header),
+			Body:    string(body),
+		},
+	}
+
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	c.Next()
+
+	exchange.Response = RecordedResponse{
+		Status:  writer.Status(),
+		Headers: recordedHeaders(writer.Header()),
+		Body:    writer.body.String(),
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	err := tr.enc.Encode(exchange)
+	if err != nil {
+		slog.Error("failed to record request", "method", exchange.Request.Method, "path", exchange.Request.Path, "error", err)
+	}
+}
+
+// recordedHeaders returns a copy of @param header without the headers
+// that carry secrets
+func recordedHeaders(header http.Header) map[string][]string {
+	recorded := map[string][]string{}
+	for name, values := range header {
+		if slices.Contains(redactedHeaders, http.CanonicalHeaderKey(name)) {
+			continue
+		}
+		recorded[name] = slices.Clone(values)
+	}
+	return recorded
+}
+
+// ReadRecording reads every exchange in the recording @param r
+func ReadRecording(r io.Reader) ([]RecordedExchange, error) {
+	exchanges := []RecordedExchange{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var exchange RecordedExchange
+		err := json.Unmarshal(scanner.Bytes(), &exchange)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse line %d of the recording", line)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read the recording")
+	}
+
+	return exchanges, nil
+}
+
+// ReplayOptions configure Replay
+type ReplayOptions struct {
+	// Target is the base URL of the emulator to replay against
+	Target string
+	// IgnoredFields are the JSON response body fields, at any depth, not
+	// compared
+	IgnoredFields []string
+	// Client makes the requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// ReplayResult is the outcome of replaying one exchange
+type ReplayResult struct {
+	Exchange RecordedExchange
+	Response RecordedResponse
+	// Differences describes how the response differs from the recorded
+	// one; it is empty if they match
+	Differences []string
+}
+
+// Replay re-issues each of @param exchanges, in order, and compares the
+// responses with those recorded. JSON bodies are compared as JSON.
+func Replay(ctx context.Context, exchanges []RecordedExchange, opts ReplayOptions) ([]ReplayResult, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	target := strings.TrimSuffix(opts.Target, "/")
+
+	etags := newEtagPairs()
+	results := make([]ReplayResult, 0, len(exchanges))
+	for i, exchange := range exchanges {
+		response, err := replayRequest(ctx, opts.Client, target, exchange.Request)
+		if err != nil {
+			return results, errors.Wrapf(err, "failed to replay request %d, %s %s", i+1, exchange.Request.Method, exchange.Request.Path)
+		}
+
+		results = append(results, ReplayResult{
+			Exchange:    exchange,
+			Response:    response,
+			Differences: compareResponses(exchange.Response, response, opts.IgnoredFields, etags),
+		})
+	}
+
+	return results, nil
+}
+
+// replayRequest issues @param recorded against @param target
+func replayRequest(ctx context.Context, client *http.Client, target string, recorded RecordedRequest) (RecordedResponse, error) {
+	url := target + recorded.Path
+	if recorded.Query != "" {
+		url += "?" + recorded.Query
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, recorded.Method, url, strings.NewReader(recorded.Body))
+	if err != nil {
+		return RecordedResponse{}, err
+	}
+	for name, values := range recorded.Headers {
+		if http.CanonicalHeaderKey(name) == "Content-Length" {
+			continue
+		}
+		rq.Header[name] = values
+	}
+
+	rs, err := client.Do(rq)
+	if err != nil {
+		return RecordedResponse{}, err
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		return RecordedResponse{}, err
+	}
+
+	return RecordedResponse{
+		Status:  rs.StatusCode,
+		Headers: recordedHeaders(rs.Header),
+		Body:    string(body),
+	}, nil
+}
+
+// etagPairs pairs the etags recorded with those replayed
+type etagPairs struct {
+	// replayed are the replayed etags, by recorded etag
+	replayed map[string]string
+	// recorded are the recorded etags, by replayed etag
+	recorded map[string]string
+}
+
+func newEtagPairs() *etagPairs {
+	return &etagPairs{replayed: map[string]string{}, recorded: map[string]string{}}
+}
+
+// pair pairs the etags @param recorded and @param replayed, if neither is
+// paired yet, and reports whether they are paired with each other
+func (ep *etagPairs) pair(recorded string, replayed string) bool {
+	pairedReplayed, recordedPaired := ep.replayed[recorded]
+	pairedRecorded, replayedPaired := ep.recorded[replayed]
+	if !recordedPaired && !replayedPaired {
+		ep.replayed[recorded] = replayed
+		ep.recorded[replayed] = recorded
+		return true
+	}
+	return pairedReplayed == replayed && pairedRecorded == recorded
+}
+
+// compareResponses describes the differences between @param recorded and
+// @param replayed, ignoring the JSON body fields @param ignoredFields, and
+// pairing their etags in @param etags
+func compareResponses(recorded RecordedResponse, replayed RecordedResponse, ignoredFields []string, etags *etagPairs) []string {
+	differences := []string{}
+
+	if recorded.Status != replayed.Status {
+		differences = append(differences, fmt.Sprintf("status: recorded %d, replayed %d", recorded.Status, replayed.Status))
+	}
+	differences = append(differences, compareHeaders(http.Header(recorded.Headers), http.Header(replayed.Headers), etags)...)
+
+	var recordedBody, replayedBody interface{}
+	if json.Unmarshal([]byte(recorded.Body), &recordedBody) == nil &&
+		json.Unmarshal([]byte(replayed.Body), &replayedBody) == nil {
+		return append(differences, compareJSON("$", recordedBody, replayedBody, ignoredFields, etags)...)
+	}
+
+	if recorded.Body != replayed.Body {
+		differences = append(differences, fmt.Sprintf("body: recorded %q, replayed %q", recorded.Body, replayed.Body))
+	}
+
+	return differences
+}
+
+// compareHeaders describes the differences between the documented headers
+// of @param recorded and @param replayed, pairing their etags in @param etags
+func compareHeaders(recorded http.Header, replayed http.Header, etags *etagPairs) []string {
+	differences := []string{}
+	differ := func(name string) {
+		differences = append(differences, fmt.Sprintf("%s: recorded %q, replayed %q", name, recorded.Get(name), replayed.Get(name)))
+	}
+
+	for _, name := range exactHeaders {
+		if recorded.Get(name) != replayed.Get(name) {
+			differ(name)
+		}
+	}
+
+	recordedEtag, replayedEtag := recorded.Get("ETag"), replayed.Get("ETag")
+	if (recordedEtag == "") != (replayedEtag == "") ||
+		strings.HasPrefix(recordedEtag, `"`) != strings.HasPrefix(replayedEtag, `"`) ||
+		(recordedEtag != "" && !etags.pair(strings.Trim(recordedEtag, `"`), strings.Trim(replayedEtag, `"`))) {
+		differ("ETag")
+	}
+
+	if !syncTokensAlike(recorded.Get("Sync-Token"), replayed.Get("Sync-Token")) {
+		differ("Sync-Token")
+	}
+
+	recordedId, replayedId := recorded.Get(requestIdHeader), replayed.Get(requestIdHeader)
+	if (recordedId == "") != (replayedId == "") || (uuid.Validate(recordedId) == nil && uuid.Validate(replayedId) != nil) {
+		differ(requestIdHeader)
+	}
+
+	return differences
+}
+
+// syncTokensAlike reports whether the Sync-Token headers @param recorded
+// and @param replayed hold as many sync tokens, each well formed
+func syncTokensAlike(recorded string, replayed string) bool {
+	if recorded == "" || replayed == "" {
+		return recorded == replayed
+	}
+
+	recordedTokens := strings.Split(recorded, ",")
+	replayedTokens := strings.Split(replayed, ",")
+	if len(recordedTokens) != len(replayedTokens) {
+		return false
+	}
+	for _, token := range replayedTokens {
+		if !syncTokenPattern.MatchString(strings.TrimSpace(token)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareJSON describes the differences between the JSON values
+// @param recorded and @param replayed, found at @param path, pairing their
+// etags in @param etags
+func compareJSON(path string, recorded interface{}, replayed interface{}, ignoredFields []string, etags *etagPairs) []string {
+	recordedObject, recordedIsObject := recorded.(map[string]interface{})
+	replayedObject, replayedIsObject := replayed.(map[string]interface{})
+	if recordedIsObject && replayedIsObject {
+		names := []string{}
+		for name := range recordedObject {
+			names = append(names, name)
+		}
+		for name := range replayedObject {
+			if _, found := recordedObject[name]; !found {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		differences := []string{}
+		for _, name := range names {
+			fieldPath := path + "." + name
+			switch {
+			case slices.Contains(ignoredFields, name):
+			case name == "etag":
+				differences = append(differences, compareEtags(fieldPath, recordedObject[name], replayedObject[name], etags)...)
+			case slices.Contains(timestampFields, name):
+				differences = append(differences, compareTimestamps(fieldPath, recordedObject[name], replayedObject[name])...)
+			default:
+				differences = append(differences,
+					compareJSON(fieldPath, recordedObject[name], replayedObject[name], ignoredFields, etags)...)
+			}
+		}
+		return differences
+	}
+
+	recordedArray, recordedIsArray := recorded.([]interface{})
+	replayedArray, replayedIsArray := replayed.([]interface{})
+	if recordedIsArray && replayedIsArray {
+		if len(recordedArray) != len(replayedArray) {
+			return []string{fmt.Sprintf("%s: recorded %d items, replayed %d", path, len(recordedArray), len(replayedArray))}
+		}
+
+		differences := []string{}
+		for i := range recordedArray {
+			differences = append(differences,
+				compareJSON(fmt.Sprintf("%s[%d]", path, i), recordedArray[i], replayedArray[i], ignoredFields, etags)...)
+		}
+		return differences
+	}
+
+	return compareValues(path, recorded, replayed)
+}
+
+// compareEtags describes how the etag fields @param recorded and
+// @param replayed, found at @param path, differ once paired in @param etags
+func compareEtags(path string, recorded interface{}, replayed interface{}, etags *etagPairs) []string {
+	recordedEtag, recordedIsString := recorded.(string)
+	replayedEtag, replayedIsString := replayed.(string)
+	if recordedIsString && replayedIsString && etags.pair(recordedEtag, replayedEtag) {
+		return nil
+	}
+	return compareValues(path, recorded, replayed)
+}
+
+// compareTimestamps describes how the timestamp fields @param recorded and
+// @param replayed, found at @param path, differ, if one is an RFC 3339
+// timestamp and the other is not
+func compareTimestamps(path string, recorded interface{}, replayed interface{}) []string {
+	if isTimestamp(recorded) && isTimestamp(replayed) {
+		return nil
+	}
+	return compareValues(path, recorded, replayed)
+}
+
+func isTimestamp(value interface{}) bool {
+	timestamp, ok := value.(string)
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339, timestamp)
+	return err == nil
+}
+
+// compareValues describes how the JSON values @param recorded and
+// @param replayed, found at @param path, differ, if they do
+func compareValues(path string, recorded interface{}, replayed interface{}) []string {
+	recordedJson, _ := json.Marshal(recorded)
+	replayedJson, _ := json.Marshal(replayed)
+	if !bytes.Equal(recordedJson, replayedJson) {
+		return []string{fmt.Sprintf("%s: recorded %s, replayed %s", path, recordedJson, replayedJson)}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecording(t *testing.T) {
+	record := func(t *testing.T) []RecordedExchange {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		var recording bytes.Buffer
+		gin.SetMode(gin.TestMode)
+		engine := SetupRestServer(store, WithRecorder(NewTrafficRecorder(&recording)))
+
+		for _, rq := range []*http.Request{
+			httptest.NewRequest(http.MethodPut, "/kv/App:Name?api-version=2023-10-01", strings.NewReader(`{"value": "demo"}`)),
+			httptest.NewRequest(http.MethodGet, "/kv/App:Name?api-version=2023-10-01", nil),
+			httptest.NewRequest(http.MethodGet, "/kv?key=App:*&api-version=2023-10-01", nil),
+			httptest.NewRequest(http.MethodGet, MetricsPath, nil),
+		} {
+			rq.Header.Set("Content-Type", "application/json")
+			rq.Header.Set("Authorization", "HMAC-SHA256 Credential=id&SignedHeaders=host&Signature=secret")
+			engine.ServeHTTP(httptest.NewRecorder(), rq)
+		}
+
+		exchanges, err := ReadRecording(&recording)
+		require.NoError(t, err)
+		return exchanges
+	}
+
+	replayTarget := func(t *testing.T) (*persistentConfigStore, *httptest.Server, func()) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		server := httptest.NewServer(SetupRestServer(store))
+		return store, server, func() {
+			server.Close()
+			closer()
+		}
+	}
+
+	t.Run("Requests are recorded without secrets", func(t *testing.T) {
+		exchanges := record(t)
+		require.Len(t, exchanges, 3)
+
+		put := exchanges[0]
+		require.Equal(t, http.MethodPut, put.Request.Method)
+		require.Equal(t, "/kv/App:Name", put.Request.Path)
+		require.Equal(t, "api-version=2023-10-01", put.Request.Query)
+		require.Equal(t, `{"value": "demo"}`, put.Request.Body)
+		require.Equal(t, []string{"application/json"}, put.Request.Headers["Content-Type"])
+		require.NotContains(t, put.Request.Headers, "Authorization")
+		require.Equal(t, http.StatusOK, put.Response.Status)
+		require.Contains(t, put.Response.Body, `"value":"demo"`)
+	})
+
+	t.Run("Replaying against the same state matches", func(t *testing.T) {
+		exchanges := record(t)
+		_, server, stop := replayTarget(t)
+		defer stop()
+
+		results, err := Replay(context.Background(), exchanges, ReplayOptions{Target: server.URL})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		for _, result := range results {
+			require.Empty(t, result.Differences, result.Exchange.Request.Path)
+		}
+	})
+
+	t.Run("Replaying against a different state reports the differences", func(t *testing.T) {
+		exchanges := record(t)
+		store, server, stop := replayTarget(t)
+		defer stop()
+
+		_, err := store.UpdateSetting("App:Other", "extra")
+		require.NoError(t, err)
+		_, err = store.LockSetting("App:Other")
+		require.NoError(t, err)
+
+		results, err := Replay(context.Background(), exchanges, ReplayOptions{Target: server.URL})
+		require.NoError(t, err)
+		require.Empty(t, results[0].Differences)
+		require.Empty(t, results[1].Differences)
+		require.Equal(t, []string{"$.items: recorded 1 items, replayed 2"}, results[2].Differences)
+	})
+
+	t.Run("JSON bodies are compared field by field", func(t *testing.T) {
+		differences := compareResponses(
+			RecordedResponse{Status: 200, Body: `{"key": "a", "value": "1", "etag": "x", "tags": {}, "note": "n"}`},
+			RecordedResponse{Status: 404, Body: `{"key": "a", "value": "2", "etag": "y", "locked": true, "tags": {}, "note": "m"}`},
+			[]string{"note"},
+			newEtagPairs(),
+		)
+		require.Equal(t, []string{
+			"status: recorded 200, replayed 404",
+			`$.locked: recorded null, replayed true`,
+			`$.value: recorded "1", replayed "2"`,
+		}, differences)
+	})
+
+	t.Run("Etags, sync tokens, request ids and timestamps are normalised", func(t *testing.T) {
+		response := func(etag string, syncToken string, requestId string, lastModified string) RecordedResponse {
+			headers := http.Header{}
+			for name, value := range map[string]string{"ETag": etag, "Sync-Token": syncToken, requestIdHeader: requestId} {
+				if value != "" {
+					headers.Set(name, value)
+				}
+			}
+			return RecordedResponse{
+				Status:  http.StatusOK,
+				Headers: headers,
+				Body:    fmt.Sprintf(`{"etag": %q, "last_modified": %s}`, strings.Trim(etag, `"`), lastModified),
+			}
+		}
+		etags := newEtagPairs()
+
+		require.Empty(t, compareResponses(
+			response(`"a"`, "zAJw6V16=MDoyOCMzMzg5MDU=;sn=338905", "5f1c2a4e-0b5e-4f7a-9c1d-2e3f4a5b6c7d", `"2024-05-01T10:00:00+00:00"`),
+			response(`"x"`, "aacemu=MDox;sn=1", "0c9d8e7f-6a5b-4c3d-2e1f-0a9b8c7d6e5f", `"2025-01-02T03:04:05.678Z"`),
+			nil, etags,
+		))
+
+		// Once paired, a recorded etag must be replayed as the same etag
+		require.Equal(t, []string{
+			`ETag: recorded "\"a\"", replayed "\"z\""`,
+			`Sync-Token: recorded "zAJw6V16=MDoyOCMzMzg5MDU=;sn=338906", replayed "aacemu=MDox"`,
+			`x-ms-request-id: recorded "5f1c2a4e-0b5e-4f7a-9c1d-2e3f4a5b6c7d", replayed ""`,
+			`$.etag: recorded "a", replayed "z"`,
+			`$.last_modified: recorded "2024-05-01T10:00:00+00:00", replayed null`,
+		}, compareResponses(
+			response(`"a"`, "zAJw6V16=MDoyOCMzMzg5MDU=;sn=338906", "5f1c2a4e-0b5e-4f7a-9c1d-2e3f4a5b6c7d", `"2024-05-01T10:00:00+00:00"`),
+			response(`"z"`, "aacemu=MDox", "", "null"),
+			nil, etags,
+		))
+
+		// Nor may two recorded etags be replayed as the same one
+		require.Equal(t, []string{
+			`ETag: recorded "\"b\"", replayed "\"x\""`,
+			`$.etag: recorded "b", replayed "x"`,
+		}, compareResponses(response(`"b"`, "", "", "null"), response(`"x"`, "", "", "null"), nil, etags))
+	})
+}
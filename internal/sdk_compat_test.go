@@ -0,0 +1,402 @@
+package emulator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azappconfig"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// The SDK compatibility suite drives the emulator with the azappconfig Go
+// SDK, authenticated with a connection string. Any difference from the
+// service that the SDK can see fails the suite.
+//
+// The SDK has no operations for listing keys or labels, which are listed
+// with requests signed as the SDK signs them. SettingSelector.AcceptDateTime
+// is left out, as point in time reads are not supported.
+
+// sdkClient returns an SDK client of the emulator at @param endpoint,
+// signing requests with @param credential. Requests are not retried, so
+// that errors surface at once.
+func sdkClient(t *testing.T, endpoint string, credential Credential) *azappconfig.Client {
+	connectionString := fmt.Sprintf("Endpoint=%s;Id=%s;Secret=%s", endpoint, credential.Id, credential.Secret)
+	client, err := azappconfig.NewClientFromConnectionString(connectionString, &azappconfig.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return client
+}
+
+// requireResponseError checks @param err is the service's error response
+// with @param status
+func requireResponseError(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var responseErr *azcore.ResponseError
+	require.ErrorAs(t, err, &responseErr)
+	require.Equal(t, status, responseErr.StatusCode, responseErr.Error())
+}
+
+// listSettings returns every setting @param selector matches, following
+// pages, and the number of pages
+func listSettings(t *testing.T, client *azappconfig.Client, selector azappconfig.SettingSelector) ([]azappconfig.Setting, int) {
+	t.Helper()
+
+	settings := []azappconfig.Setting{}
+	pages := 0
+	pager := client.NewListSettingsPager(selector, nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		require.NoError(t, err)
+		settings = append(settings, page.Settings...)
+		pages++
+	}
+	return settings, pages
+}
+
+func TestSdkCompatibility(t *testing.T) {
+	credential := Credential{Id: "sdk-id", Secret: base64.StdEncoding.EncodeToString([]byte("sdk-secret"))}
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*azappconfig.Client, *persistentConfigStore, string) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		gin.SetMode(gin.TestMode)
+		server := httptest.NewServer(SetupRestServer(store, WithHmacAuth([]Credential{credential})))
+		t.Cleanup(func() {
+			server.Close()
+			closer()
+		})
+
+		return sdkClient(t, server.URL, credential), store, server.URL
+	}
+
+	t.Run("Settings are set, got and deleted", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		set, err := client.SetSetting(ctx, "App:Name", to.Ptr("demo"), &azappconfig.SetSettingOptions{
+			Label:       to.Ptr("prod"),
+			ContentType: to.Ptr("text/plain"),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "App:Name", *set.Key)
+		require.Equal(t, "prod", *set.Label)
+		require.Equal(t, "demo", *set.Value)
+		require.Equal(t, "text/plain", *set.ContentType)
+		require.False(t, *set.IsReadOnly)
+		require.NotEmpty(t, *set.ETag)
+		require.NotNil(t, set.LastModified)
+		require.NotEmpty(t, set.SyncToken)
+
+		got, err := client.GetSetting(ctx, "App:Name", &azappconfig.GetSettingOptions{Label: to.Ptr("prod")})
+		require.NoError(t, err)
+		require.Equal(t, set.Setting, got.Setting)
+
+		deleted, err := client.DeleteSetting(ctx, "App:Name", &azappconfig.DeleteSettingOptions{Label: to.Ptr("prod")})
+		require.NoError(t, err)
+		require.Equal(t, "demo", *deleted.Value)
+
+		_, err = client.GetSetting(ctx, "App:Name", &azappconfig.GetSettingOptions{Label: to.Ptr("prod")})
+		requireResponseError(t, err, http.StatusNotFound)
+		_, err = client.DeleteSetting(ctx, "App:Name", &azappconfig.DeleteSettingOptions{Label: to.Ptr("prod")})
+		requireResponseError(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Keys with reserved characters round trip", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		for _, key := range []string{"a/b", "a b", "a+b", "a?b#c", "ключ"} {
+			_, err := client.SetSetting(ctx, key, to.Ptr("v"), nil)
+			require.NoError(t, err, key)
+			got, err := client.GetSetting(ctx, key, nil)
+			require.NoError(t, err, key)
+			require.Equal(t, key, *got.Key)
+		}
+	})
+
+	t.Run("Settings are listed with key and label filters, following pages", func(t *testing.T) {
+		client, store, _ := setup(t)
+
+		for i := 0; i < 250; i++ {
+			_, err := store.UpdateSetting(fmt.Sprintf("App:%03d", i), "v")
+			require.NoError(t, err)
+		}
+		_, err := store.UpdateLabeledSetting("App:000", "prod", "p", SettingAttributes{})
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "v")
+		require.NoError(t, err)
+
+		// Without a label filter, every label is listed
+		settings, pages := listSettings(t, client, azappconfig.SettingSelector{KeyFilter: to.Ptr("App:*")})
+		require.Len(t, settings, 251)
+		require.Equal(t, 3, pages)
+		seen := map[string]bool{}
+		for _, setting := range settings {
+			id := *setting.Key + "/" + *setting.Label
+			require.False(t, seen[id], "%s is listed twice", id)
+			seen[id] = true
+		}
+
+		settings, _ = listSettings(t, client, azappconfig.SettingSelector{KeyFilter: to.Ptr("App:*"), LabelFilter: to.Ptr("prod")})
+		require.Len(t, settings, 1)
+
+		settings, _ = listSettings(t, client, azappconfig.SettingSelector{KeyFilter: to.Ptr("App:000,Other"), LabelFilter: to.Ptr("*")})
+		require.Len(t, settings, 3)
+
+		settings, pages = listSettings(t, client, azappconfig.SettingSelector{KeyFilter: to.Ptr("Nothing*")})
+		require.Empty(t, settings)
+		require.Equal(t, 1, pages)
+	})
+
+	t.Run("Sync tokens are returned and accepted", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		set, err := client.SetSetting(ctx, "App:Name", to.Ptr("demo"), nil)
+		require.NoError(t, err)
+		require.NotEmpty(t, set.SyncToken)
+		require.NoError(t, client.SetSyncToken(set.SyncToken))
+
+		// The client sends the token back on its next request
+		got, err := client.GetSetting(ctx, "App:Name", nil)
+		require.NoError(t, err)
+		require.Equal(t, set.SyncToken, got.SyncToken)
+	})
+
+	t.Run("Read-only settings can't be changed until made writable", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		_, err := client.SetSetting(ctx, "App:Name", to.Ptr("demo"), nil)
+		require.NoError(t, err)
+
+		locked, err := client.SetReadOnly(ctx, "App:Name", true, nil)
+		require.NoError(t, err)
+		require.True(t, *locked.IsReadOnly)
+
+		_, err = client.SetSetting(ctx, "App:Name", to.Ptr("refused"), nil)
+		requireResponseError(t, err, http.StatusConflict)
+		_, err = client.DeleteSetting(ctx, "App:Name", nil)
+		requireResponseError(t, err, http.StatusConflict)
+
+		unlocked, err := client.SetReadOnly(ctx, "App:Name", false, nil)
+		require.NoError(t, err)
+		require.False(t, *unlocked.IsReadOnly)
+
+		changed, err := client.SetSetting(ctx, "App:Name", to.Ptr("changed"), nil)
+		require.NoError(t, err)
+		require.Equal(t, "changed", *changed.Value)
+
+		_, err = client.SetReadOnly(ctx, "App:Missing", true, nil)
+		requireResponseError(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Snapshots are created, listed, read and archived", func(t *testing.T) {
+		client, store, _ := setup(t)
+
+		_, err := store.UpdateSetting("App:Name", "v")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "v")
+		require.NoError(t, err)
+
+		poller, err := client.BeginCreateSnapshot(ctx, "release", []azappconfig.SettingFilter{{KeyFilter: to.Ptr("App:*")}}, nil)
+		require.NoError(t, err)
+		created, err := poller.PollUntilDone(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, "release", *created.Name)
+		require.Equal(t, azappconfig.SnapshotStatusReady, *created.Status)
+
+		snapshots := []azappconfig.Snapshot{}
+		pager := client.NewListSnapshotsPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			require.NoError(t, err)
+			snapshots = append(snapshots, page.Snapshots...)
+		}
+		require.Len(t, snapshots, 1)
+
+		got, err := client.GetSnapshot(ctx, "release", nil)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), *got.ItemsCount)
+
+		settings := []azappconfig.Setting{}
+		settingsPager := client.NewListSettingsForSnapshotPager("release", nil)
+		for settingsPager.More() {
+			page, err := settingsPager.NextPage(ctx)
+			require.NoError(t, err)
+			settings = append(settings, page.Settings...)
+		}
+		require.Len(t, settings, 1)
+		require.Equal(t, "App:Name", *settings[0].Key)
+
+		archived, err := client.ArchiveSnapshot(ctx, "release", nil)
+		require.NoError(t, err)
+		require.Equal(t, azappconfig.SnapshotStatusArchived, *archived.Status)
+		recovered, err := client.RecoverSnapshot(ctx, "release", nil)
+		require.NoError(t, err)
+		require.Equal(t, azappconfig.SnapshotStatusReady, *recovered.Status)
+
+		_, err = client.GetSnapshot(ctx, "missing", nil)
+		requireResponseError(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Revisions are listed newest first, following pages", func(t *testing.T) {
+		client, store, _ := setup(t)
+
+		for _, value := range []string{"v1", "v2", "v3"} {
+			_, err := client.SetSetting(ctx, "App:Name", to.Ptr(value), nil)
+			require.NoError(t, err)
+		}
+		_, err := client.SetSetting(ctx, "App:Name", to.Ptr("p"), &azappconfig.SetSettingOptions{Label: to.Ptr("prod")})
+		require.NoError(t, err)
+		for i := 0; i < 150; i++ {
+			_, err := store.UpdateSetting("Busy", fmt.Sprintf("%03d", i))
+			require.NoError(t, err)
+		}
+
+		listRevisions := func(selector azappconfig.SettingSelector) ([]azappconfig.Setting, int) {
+			revisions := []azappconfig.Setting{}
+			pages := 0
+			pager := client.NewListRevisionsPager(selector, nil)
+			for pager.More() {
+				page, err := pager.NextPage(ctx)
+				require.NoError(t, err)
+				revisions = append(revisions, page.Settings...)
+				pages++
+			}
+			return revisions, pages
+		}
+
+		revisions, pages := listRevisions(azappconfig.SettingSelector{KeyFilter: to.Ptr("App:Name")})
+		require.Equal(t, 1, pages)
+		values := []string{}
+		etags := map[azcore.ETag]bool{}
+		for _, revision := range revisions {
+			values = append(values, *revision.Label+"="+*revision.Value)
+			etags[*revision.ETag] = true
+		}
+		require.Equal(t, []string{"=v3", "=v2", "=v1", "prod=p"}, values)
+		require.Len(t, etags, 4, "each revision has its own etag")
+
+		revisions, _ = listRevisions(azappconfig.SettingSelector{KeyFilter: to.Ptr("App:*"), LabelFilter: to.Ptr("prod")})
+		require.Len(t, revisions, 1)
+
+		revisions, pages = listRevisions(azappconfig.SettingSelector{KeyFilter: to.Ptr("Busy")})
+		require.Equal(t, 2, pages)
+		require.Len(t, revisions, 150)
+		for i, revision := range revisions {
+			require.Equal(t, fmt.Sprintf("%03d", 149-i), *revision.Value)
+		}
+	})
+
+	t.Run("Keys and labels are listed", func(t *testing.T) {
+		_, store, endpoint := setup(t)
+
+		for _, label := range []string{NullLabel, "prod", "test"} {
+			_, err := store.UpdateLabeledSetting("App:Name", label, "v", SettingAttributes{})
+			require.NoError(t, err)
+		}
+		_, err := store.UpdateLabeledSetting("Other", "prod", "v", SettingAttributes{})
+		require.NoError(t, err)
+
+		list := func(path string) []*string {
+			rq, err := http.NewRequest(http.MethodGet, endpoint+path, nil)
+			require.NoError(t, err)
+			signRequest(t, rq, "", credential)
+			rs, err := http.DefaultClient.Do(rq)
+			require.NoError(t, err)
+			defer rs.Body.Close()
+			require.Equal(t, http.StatusOK, rs.StatusCode)
+
+			var body struct {
+				Items []struct {
+					Name *string `json:"name"`
+				} `json:"items"`
+			}
+			require.NoError(t, json.NewDecoder(rs.Body).Decode(&body))
+			names := []*string{}
+			for _, item := range body.Items {
+				names = append(names, item.Name)
+			}
+			return names
+		}
+
+		require.Equal(t, []*string{nil, to.Ptr("prod"), to.Ptr("test")}, list("/labels?api-version=2023-10-01"))
+		require.Equal(t, []*string{to.Ptr("prod")}, list("/labels?name=p*&api-version=2023-10-01"))
+		require.Equal(t, []*string{to.Ptr("App:Name"), to.Ptr("Other")}, list("/keys?api-version=2023-10-01"))
+		require.Equal(t, []*string{to.Ptr("Other")}, list("/keys?name=O*&api-version=2023-10-01"))
+	})
+
+	t.Run("Settings are only added if they don't exist", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		added, err := client.AddSetting(ctx, "App:Name", to.Ptr("demo"), nil)
+		require.NoError(t, err)
+		require.Equal(t, "demo", *added.Value)
+
+		_, err = client.AddSetting(ctx, "App:Name", to.Ptr("again"), nil)
+		requireResponseError(t, err, http.StatusPreconditionFailed)
+
+		got, err := client.GetSetting(ctx, "App:Name", nil)
+		require.NoError(t, err)
+		require.Equal(t, "demo", *got.Value)
+	})
+
+	t.Run("Conditional requests compare etags", func(t *testing.T) {
+		client, _, _ := setup(t)
+
+		first, err := client.SetSetting(ctx, "App:Name", to.Ptr("v1"), nil)
+		require.NoError(t, err)
+
+		_, err = client.GetSetting(ctx, "App:Name", &azappconfig.GetSettingOptions{OnlyIfChanged: first.ETag})
+		requireResponseError(t, err, http.StatusNotModified)
+
+		second, err := client.SetSetting(ctx, "App:Name", to.Ptr("v2"), &azappconfig.SetSettingOptions{OnlyIfUnchanged: first.ETag})
+		require.NoError(t, err)
+		require.NotEqual(t, *first.ETag, *second.ETag)
+
+		got, err := client.GetSetting(ctx, "App:Name", &azappconfig.GetSettingOptions{OnlyIfChanged: first.ETag})
+		require.NoError(t, err)
+		require.Equal(t, "v2", *got.Value)
+
+		// The first etag is stale now
+		_, err = client.SetSetting(ctx, "App:Name", to.Ptr("v3"), &azappconfig.SetSettingOptions{OnlyIfUnchanged: first.ETag})
+		requireResponseError(t, err, http.StatusPreconditionFailed)
+		_, err = client.SetReadOnly(ctx, "App:Name", true, &azappconfig.SetReadOnlyOptions{OnlyIfUnchanged: first.ETag})
+		requireResponseError(t, err, http.StatusPreconditionFailed)
+		_, err = client.DeleteSetting(ctx, "App:Name", &azappconfig.DeleteSettingOptions{OnlyIfUnchanged: first.ETag})
+		requireResponseError(t, err, http.StatusPreconditionFailed)
+
+		locked, err := client.SetReadOnly(ctx, "App:Name", true, &azappconfig.SetReadOnlyOptions{OnlyIfUnchanged: second.ETag})
+		require.NoError(t, err)
+		require.True(t, *locked.IsReadOnly)
+		_, err = client.SetReadOnly(ctx, "App:Name", false, &azappconfig.SetReadOnlyOptions{OnlyIfUnchanged: second.ETag})
+		require.NoError(t, err)
+
+		_, err = client.DeleteSetting(ctx, "App:Name", &azappconfig.DeleteSettingOptions{OnlyIfUnchanged: second.ETag})
+		require.NoError(t, err)
+		_, err = client.DeleteSetting(ctx, "App:Name", &azappconfig.DeleteSettingOptions{OnlyIfUnchanged: second.ETag})
+		requireResponseError(t, err, http.StatusPreconditionFailed)
+	})
+
+	t.Run("Unsigned and wrongly signed requests are refused", func(t *testing.T) {
+		_, _, endpoint := setup(t)
+
+		rs, err := http.Get(endpoint + "/kv?api-version=2023-10-01")
+		require.NoError(t, err)
+		rs.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, rs.StatusCode)
+
+		wrong := sdkClient(t, endpoint, Credential{Id: credential.Id, Secret: base64.StdEncoding.EncodeToString([]byte("wrong"))})
+		_, err = wrong.GetSetting(ctx, "App:Name", nil)
+		requireResponseError(t, err, http.StatusUnauthorized)
+	})
+}
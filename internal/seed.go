@@ -0,0 +1,556 @@
+package emulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Seed file formats understood by ImportSettings
+const (
+	// SeedFormatRecords is a JSON array of SettingRecords, as written by ExportSettings
+	SeedFormatRecords = "records"
+	// SeedFormatJSON is a flat or nested JSON object
+	SeedFormatJSON = "json"
+	// SeedFormatYAML is a flat or nested YAML mapping
+	SeedFormatYAML = "yaml"
+	// SeedFormatProperties is a Java .properties file
+	SeedFormatProperties = "properties"
+	// SeedFormatDotEnv is a .env file of NAME=value lines
+	SeedFormatDotEnv = "env"
+	// SeedFormatKVSet is the Azure CLI's kvset profile
+	SeedFormatKVSet = "kvset"
+)
+
+var SeedFormats = []string{SeedFormatRecords, SeedFormatJSON, SeedFormatYAML, SeedFormatProperties, SeedFormatDotEnv, SeedFormatKVSet}
+
+// DefaultSeedSeparator joins the keys of nested JSON and YAML objects
+const DefaultSeedSeparator = ":"
+
+// SettingRecord is the file representation of a single key-value, used
+// for seeding the store and exporting from it.
+type SettingRecord struct {
+	Key         string            `json:"key" yaml:"key"`
+	Label       string            `json:"label,omitempty" yaml:"label,omitempty"`
+	Value       string            `json:"value" yaml:"value"`
+	ContentType string            `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Locked      bool              `json:"locked,omitempty" yaml:"locked,omitempty"`
+}
+
+// ImportOptions control how a seed file is turned into settings
+type ImportOptions struct {
+	// Format is one of SeedFormats. If empty, it is detected from the file
+	// extension, and JSON or YAML holding an array is read as records.
+	Format string
+	// Separator joins the keys of nested objects. Defaults to DefaultSeedSeparator.
+	Separator string
+	// Prefix is prepended to every key
+	Prefix string
+	// Label, ContentType and Tags apply to every setting that does not set its own.
+	// A JSON ContentType imports the values of JSON and YAML files as JSON.
+	// None of these apply to kvset files, which are imported as they are.
+	Label       string
+	ContentType string
+	Tags        map[string]string
+}
+
+// ImportSettings reads settings in the format given by @param opts from
+// @param r and writes each one to the store, adding a version only if it
+// differs from the latest, and locking or unlocking it as the record says.
+// Importing the same settings again so changes nothing. Returns the number
+// of settings imported.
+func ImportSettings(store *persistentConfigStore, r io.Reader, opts ImportOptions) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read settings")
+	}
+
+	records, err := parseSeed(data, opts)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to decode %s settings", opts.Format)
+	}
+
+	for i, record := range records {
+		if opts.Format != SeedFormatKVSet {
+			record = opts.apply(record)
+		}
+
+		if IsFeatureFlag(record.Key, record.ContentType) {
+			_, err := ParseFeatureFlag(record.Key, record.Value)
+			if err != nil {
+				return i, err
+			}
+		}
+
+		err := store.As(Actor{}).CaptureSetting(record.Key, record.Label, record.Value, SettingAttributes{
+			ContentType: record.ContentType,
+			Tags:        record.Tags,
+		}, record.Locked)
+		if err != nil {
+			return i, errors.Wrapf(err, "failed to import setting %s", record.Key)
+		}
+	}
+
+	return len(records), nil
+}
+
+// ImportSettingsFile is ImportSettings, reading from the file at @param path.
+// If no format is given, it is detected from the file extension.
+func ImportSettingsFile(store *persistentConfigStore, path string, opts ImportOptions) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	if opts.Format == "" {
+		opts.Format, err = seedFormatOf(path)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return ImportSettings(store, f, opts)
+}
+
+// ExportSettings writes the latest version of the settings to @param w in
+// the format given by @param opts. Returns the number of settings exported.
+func ExportSettings(store *persistentConfigStore, w io.Writer, opts ExportOptions) (int, error) {
+	settings, err := store.GetSettings()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list settings")
+	}
+
+	switch opts.Format {
+	case "", SeedFormatRecords:
+		return exportRecords(settings, w)
+	case SeedFormatKVSet:
+		return exportKVSet(settings, w)
+	}
+
+	return exportDefaultProfile(settings, w, opts)
+}
+
+// exportRecords writes @param settings as a JSON array of SettingRecords
+func exportRecords(settings []ConfigSetting, w io.Writer) (int, error) {
+
+	records := make([]SettingRecord, 0, len(settings))
+	for _, setting := range settings {
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read setting %s", setting.Key)
+		}
+
+		records = append(records, SettingRecord{
+			Key:         setting.Key,
+			Label:       setting.Label,
+			Value:       latest.Value,
+			ContentType: latest.ContentType,
+			Tags:        latest.Tags,
+			Locked:      setting.Locked,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(records)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to encode settings")
+	}
+
+	return len(records), nil
+}
+
+// apply fills in the parts of @param record that the options provide
+func (opts ImportOptions) apply(record SettingRecord) SettingRecord {
+	if !strings.HasPrefix(record.Key, FeatureFlagKeyPrefix) {
+		record.Key = opts.Prefix + record.Key
+	}
+
+	if record.Label == "" {
+		record.Label = opts.Label
+	}
+	if record.ContentType == "" {
+		record.ContentType = opts.ContentType
+	}
+
+	if len(opts.Tags) > 0 {
+		tags := map[string]string{}
+		for k, v := range opts.Tags {
+			tags[k] = v
+		}
+		for k, v := range record.Tags {
+			tags[k] = v
+		}
+		record.Tags = tags
+	}
+
+	return record
+}
+
+func seedFormatOf(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case ext == ".json":
+		return SeedFormatJSON, nil
+	case ext == ".yaml" || ext == ".yml":
+		return SeedFormatYAML, nil
+	case ext == ".properties":
+		return SeedFormatProperties, nil
+	case ext == ".env" || strings.HasPrefix(filepath.Base(path), ".env"):
+		return SeedFormatDotEnv, nil
+	}
+
+	return "", fmt.Errorf("cannot tell the format of %s from its extension", path)
+}
+
+func parseSeed(data []byte, opts ImportOptions) ([]SettingRecord, error) {
+	separator := opts.Separator
+	if separator == "" {
+		separator = DefaultSeedSeparator
+	}
+
+	switch opts.Format {
+	case SeedFormatRecords:
+		var records []SettingRecord
+		err := json.Unmarshal(data, &records)
+		return records, err
+
+	case SeedFormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, isArray := doc.([]interface{}); isArray {
+			return parseSeed(data, ImportOptions{Format: SeedFormatRecords})
+		}
+		return parseObjectSeed(doc, separator, opts.ContentType)
+
+	case SeedFormatYAML:
+		var doc interface{}
+		err := yaml.Unmarshal(data, &doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, isArray := doc.([]interface{}); isArray {
+			var records []SettingRecord
+			err = yaml.Unmarshal(data, &records)
+			return records, err
+		}
+		return parseObjectSeed(normalizeYAML(doc), separator, opts.ContentType)
+
+	case SeedFormatKVSet:
+		return parseKVSet(data)
+
+	case SeedFormatProperties:
+		return parseProperties(data)
+
+	case SeedFormatDotEnv:
+		return parseDotEnv(data)
+	}
+
+	return nil, fmt.Errorf("unknown format '%s'", opts.Format)
+}
+
+func parseObjectSeed(doc interface{}, separator string, contentType string) ([]SettingRecord, error) {
+	if doc == nil {
+		return []SettingRecord{}, nil
+	}
+
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object at the top level")
+	}
+
+	return parseDefaultProfile(object, separator, contentType)
+}
+
+// normalizeYAML converts the mappings of a decoded YAML document, which may
+// have non-string keys, to the map[string]interface{} that JSON decodes to.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			v[name] = normalizeYAML(child)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for name, child := range v {
+			converted[fmt.Sprint(name)] = normalizeYAML(child)
+		}
+		return converted
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeYAML(child)
+		}
+		return v
+	}
+	return value
+}
+
+// flattenSeed turns a nested object into settings, joining the keys of
+// nested objects and the indexes of arrays with @param separator. If
+// @param jsonValues is set, every value is stored as JSON and arrays are
+// not flattened.
+func flattenSeed(doc map[string]interface{}, separator string, jsonValues bool) ([]SettingRecord, error) {
+	records := []SettingRecord{}
+	err := flattenSeedValue("", doc, separator, jsonValues, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func flattenSeedValue(key string, value interface{}, separator string, jsonValues bool, records *[]SettingRecord) error {
+	join := func(child string) string {
+		if key == "" {
+			return child
+		}
+		return key + separator + child
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			err := flattenSeedValue(join(name), v[name], separator, jsonValues, records)
+			if err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if jsonValues {
+			return appendJsonSeedValue(key, v, records)
+		}
+
+		for i, child := range v {
+			err := flattenSeedValue(join(strconv.Itoa(i)), child, separator, jsonValues, records)
+			if err != nil {
+				return err
+			}
+		}
+
+	case nil:
+		if jsonValues {
+			return appendJsonSeedValue(key, v, records)
+		}
+		*records = append(*records, SettingRecord{Key: key})
+
+	case string:
+		if jsonValues {
+			return appendJsonSeedValue(key, v, records)
+		}
+		*records = append(*records, SettingRecord{Key: key, Value: v})
+
+	default:
+		// Numbers and booleans keep their JSON representation
+		return appendJsonSeedValue(key, v, records)
+	}
+
+	return nil
+}
+
+func appendJsonSeedValue(key string, value interface{}, records *[]SettingRecord) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode value of %s", key)
+	}
+	*records = append(*records, SettingRecord{Key: key, Value: string(encoded)})
+
+	return nil
+}
+
+// parseProperties reads a Java .properties file: '#' and '!' comments,
+// '=', ':' or whitespace separators, '\' line continuations and escapes.
+func parseProperties(data []byte) ([]SettingRecord, error) {
+	records := []SettingRecord{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	logical := ""
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical == "" && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+
+		// An odd number of trailing backslashes continues the line
+		trailing := len(line) - len(strings.TrimRight(line, "\\"))
+		if trailing%2 == 1 {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, SettingRecord{Key: key, Value: value})
+		logical = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if logical != "" {
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, SettingRecord{Key: key, Value: value})
+	}
+
+	return records, nil
+}
+
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+
+	key, err := unescapeProperty(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := unescapeProperty(rest)
+	if err != nil {
+		return "", "", err
+	}
+
+	return key, value, nil
+}
+
+func unescapeProperty(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("truncated unicode escape in '%s'", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape in '%s'", s)
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), nil
+}
+
+// parseDotEnv reads a .env file: NAME=value lines, optionally preceded by
+// "export", with '#' comments and single or double quoted values.
+func parseDotEnv(data []byte) ([]SettingRecord, error) {
+	records := []SettingRecord{}
+
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(strings.TrimSuffix(lines[n], "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected NAME=value", n+1)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			quote := value[0]
+			// Quoted values may span lines
+			for !closesQuote(value, quote) && n+1 < len(lines) {
+				n++
+				value += "\n" + strings.TrimSuffix(lines[n], "\r")
+			}
+			if !closesQuote(value, quote) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", n+1)
+			}
+
+			end := strings.LastIndexByte(value, quote)
+			value = value[1:end]
+			if quote == '"' {
+				value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value)
+			}
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("line %d: value is not valid UTF-8", n+1)
+		}
+
+		records = append(records, SettingRecord{Key: name, Value: value})
+	}
+
+	return records, nil
+}
+
+func closesQuote(value string, quote byte) bool {
+	for i := 1; i < len(value); i++ {
+		if value[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if value[i] == quote {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportSettings(t *testing.T) {
+	t.Run("Seed formats", func(t *testing.T) {
+		cases := map[string]struct {
+			opts     ImportOptions
+			data     string
+			expected map[string]string
+		}{
+			"nested json": {
+				ImportOptions{Format: SeedFormatJSON},
+				`{"App": {"Name": "demo", "Port": 8080, "Debug": true, "Hosts": ["a", "b"]}}`,
+				map[string]string{"App:Name": "demo", "App:Port": "8080", "App:Debug": "true", "App:Hosts:0": "a", "App:Hosts:1": "b"},
+			},
+			"nested yaml with separator": {
+				ImportOptions{Format: SeedFormatYAML, Separator: "/"},
+				"app:\n  name: demo\n  retries: 3\n",
+				map[string]string{"app/name": "demo", "app/retries": "3"},
+			},
+			"properties": {
+				ImportOptions{Format: SeedFormatProperties},
+				"# comment\napp.name = demo\napp.greeting: hello \\\n    world\napp.path C:\\\\temp\napp.snow=\\u2603\n",
+				map[string]string{"app.name": "demo", "app.greeting": "hello world", "app.path": `C:\temp`, "app.snow": "☃"},
+			},
+			"dotenv": {
+				ImportOptions{Format: SeedFormatDotEnv},
+				"# comment\nexport APP_NAME=demo\nAPP_MOTD=\"line 1\\nline 2\"\nAPP_RAW='$not \\n expanded'\nAPP_PORT=8080 # trailing\n",
+				map[string]string{"APP_NAME": "demo", "APP_MOTD": "line 1\nline 2", "APP_RAW": `$not \n expanded`, "APP_PORT": "8080"},
+			},
+			"records": {
+				ImportOptions{Format: SeedFormatJSON},
+				`[{"key": "a", "value": "1"}]`,
+				map[string]string{"a": "1"},
+			},
+		}
+
+		for name, tc := range cases {
+			store, _, closer, err := makeTestStore(t)
+			require.NoError(t, err, name)
+
+			count, err := ImportSettings(store, strings.NewReader(tc.data), tc.opts)
+			require.NoError(t, err, name)
+			require.Equal(t, len(tc.expected), count, name)
+
+			for key, value := range tc.expected {
+				version, err := store.GetSettingLatestVersion(key)
+				require.NoError(t, err, name+": "+key)
+				require.Equal(t, value, version.Value, name+": "+key)
+			}
+
+			closer()
+		}
+	})
+
+	t.Run("Label, prefix, content type and tags are applied", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		_, err := ImportSettings(store, strings.NewReader(`{"Name": "demo"}`), ImportOptions{
+			Format:      SeedFormatJSON,
+			Prefix:      "App:",
+			Label:       "dev",
+			ContentType: "text/plain",
+			Tags:        map[string]string{"source": "seed"},
+		})
+		require.NoError(t, err)
+
+		// Importing again adds a version rather than a second setting
+		_, err = ImportSettings(store, strings.NewReader(`{"Name": "demo2"}`), ImportOptions{
+			Format: SeedFormatJSON,
+			Prefix: "App:",
+			Label:  "dev",
+		})
+		require.NoError(t, err)
+
+		setting, err := store.GetLabeledConfigSetting("App:Name", "dev")
+		require.NoError(t, err)
+		require.Len(t, setting.Versions, 2)
+		require.Equal(t, "text/plain", setting.Versions[1].ContentType)
+		require.Equal(t, map[string]string{"source": "seed"}, setting.Versions[1].Tags)
+
+		_, err = store.GetConfigSetting("App:Name")
+		require.ErrorIs(t, err, ErrSettingNotFound)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/kv/App:Name?label=dev&api-version=2023-10-01", nil)
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var kv map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &kv))
+		require.Equal(t, "demo2", kv["value"])
+		require.Equal(t, "dev", kv["label"])
+	})
+
+	t.Run("Importing locked settings again changes nothing", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		seed := `[{"key": "a", "value": "1", "locked": true}, {"key": "b", "value": "2"}]`
+		for i := 0; i < 2; i++ {
+			count, err := ImportSettings(store, strings.NewReader(seed), ImportOptions{Format: SeedFormatRecords})
+			require.NoError(t, err)
+			require.Equal(t, 2, count)
+		}
+
+		setting, err := store.GetLabeledConfigSetting("a", "")
+		require.NoError(t, err)
+		require.True(t, setting.Locked)
+		require.Len(t, setting.Versions, 1)
+
+		// A changed value is imported, and the setting locked again
+		count, err := ImportSettings(store, strings.NewReader(`[{"key": "a", "value": "3", "locked": true}]`),
+			ImportOptions{Format: SeedFormatRecords})
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		setting, err = store.GetLabeledConfigSetting("a", "")
+		require.NoError(t, err)
+		require.True(t, setting.Locked)
+		latest, err := setting.GetLatest()
+		require.NoError(t, err)
+		require.Equal(t, "3", latest.Value)
+	})
+
+	t.Run("Export round trips through import", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = ImportSettings(store, strings.NewReader(`{"a": "1", "b": "2"}`), ImportOptions{
+			Format: SeedFormatJSON,
+			Label:  "prod",
+		})
+		require.NoError(t, err)
+
+		var exported bytes.Buffer
+		count, err := ExportSettings(store, &exported, ExportOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		require.NoError(t, store.Reset())
+
+		count, err = ImportSettings(store, &exported, ImportOptions{Format: SeedFormatRecords})
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		version, err := store.GetLabeledConfigSetting("b", "prod")
+		require.NoError(t, err)
+		require.Equal(t, "2", version.Versions[0].Value)
+	})
+}
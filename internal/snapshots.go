@@ -0,0 +1,199 @@
+package emulator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ostafen/clover"
+	"github.com/pkg/errors"
+)
+
+// ErrSnapshotExists is returned (wrapped) when creating a snapshot whose name is taken
+var ErrSnapshotExists = errors.New("snapshot already exists")
+
+// CreateSnapshot records the latest version of every setting matching any
+// of @param filters as the snapshot @param name.
+func (pcs *persistentConfigStore) CreateSnapshot(name string, filters []SnapshotFilter) (ConfigurationSnapshot, error) {
+	if name == "" {
+		return ConfigurationSnapshot{}, fmt.Errorf("a snapshot name is required")
+	}
+	if len(filters) == 0 {
+		return ConfigurationSnapshot{}, fmt.Errorf("at least one filter is required")
+	}
+
+	type compiledFilter struct {
+		key   Filter
+		label Filter
+	}
+
+	compiled := make([]compiledFilter, 0, len(filters))
+	for _, f := range filters {
+		key, err := newFilter(f.Key)
+		if err != nil {
+			return ConfigurationSnapshot{}, errors.Wrapf(err, "invalid key filter '%s'", f.Key)
+		}
+
+		label := f.Label
+		if label == "" {
+			// A snapshot filter without a label selects the null label
+			label = nullLabelFilter
+		}
+		labelFilter, err := newLabelFilter(&label)
+		if err != nil {
+			return ConfigurationSnapshot{}, errors.Wrapf(err, "invalid label filter '%s'", f.Label)
+		}
+
+		compiled = append(compiled, compiledFilter{key, labelFilter})
+	}
+
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	doc, err := pcs.getSnapshotQuery(name).FindFirst()
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to find snapshot %s", name)
+	}
+	if doc != nil {
+		return ConfigurationSnapshot{}, errors.Wrap(ErrSnapshotExists, name)
+	}
+
+	settings, err := pcs.getSettings()
+	if err != nil {
+		return ConfigurationSnapshot{}, err
+	}
+
+	snapshot := ConfigurationSnapshot{
+		Name:     name,
+		Status:   SnapshotStatusReady,
+		Filters:  filters,
+		Created:  time.Now(),
+		Settings: []ConfigSetting{},
+	}
+
+	for _, setting := range settings {
+		matches := slices.ContainsFunc(compiled, func(f compiledFilter) bool {
+			return f.key.Apply(setting.Key) && f.label.Apply(setting.Label)
+		})
+		if !matches {
+			continue
+		}
+
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return ConfigurationSnapshot{}, err
+		}
+
+		setting.Versions = []ConfigSettingVersion{latest}
+		snapshot.Settings = append(snapshot.Settings, setting)
+	}
+
+	_, err = pcs.cdb.InsertOne(SNAPSHOT_COLECTION_NAME, clover.NewDocumentOf(snapshot))
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to store snapshot %s", name)
+	}
+
+	return snapshot, nil
+}
+
+// GetSnapshot returns the snapshot @param name
+func (pcs *persistentConfigStore) GetSnapshot(name string) (ConfigurationSnapshot, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.getSnapshot(name)
+}
+
+// GetSnapshots returns every snapshot, ordered by name
+func (pcs *persistentConfigStore) GetSnapshots() ([]ConfigurationSnapshot, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	stop := pcs.timeStore("scan")
+	docs, err := pcs.cdb.Query(SNAPSHOT_COLECTION_NAME).FindAll()
+	stop()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list snapshots")
+	}
+
+	snapshots := make([]ConfigurationSnapshot, 0, len(docs))
+	for _, doc := range docs {
+		var snapshot ConfigurationSnapshot
+		err = doc.Unmarshal(&snapshot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal snapshot document %s", doc.ObjectId())
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	slices.SortFunc(snapshots, func(a, b ConfigurationSnapshot) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return snapshots, nil
+}
+
+// ArchiveSnapshot sets the status of snapshot @param name to archived
+func (pcs *persistentConfigStore) ArchiveSnapshot(name string) (ConfigurationSnapshot, error) {
+	return pcs.setSnapshotStatus(name, SnapshotStatusArchived)
+}
+
+// RecoverSnapshot sets the status of an archived snapshot @param name back to ready
+func (pcs *persistentConfigStore) RecoverSnapshot(name string) (ConfigurationSnapshot, error) {
+	return pcs.setSnapshotStatus(name, SnapshotStatusReady)
+}
+
+func (pcs *persistentConfigStore) setSnapshotStatus(name string, status string) (ConfigurationSnapshot, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	snapshot, err := pcs.getSnapshot(name)
+	if err != nil {
+		return ConfigurationSnapshot{}, err
+	}
+
+	snapshot.Status = status
+	if status == SnapshotStatusArchived {
+		snapshot.Archived = time.Now()
+	} else {
+		snapshot.Archived = time.Time{}
+	}
+
+	query := pcs.getSnapshotQuery(name)
+	err = query.Delete()
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to replace snapshot %s", name)
+	}
+
+	_, err = pcs.cdb.InsertOne(SNAPSHOT_COLECTION_NAME, clover.NewDocumentOf(snapshot))
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to store snapshot %s", name)
+	}
+
+	return snapshot, nil
+}
+
+func (pcs *persistentConfigStore) getSnapshotQuery(name string) *clover.Query {
+	return pcs.cdb.Query(SNAPSHOT_COLECTION_NAME).Where(clover.Field("Name").Eq(name))
+}
+
+// getSnapshot does the work of GetSnapshot.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) getSnapshot(name string) (ConfigurationSnapshot, error) {
+	doc, err := pcs.getSnapshotQuery(name).FindFirst()
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to find snapshot %s", name)
+	}
+	if doc == nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(ErrSettingNotFound, "snapshot %s", name)
+	}
+
+	var snapshot ConfigurationSnapshot
+	err = doc.Unmarshal(&snapshot)
+	if err != nil {
+		return ConfigurationSnapshot{}, errors.Wrapf(err, "failed to unmarshal snapshot %s", name)
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,236 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshots(t *testing.T) {
+	t.Run("Create, archive and recover", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		_, err = store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("App:Name", "demo2")
+		require.NoError(t, err)
+		_, err = store.UpdateLabeledSetting("App:Name", "prod", "demo-prod", SettingAttributes{})
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "excluded")
+		require.NoError(t, err)
+
+		snapshot, err := store.CreateSnapshot("release-1", []SnapshotFilter{{Key: "App:*"}})
+		require.NoError(t, err)
+		require.Equal(t, SnapshotStatusReady, snapshot.Status)
+		require.Len(t, snapshot.Settings, 1)
+		require.Equal(t, "demo2", snapshot.Settings[0].Versions[0].Value)
+
+		_, err = store.CreateSnapshot("release-1", []SnapshotFilter{{Key: "*"}})
+		require.ErrorIs(t, err, ErrSnapshotExists)
+
+		// Later changes do not affect the snapshot
+		_, err = store.UpdateSetting("App:Name", "demo3")
+		require.NoError(t, err)
+
+		snapshot, err = store.GetSnapshot("release-1")
+		require.NoError(t, err)
+		require.Equal(t, "demo2", snapshot.Settings[0].Versions[0].Value)
+
+		snapshot, err = store.ArchiveSnapshot("release-1")
+		require.NoError(t, err)
+		require.Equal(t, SnapshotStatusArchived, snapshot.Status)
+		require.False(t, snapshot.Archived.IsZero())
+
+		snapshot, err = store.RecoverSnapshot("release-1")
+		require.NoError(t, err)
+		require.Equal(t, SnapshotStatusReady, snapshot.Status)
+
+		snapshots, err := store.GetSnapshots()
+		require.NoError(t, err)
+		require.Len(t, snapshots, 1)
+
+		_, err = store.GetSnapshot("missing")
+		require.ErrorIs(t, err, ErrSettingNotFound)
+	})
+
+	t.Run("REST API", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		do := func(method string, path string, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			rq := httptest.NewRequest(method, path+"api-version=2023-10-01", strings.NewReader(body))
+			rq.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(rec, rq)
+			return rec
+		}
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "excluded")
+		require.NoError(t, err)
+
+		rec := do(http.MethodPut, "/snapshots/release?", `{"filters": [{"key": "App:*"}]}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		require.NotEmpty(t, rec.Header().Get("ETag"))
+		operation, err := url.Parse(rec.Header().Get("Operation-Location"))
+		require.NoError(t, err)
+		require.Equal(t, "/operations", operation.Path)
+
+		rec = httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, operation.RequestURI(), nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.JSONEq(t, `{"id": "release", "status": "Succeeded"}`, rec.Body.String())
+
+		rec = do(http.MethodPut, "/snapshots/release?", `{"filters": [{"key": "*"}]}`)
+		require.Equal(t, http.StatusConflict, rec.Code)
+		rec = do(http.MethodPut, "/snapshots/empty?", `{"filters": []}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		_, err = store.UpdateSetting("App:Name", "changed")
+		require.NoError(t, err)
+
+		rec = do(http.MethodGet, "/kv?snapshot=release&", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var list struct {
+			Items []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		require.Len(t, list.Items, 1)
+		require.Equal(t, "demo", list.Items[0].Value)
+
+		rec = do(http.MethodGet, "/kv?snapshot=release&key=App:*&", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = do(http.MethodGet, "/kv?snapshot=missing&", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(http.MethodPatch, "/snapshots/release?", `{"status": "archived"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = do(http.MethodGet, "/snapshots/release?", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var snapshot struct {
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			ItemsCount int    `json:"items_count"`
+			Expires    string `json:"expires"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snapshot))
+		require.Equal(t, "release", snapshot.Name)
+		require.Equal(t, SnapshotStatusArchived, snapshot.Status)
+		require.Equal(t, 1, snapshot.ItemsCount)
+		require.NotEmpty(t, snapshot.Expires)
+
+		rec = do(http.MethodGet, "/snapshots?status=ready&", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.JSONEq(t, `{"items": []}`, rec.Body.String())
+		rec = do(http.MethodGet, "/snapshots?status=archived&", "")
+		require.Contains(t, rec.Body.String(), `"name":"release"`)
+
+		rec = do(http.MethodGet, "/snapshots/missing?", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestAdminUI(t *testing.T) {
+	engine, store, closer := makeTestRestServer(t)
+	defer closer()
+
+	do := func(method string, path string, params url.Values, body string) *httptest.ResponseRecorder {
+		if params != nil {
+			path += "?" + params.Encode()
+		}
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(method, AdminBasePath+path, strings.NewReader(body))
+		rq.Header.Set("Content-Type", "application/json")
+		engine.ServeHTTP(rec, rq)
+		return rec
+	}
+
+	t.Run("Serves the UI", func(t *testing.T) {
+		rec := do(http.MethodGet, "", nil, "")
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Equal(t, AdminBasePath+"/ui/", rec.Header().Get("Location"))
+
+		rec = do(http.MethodGet, "/ui/", nil, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "app.js")
+	})
+
+	t.Run("Edits, locks and lists settings", func(t *testing.T) {
+		id := url.Values{"key": {"App/Settings"}, "label": {"prod"}}
+
+		rec := do(http.MethodPut, "/settings", id, `{"value": "{\"a\": 1}", "content_type": "application/json"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		rec = do(http.MethodPut, "/settings", id, `{"value": "{\"a\": 2}", "content_type": "application/json"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = do(http.MethodPut, "/settings", id, `{"value": "{oops", "content_type": "application/json"}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = do(http.MethodPut, "/settings", url.Values{"key": {FeatureFlagKeyPrefix + "Beta"}},
+			`{"value": "{}", "content_type": "`+FeatureFlagContentType+`"}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = do(http.MethodGet, "/settings/history", id, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var history struct {
+			Items []adminSettingVersion `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+		require.Len(t, history.Items, 2)
+		require.Equal(t, `{"a": 2}`, history.Items[0].Value)
+
+		rec = do(http.MethodPost, "/settings/lock", id, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		setting, err := store.GetLabeledConfigSetting("App/Settings", "prod")
+		require.NoError(t, err)
+		require.True(t, setting.Locked)
+
+		rec = do(http.MethodPost, "/settings/lock", url.Values{"key": {"missing"}}, "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(http.MethodGet, "/settings", url.Values{"label": {"prod"}}, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var list struct {
+			Items []adminSetting `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		require.Len(t, list.Items, 1)
+		require.True(t, list.Items[0].Locked)
+		require.Equal(t, 2, list.Items[0].Versions)
+
+		rec = do(http.MethodGet, "/labels", nil, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"items": ["prod"]}`, rec.Body.String())
+	})
+
+	t.Run("Creates and archives snapshots", func(t *testing.T) {
+		rec := do(http.MethodPost, "/snapshots", nil, `{"name": "s1", "filters": [{"key": "App/*", "label": "prod"}]}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = do(http.MethodPost, "/snapshots", nil, `{"name": "s1", "filters": [{"key": "*"}]}`)
+		require.Equal(t, http.StatusConflict, rec.Code)
+
+		rec = do(http.MethodPost, "/snapshots/s1/archive", nil, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		snapshot, err := store.GetSnapshot("s1")
+		require.NoError(t, err)
+		require.Equal(t, SnapshotStatusArchived, snapshot.Status)
+		require.Len(t, snapshot.Settings, 1)
+
+		rec = do(http.MethodPost, "/snapshots/missing/recover", nil, "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -5,7 +5,9 @@ import (
 	//"github.com/golang-jwt/jwt/v4"
 
 	"fmt"
+	"log/slog"
 	"slices"
+	"strings"
 	"sync"
 
 	"github.com/ostafen/clover"
@@ -20,11 +22,29 @@ import (
 const (
 	SETTING_COLECTION_NAME  = "settings"
 	SNAPSHOT_COLECTION_NAME = "snapshots"
+	SECRET_COLLECTION_NAME  = "secrets"
 )
 
+// ErrSettingNotFound is returned (wrapped) when a setting does not exist
+var ErrSettingNotFound = errors.New("setting not found")
+
+// ErrSettingLocked is returned (wrapped) when a locked setting would be modified
+var ErrSettingLocked = errors.New("setting is locked")
+
 type persistentConfigStore struct {
 	sync.Mutex
 	cdb *clover.DB
+
+	// sequence numbers changes to settings, see changes.go
+	sequence       int64
+	listeners      []changeListener
+	nextListenerId int64
+
+	// actor is who the change being made is attributed to, see audit.go.
+	// It is only set while the Mutex is held.
+	actor Actor
+
+	metrics *storeMetrics
 }
 
 func NewPersistentConfigStore(
@@ -37,9 +57,20 @@ func NewPersistentConfigStore(
 
 	cdb.CreateCollection(SETTING_COLECTION_NAME)
 	cdb.CreateCollection(SNAPSHOT_COLECTION_NAME)
+	cdb.CreateCollection(SECRET_COLLECTION_NAME)
+	cdb.CreateCollection(AUDIT_COLLECTION_NAME)
+	cdb.CreateCollection(SYNC_COLLECTION_NAME)
+
+	sequence, err := lastAuditSequence(cdb)
+	if err != nil {
+		closer()
+		return nil, func() {}, err
+	}
 
 	pcs := persistentConfigStore{
-		cdb: cdb,
+		cdb:      cdb,
+		sequence: sequence,
+		metrics:  newStoreMetrics(),
 	}
 
 	return &pcs, closer, nil
@@ -74,35 +105,67 @@ func NewPersistentConfigStore(
 // UpdateValue creates a new version of the setting defined by @param key
 // If no setting exists of that key, it will be created.
 func (pcs *persistentConfigStore) UpdateSetting(key string, value string) (ConfigSetting, error) {
+	return pcs.UpdateSettingWithAttributes(key, value, SettingAttributes{})
+}
+
+// UpdateSettingWithAttributes is UpdateSetting, additionally setting the
+// content type and tags of the new version.
+func (pcs *persistentConfigStore) UpdateSettingWithAttributes(key string, value string, attrs SettingAttributes) (ConfigSetting, error) {
+	return pcs.UpdateLabeledSetting(key, NullLabel, value, attrs)
+}
+
+// UpdateLabeledSetting is UpdateSettingWithAttributes for the setting
+// identified by @param key and @param label.
+func (pcs *persistentConfigStore) UpdateLabeledSetting(key string, label string, value string, attrs SettingAttributes) (ConfigSetting, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	if !pcs.settingExists(key) {
+	return pcs.updateLabeledSetting(key, label, value, attrs)
+}
+
+// updateSetting does the work of UpdateSettingWithAttributes, for the null label.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) updateSetting(key string, value string, attrs SettingAttributes) (ConfigSetting, error) {
+	return pcs.updateLabeledSetting(key, NullLabel, value, attrs)
+}
+
+// updateLabeledSetting does the work of UpdateLabeledSetting.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) updateLabeledSetting(key string, label string, value string, attrs SettingAttributes) (ConfigSetting, error) {
+	err := ValidateKeyValue(key, label, value, attrs)
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+
+	if !pcs.settingExists(key, label) {
 		// Setting does not exist, create it and exit
-		fmt.Printf("Setting does not exist: %s\n", key)
-		setting, err := pcs.createSetting(key, value)
+		setting, err := pcs.createSetting(key, label, value, attrs)
 		if err != nil {
 			return ConfigSetting{}, errors.Wrapf(err, "failed to create setting %s", key)
 		}
+		pcs.notifyChange(ChangeCreate, setting, "")
 		return setting, nil
 	}
 
-	unlocked, err := pcs.settingUnlocked(key)
+	unlocked, err := pcs.settingUnlocked(key, label)
 	if err != nil {
 		return ConfigSetting{}, fmt.Errorf("error getting setting locked state")
 	}
 	if !unlocked {
-		return ConfigSetting{}, fmt.Errorf("setting is locked")
+		return ConfigSetting{}, errors.Wrap(ErrSettingLocked, key)
 	}
 
 	// Setting exists, update the stored document.
-	setting, err := pcs.updateSettingFunc(key, func(s *ConfigSetting) {
-		s.NewVersion(value)
+	var previousEtag string
+	setting, err := pcs.updateSettingFunc(key, label, func(s *ConfigSetting) {
+		previousEtag = s.Versions[0].Uuid
+		s.NewVersion(value, attrs)
 	})
 	if err != nil {
 		// TODO: wrap err
 		return ConfigSetting{}, err
 	}
+	pcs.notifyChange(ChangeUpdate, setting, previousEtag)
 
 	return setting, nil
 }
@@ -111,23 +174,37 @@ func (pcs *persistentConfigStore) CreateSetting(key string, value string) (Confi
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	return pcs.createSetting(key, value)
+	err := ValidateKeyValue(key, NullLabel, value, SettingAttributes{})
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+
+	setting, err := pcs.createSetting(key, NullLabel, value, SettingAttributes{})
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+	pcs.notifyChange(ChangeCreate, setting, "")
+
+	return setting, nil
 }
 
 // createSetting does the work of creating a new setting.
 // This non-exported function DOES NOT manage the Mutex.
 // Do not call directly outside of this type.
-func (pcs *persistentConfigStore) createSetting(key string, value string) (ConfigSetting, error) {
-	fmt.Printf("Creating setting: %s\n", key)
+func (pcs *persistentConfigStore) createSetting(key string, label string, value string, attrs SettingAttributes) (ConfigSetting, error) {
+	slog.Debug("creating setting", "key", key, "label", label)
 
 	// Make sure a Setting
-	setting := NewConfigSettingNow(key, value)
+	setting := NewConfigSettingNow(key, value, attrs)
+	setting.Label = label
 	settingDoc := clover.NewDocumentOf(setting)
 	if settingDoc == nil {
 		return ConfigSetting{}, fmt.Errorf("failed to convert setting object to storage document for: %s, %s", key, value)
 	}
 
+	stop := pcs.timeStore("write")
 	_, err := pcs.cdb.InsertOne(SETTING_COLECTION_NAME, settingDoc)
+	stop()
 	if err != nil {
 		return ConfigSetting{}, errors.Wrapf(
 			err,
@@ -139,14 +216,14 @@ func (pcs *persistentConfigStore) createSetting(key string, value string) (Confi
 	return *setting, nil
 }
 
-func (pcs *persistentConfigStore) settingExists(key string) bool {
-	settingDoc, err := pcs.getSettingDoc(key)
+func (pcs *persistentConfigStore) settingExists(key string, label string) bool {
+	settingDoc, err := pcs.getSettingDoc(key, label)
 	exists := err == nil && settingDoc != nil
 	return exists
 }
 
-func (pcs *persistentConfigStore) settingUnlocked(key string) (bool, error) {
-	setting, err := pcs.getSetting(key)
+func (pcs *persistentConfigStore) settingUnlocked(key string, label string) (bool, error) {
+	setting, err := pcs.getSetting(key, label)
 	if err != nil {
 		// TODO: wrap err
 		return false, err
@@ -160,115 +237,249 @@ func (pcs *persistentConfigStore) GetSetting(key string) (string, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	version, err := pcs.getSettingLatestVersion(key)
+	version, err := pcs.getSettingLatestVersion(key, NullLabel)
 	if err != nil {
 		// TODO: wrap err
 		return "", err
 	}
 
-	fmt.Printf("GetSetting(%s) = %s", key, version.Value)
-
 	return version.Value, nil
 }
 
+// GetConfigSetting returns the whole ConfigSetting, including all versions
+func (pcs *persistentConfigStore) GetConfigSetting(key string) (ConfigSetting, error) {
+	return pcs.GetLabeledConfigSetting(key, NullLabel)
+}
+
+// GetLabeledConfigSetting is GetConfigSetting for the setting identified by
+// @param key and @param label.
+func (pcs *persistentConfigStore) GetLabeledConfigSetting(key string, label string) (ConfigSetting, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+	return pcs.getSetting(key, label)
+}
+
 // GetSettingLatestVersion returns the ConfigSettingVersion struct of the latest version of the setting
 func (pcs *persistentConfigStore) GetSettingLatestVersion(key string) (ConfigSettingVersion, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
-	return pcs.getSettingLatestVersion(key)
+	return pcs.getSettingLatestVersion(key, NullLabel)
 }
 
 // getSettingLatestVersion returns the ConfigSettingVersion struct of the latest version of the setting
-func (pcs *persistentConfigStore) getSettingLatestVersion(key string) (ConfigSettingVersion, error) {
-	setting, err := pcs.getSetting(key)
+func (pcs *persistentConfigStore) getSettingLatestVersion(key string, label string) (ConfigSettingVersion, error) {
+	setting, err := pcs.getSetting(key, label)
 	if err != nil {
 		// TODO: wrap err
 		return ConfigSettingVersion{}, err
 	}
 
+	// Newest first
 	slices.SortFunc(setting.Versions, func(a, b ConfigSettingVersion) int {
-		if a.Timestamp.Before(b.Timestamp) {
-			return -1
-		} else {
-			return 1
-		}
+		return b.Timestamp.Compare(a.Timestamp)
 	})
 
-	return setting.Versions[0], nil
+	return setting.GetLatest()
 }
 
 func (pcs *persistentConfigStore) DeleteSetting(key string) error {
+	return pcs.DeleteLabeledSetting(key, NullLabel)
+}
+
+// DeleteLabeledSetting is DeleteSetting for the setting identified by
+// @param key and @param label.
+func (pcs *persistentConfigStore) DeleteLabeledSetting(key string, label string) error {
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	settingDoc, err := pcs.getSettingDoc(key)
+	return pcs.deleteLabeledSetting(key, label)
+}
+
+// deleteLabeledSetting does the work of DeleteLabeledSetting.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) deleteLabeledSetting(key string, label string) error {
+	settingDoc, err := pcs.getSettingDoc(key, label)
 	if err != nil {
 		// TODO: wrap err
 		return err
 	}
+	if settingDoc == nil {
+		return errors.Wrap(ErrSettingNotFound, key)
+	}
+
+	var setting ConfigSetting
+	err = settingDoc.Unmarshal(&setting)
+	if err != nil {
+		return errors.Wrapf(err, "failed to unmarshal storage document for: %s", key)
+	}
+	if setting.Locked {
+		return errors.Wrap(ErrSettingLocked, key)
+	}
 
-	pcs.getSettingQuery(key).DeleteById(settingDoc.ObjectId())
+	stop := pcs.timeStore("delete")
+	err = pcs.getSettingQuery(key, label).DeleteById(settingDoc.ObjectId())
+	stop()
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete setting: %s", key)
+	}
+	latest, err := setting.GetLatest()
+	if err != nil {
+		return err
+	}
+	pcs.notifyChange(ChangeDelete, setting, latest.Uuid)
 
 	return nil
 }
 
 func (pcs *persistentConfigStore) LockSetting(key string) (ConfigSetting, error) {
+	return pcs.LockLabeledSetting(key, NullLabel)
+}
+
+// LockLabeledSetting is LockSetting for the setting identified by
+// @param key and @param label.
+func (pcs *persistentConfigStore) LockLabeledSetting(key string, label string) (ConfigSetting, error) {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.setSettingLocked(key, label, true)
+}
+
+func (pcs *persistentConfigStore) UnlockSetting(key string) (ConfigSetting, error) {
+	return pcs.UnlockLabeledSetting(key, NullLabel)
+}
+
+// UnlockLabeledSetting is UnlockSetting for the setting identified by
+// @param key and @param label.
+func (pcs *persistentConfigStore) UnlockLabeledSetting(key string, label string) (ConfigSetting, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
-	if !pcs.settingExists(key) {
-		return ConfigSetting{}, fmt.Errorf("setting %s does not exist", key)
+
+	return pcs.setSettingLocked(key, label, false)
+}
+
+// setSettingLocked does the work of LockLabeledSetting and UnlockLabeledSetting.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) setSettingLocked(key string, label string, locked bool) (ConfigSetting, error) {
+	if !pcs.settingExists(key, label) {
+		return ConfigSetting{}, errors.Wrap(ErrSettingNotFound, key)
 	}
 
-	setting, err := pcs.updateSettingFunc(key, func(s *ConfigSetting) {
-		s.Locked = true
+	operation, verb := ChangeLock, "lock"
+	if !locked {
+		operation, verb = ChangeUnlock, "unlock"
+	}
+
+	setting, err := pcs.updateSettingFunc(key, label, func(s *ConfigSetting) {
+		s.Locked = locked
 	})
 	if err != nil {
-		return ConfigSetting{}, errors.Wrapf(err, "failed to lock setting: %s", key)
+		return ConfigSetting{}, errors.Wrapf(err, "failed to %s setting: %s", verb, key)
 	}
 
+	latest, err := setting.GetLatest()
+	if err != nil {
+		return ConfigSetting{}, err
+	}
+	pcs.notifyChange(operation, setting, latest.Uuid)
+
 	return setting, nil
 }
 
-func (pcs *persistentConfigStore) UnlockSetting(key string) (ConfigSetting, error) {
+func (pcs *persistentConfigStore) GetKeys() ([]string, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	if !pcs.settingExists(key) {
-		return ConfigSetting{}, fmt.Errorf("setting %s does not exist", key)
-	}
+	return pcs.getKeys()
+}
 
-	setting, err := pcs.updateSettingFunc(key, func(s *ConfigSetting) {
-		s.Locked = false
-	})
+// getKeys returns the sorted, distinct keys of all settings, whatever their label.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) getKeys() ([]string, error) {
+	stop := pcs.timeStore("scan")
+	docs, err := pcs.cdb.Query(SETTING_COLECTION_NAME).FindAll()
+	stop()
 	if err != nil {
-		return ConfigSetting{}, errors.Wrapf(err, "failed to unlock setting: %s", key)
+		return nil, errors.Wrap(err, "failed to list setting documents")
 	}
 
-	return setting, nil
+	keys := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		key, ok := doc.Get("Key").(string)
+		if !ok {
+			return nil, fmt.Errorf("setting document %s has no key", doc.ObjectId())
+		}
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return slices.Compact(keys), nil
 }
 
-func (pcs *persistentConfigStore) GetKeys() ([]string, error) {
+// GetSettings returns every setting, ordered by key and then label
+func (pcs *persistentConfigStore) GetSettings() ([]ConfigSetting, error) {
 	pcs.Lock()
 	defer pcs.Unlock()
 
-	// TODO: better error handling
-	return pcs.cdb.ListCollections()
+	return pcs.getSettings()
 }
 
-func (pcs *persistentConfigStore) getSettingQuery(key string) *clover.Query {
-	return pcs.cdb.Query(SETTING_COLECTION_NAME).Where(clover.Field("Key").Eq(key))
+// getSettings does the work of GetSettings.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) getSettings() ([]ConfigSetting, error) {
+	stop := pcs.timeStore("scan")
+	docs, err := pcs.cdb.Query(SETTING_COLECTION_NAME).FindAll()
+	stop()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list setting documents")
+	}
+
+	settings := make([]ConfigSetting, 0, len(docs))
+	for _, doc := range docs {
+		var setting ConfigSetting
+		err = doc.Unmarshal(&setting)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal setting document %s", doc.ObjectId())
+		}
+		settings = append(settings, setting)
+	}
+
+	slices.SortFunc(settings, func(a, b ConfigSetting) int {
+		if c := strings.Compare(a.Key, b.Key); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Label, b.Label)
+	})
+
+	return settings, nil
 }
 
-func (pcs *persistentConfigStore) getSettingDoc(key string) (*clover.Document, error) {
-	return pcs.getSettingQuery(key).FindFirst()
+func (pcs *persistentConfigStore) getSettingQuery(key string, label string) *clover.Query {
+	criteria := clover.Field("Key").Eq(key)
+	if label == NullLabel {
+		// Documents written before labels were stored have no Label field
+		criteria = criteria.And(clover.Field("Label").Eq(NullLabel).Or(clover.Field("Label").NotExists()))
+	} else {
+		criteria = criteria.And(clover.Field("Label").Eq(label))
+	}
+
+	return pcs.cdb.Query(SETTING_COLECTION_NAME).Where(criteria)
+}
+
+func (pcs *persistentConfigStore) getSettingDoc(key string, label string) (*clover.Document, error) {
+	defer pcs.timeStore("read")()
+	return pcs.getSettingQuery(key, label).FindFirst()
 }
 
-func (pcs *persistentConfigStore) getSetting(key string) (ConfigSetting, error) {
-	settingDoc, err := pcs.getSettingDoc(key)
+func (pcs *persistentConfigStore) getSetting(key string, label string) (ConfigSetting, error) {
+	settingDoc, err := pcs.getSettingDoc(key, label)
 	if err != nil {
 		// TODO: wrap err
 		return ConfigSetting{}, err
 	}
+	if settingDoc == nil {
+		return ConfigSetting{}, errors.Wrap(ErrSettingNotFound, key)
+	}
 
 	var setting ConfigSetting
 	err = settingDoc.Unmarshal(&setting)
@@ -280,8 +491,8 @@ func (pcs *persistentConfigStore) getSetting(key string) (ConfigSetting, error)
 	return setting, nil
 }
 
-func (pcs *persistentConfigStore) updateSettingFunc(key string, updateFunc func(*ConfigSetting)) (ConfigSetting, error) {
-	oldSettingDoc, err := pcs.getSettingDoc(key)
+func (pcs *persistentConfigStore) updateSettingFunc(key string, label string, updateFunc func(*ConfigSetting)) (ConfigSetting, error) {
+	oldSettingDoc, err := pcs.getSettingDoc(key, label)
 	if err != nil || oldSettingDoc == nil {
 		return ConfigSetting{}, fmt.Errorf("failed to retrieve storage document for: %s", key)
 	}
@@ -297,6 +508,7 @@ func (pcs *persistentConfigStore) updateSettingFunc(key string, updateFunc func(
 	updateFunc(&setting)
 
 	// Replace the old stored document with a new one
+	defer pcs.timeStore("write")()
 	err = pcs.cdb.Query(SETTING_COLECTION_NAME).DeleteById(oldSettingDoc.ObjectId())
 	if err != nil {
 		return ConfigSetting{}, errors.Wrapf(
@@ -315,6 +527,40 @@ func (pcs *persistentConfigStore) updateSettingFunc(key string, updateFunc func(
 	return setting, nil
 }
 
+// Reset deletes everything in the store: settings, snapshots and secrets
+func (pcs *persistentConfigStore) Reset() error {
+	pcs.Lock()
+	defer pcs.Unlock()
+
+	return pcs.reset()
+}
+
+// reset does the work of Reset.
+// This non-exported function DOES NOT manage the Mutex.
+func (pcs *persistentConfigStore) reset() error {
+	settings, err := pcs.getSettings()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{SETTING_COLECTION_NAME, SNAPSHOT_COLECTION_NAME, SECRET_COLLECTION_NAME, SYNC_COLLECTION_NAME} {
+		err := pcs.cdb.Query(name).Delete()
+		if err != nil {
+			return errors.Wrapf(err, "failed to empty collection %s", name)
+		}
+	}
+
+	for _, setting := range settings {
+		latest, err := setting.GetLatest()
+		if err != nil {
+			return err
+		}
+		pcs.notifyChange(ChangeDelete, setting, latest.Uuid)
+	}
+
+	return nil
+}
+
 // CLOVER FACTORY FUNCTIONS FOR TEST COMPOSABILITY
 
 func openCloverDbAt(dbpath string) (*clover.DB, func(), error) {
@@ -341,3 +587,16 @@ func MakeCloverFactory(dbpath string) func() (*clover.DB, func(), error) {
 		return openCloverDbAt(dbpath)
 	}
 }
+
+// MakeInMemoryCloverFactory returns a factory for a Clover DB that is never
+// written to disk; its contents are lost when it is closed.
+func MakeInMemoryCloverFactory() func() (*clover.DB, func(), error) {
+	return func() (*clover.DB, func(), error) {
+		cdb, err := clover.Open("", clover.InMemoryMode(true))
+		if err != nil {
+			return nil, func() {}, errors.Wrap(err, "failed to create in-memory store")
+		}
+
+		return cdb, func() { cdb.Close() }, nil
+	}
+}
@@ -67,7 +67,7 @@ func TestCreateSettings(t *testing.T) {
 
 		testKey := "testsetting1"
 		testValue := "testvalue_1_1"
-		err = store.CreateSetting(testKey, testValue)
+		_, err = store.CreateSetting(testKey, testValue)
 		require.NoError(t, err)
 
 		// Make sure the setting document exists in the clover DB
@@ -91,12 +91,12 @@ func TestCreateSettings(t *testing.T) {
 
 		testKey1 := "testsetting1"
 		testValue1 := "testvalue_1_1"
-		err = store.CreateSetting(testKey1, testValue1)
+		_, err = store.CreateSetting(testKey1, testValue1)
 		require.NoError(t, err)
 
 		testKey2 := "testsetting2"
 		testValue2 := "testvalue_2_1"
-		err = store.CreateSetting(testKey2, testValue2)
+		_, err = store.CreateSetting(testKey2, testValue2)
 		require.NoError(t, err)
 
 		// Make sure the setting documents exist in the clover DB
@@ -137,7 +137,7 @@ func TestCreateSettingsUsingUpdate(t *testing.T) {
 
 		testKey := "testsetting1"
 		testValue := "testvalue_1_1"
-		err = store.UpdateSetting(testKey, testValue)
+		_, err = store.UpdateSetting(testKey, testValue)
 		require.NoError(t, err)
 
 		// Make sure the setting document exists in the clover DB
@@ -161,12 +161,12 @@ func TestCreateSettingsUsingUpdate(t *testing.T) {
 
 		testKey1 := "testsetting1"
 		testValue1 := "testvalue_1_1"
-		err = store.UpdateSetting(testKey1, testValue1)
+		_, err = store.UpdateSetting(testKey1, testValue1)
 		require.NoError(t, err)
 
 		testKey2 := "testsetting2"
 		testValue2 := "testvalue_2_1"
-		err = store.UpdateSetting(testKey2, testValue2)
+		_, err = store.UpdateSetting(testKey2, testValue2)
 		require.NoError(t, err)
 
 		// Make sure the setting documents exist in the clover DB
@@ -208,14 +208,14 @@ func TestNewVersions(t *testing.T) {
 		testValue1 := "testvalue_1_1"
 		testValue2 := "testvalue_1_2"
 
-		err = store.UpdateSetting(testKey, testValue1)
+		_, err = store.UpdateSetting(testKey, testValue1)
 		require.NoError(t, err)
 
-		err = store.UpdateSetting(testKey, testValue2)
+		_, err = store.UpdateSetting(testKey, testValue2)
 		require.NoError(t, err)
 
 		// Get the actual setting object, check the versions
-		setting, err := store.getSetting(testKey)
+		setting, err := store.getSetting(testKey, NullLabel)
 		require.NoError(t, err)
 		require.Len(t, setting.Versions, 2)
 		require.Equal(t, testValue2, setting.Versions[0].Value)
@@ -232,7 +232,7 @@ func TestDeleteSettings(t *testing.T) {
 		defer closer()
 
 		testKey1 := "testsetting1"
-		err = store.CreateSetting(testKey1, "testvalue_1_1")
+		_, err = store.CreateSetting(testKey1, "testvalue_1_1")
 		require.NoError(t, err)
 
 		err = store.DeleteSetting(testKey1)
@@ -251,11 +251,11 @@ func TestDeleteSettings(t *testing.T) {
 
 		// CREATE
 		testKey1 := "testsetting1"
-		err = store.CreateSetting(testKey1, "testvalue_1_1")
+		_, err = store.CreateSetting(testKey1, "testvalue_1_1")
 		require.NoError(t, err)
 
 		testKey2 := "testsetting2"
-		err = store.CreateSetting(testKey2, "testvalue_2_1")
+		_, err = store.CreateSetting(testKey2, "testvalue_2_1")
 		require.NoError(t, err)
 
 		// DELETE
@@ -282,29 +282,29 @@ func TestLockSettings(t *testing.T) {
 
 		// CREATE
 		testKey1 := "testsetting1"
-		err = store.CreateSetting(testKey1, "testvalue_1_1")
+		_, err = store.CreateSetting(testKey1, "testvalue_1_1")
 		require.NoError(t, err)
 
 		// CHECK UNLOCKED
-		setting, err := store.getSetting(testKey1)
+		setting, err := store.getSetting(testKey1, NullLabel)
 		require.NoError(t, err)
 		require.False(t, setting.Locked)
 
 		// LOCK
-		err = store.LockSetting(testKey1)
+		_, err = store.LockSetting(testKey1)
 		require.NoError(t, err)
 
 		// CHECK LOCKED
-		setting, err = store.getSetting(testKey1)
+		setting, err = store.getSetting(testKey1, NullLabel)
 		require.NoError(t, err)
 		require.True(t, setting.Locked)
 
 		// UNLOCK
-		err = store.UnlockSetting(testKey1)
+		_, err = store.UnlockSetting(testKey1)
 		require.NoError(t, err)
 
 		// CHECK UNLOCKED
-		setting, err = store.getSetting(testKey1)
+		setting, err = store.getSetting(testKey1, NullLabel)
 		require.NoError(t, err)
 		require.False(t, setting.Locked)
 
@@ -321,7 +321,7 @@ func TestLockSettings(t *testing.T) {
 		require.NoError(t, err)
 
 		// LOCK
-		err = store.LockSetting(testKey1)
+		_, err = store.LockSetting(testKey1)
 		require.NoError(t, err)
 
 		// ATTEMPT UPDATE
@@ -330,4 +330,26 @@ func TestLockSettings(t *testing.T) {
 		require.Error(t, err)
 
 	})
+
+	t.Run("Attempt setting delete while locked", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		// CREATE
+		testKey1 := "testsetting1"
+		_, err = store.CreateSetting(testKey1, "testvalue_1_1")
+		require.NoError(t, err)
+
+		// LOCK
+		_, err = store.LockSetting(testKey1)
+		require.NoError(t, err)
+
+		// ATTEMPT DELETE
+		err = store.DeleteSetting(testKey1)
+		require.ErrorIs(t, err, ErrSettingLocked)
+
+		_, err = store.getSetting(testKey1, NullLabel)
+		require.NoError(t, err)
+	})
 }
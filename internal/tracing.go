@@ -0,0 +1,180 @@
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Requests can be traced, and the spans exported to an OpenTelemetry
+// collector with OTLP over HTTP. Each request is a server span, named after
+// its operationId, with a child span for each call it makes to the store. A
+// W3C traceparent sent by the client is continued, so the emulator's spans
+// join the client's trace; if the client did not sample the trace, nothing
+// is recorded.
+//
+// See https://www.w3.org/TR/trace-context/ and
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+
+const (
+	DefaultTracingServiceName = "aac-emulator"
+
+	traceparentHeader = "traceparent"
+	otlpTracesPath    = "/v1/traces"
+	tracingScope      = "urbanwizardry.com/aac-emulator"
+
+	defaultTracingBatchSize     = 512
+	defaultTracingFlushInterval = 5 * time.Second
+	// tracingQueueSize bounds the spans waiting to be exported; further
+	// spans are dropped
+	tracingQueueSize = 2048
+	// tracingShutdownTimeout bounds the export of the spans still waiting
+	// when Run returns
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// TracingOptions configure a Tracer
+type TracingOptions struct {
+	// Endpoint is the collector's OTLP/HTTP URL, e.g. http://localhost:4318.
+	// /v1/traces is appended if not already present.
+	Endpoint string
+	// ServiceName is reported as the service.name resource attribute
+	// (default DefaultTracingServiceName)
+	ServiceName string
+	// BatchSize is the most spans exported in one request (default 512)
+	BatchSize int
+	// FlushInterval is how often spans are exported (default 5s)
+	FlushInterval time.Duration
+	// Client makes the export requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// A Tracer records spans and exports them to a collector, in batches, in
+// the background
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer returns a Tracer configured by @param opts
+func NewTracer(opts TracingOptions) (*Tracer, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("an OTLP endpoint is required")
+	}
+	if !strings.HasSuffix(opts.Endpoint, otlpTracesPath) {
+		opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/") + otlpTracesPath
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultTracingServiceName
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultTracingBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultTracingFlushInterval
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(opts.Endpoint),
+		otlptracehttp.WithHTTPClient(opts.Client),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the OTLP exporter for %s", opts.Endpoint)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithMaxExportBatchSize(opts.BatchSize),
+			sdktrace.WithBatchTimeout(opts.FlushInterval),
+			sdktrace.WithMaxQueueSize(tracingQueueSize),
+		),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+
+	return &Tracer{
+		provider:   provider,
+		tracer:     provider.Tracer(tracingScope),
+		propagator: propagation.TraceContext{},
+	}, nil
+}
+
+// Run waits until @param ctx is done, then shuts the tracer down, exporting
+// the spans still waiting
+func (t *Tracer) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	err := t.provider.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("failed to export the remaining spans", "error", err)
+	}
+}
+
+// middleware records a server span for each request
+func (t *Tracer) middleware(c *gin.Context) {
+	ctx := t.propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := t.tracer.Start(ctx, c.Request.Method, trace.WithSpanKind(trace.SpanKindServer))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetName(requestOperation(c))
+	span.SetAttributes(
+		attribute.String("http.request.method", c.Request.Method),
+		attribute.String("url.path", c.Request.URL.Path),
+		attribute.Int("http.response.status_code", status),
+		attribute.String("aacemu.request_id", c.GetString(requestIdContextKey)),
+	)
+	if route := c.FullPath(); route != "" {
+		span.SetAttributes(attribute.String("http.route", route))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// startStoreSpan starts a child span of the request's span in @param ctx,
+// a gin context or the context of its request, for the store call
+// @param name. If the request is not traced, the span records nothing.
+func startStoreSpan(ctx context.Context, name string) trace.Span {
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = c.Request.Context()
+	}
+
+	parent := trace.SpanFromContext(ctx)
+	_, span := parent.TracerProvider().Tracer(tracingScope).Start(ctx, "store."+name,
+		trace.WithSpanKind(trace.SpanKindInternal))
+
+	return span
+}
+
+// endStoreSpan ends @param span, marking it as failed by @param err if it
+// is not nil
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
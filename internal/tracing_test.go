@@ -0,0 +1,207 @@
+package emulator
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an OTLP/HTTP endpoint that records the spans it receives
+type collector struct {
+	sync.Mutex
+	paths    []string
+	services []string
+	spans    []*tracepb.Span
+}
+
+func (co *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	co.Lock()
+	defer co.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	var request coltracepb.ExportTraceServiceRequest
+	_ = proto.Unmarshal(body, &request)
+	co.paths = append(co.paths, r.URL.Path)
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, attribute := range resourceSpans.GetResource().GetAttributes() {
+			if attribute.Key == "service.name" {
+				co.services = append(co.services, attribute.GetValue().GetStringValue())
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			co.spans = append(co.spans, scopeSpans.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+func (co *collector) received() []*tracepb.Span {
+	co.Lock()
+	defer co.Unlock()
+	return append([]*tracepb.Span{}, co.spans...)
+}
+
+// named returns the names of @param spans
+func named(spans []*tracepb.Span) []string {
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func TestTracing(t *testing.T) {
+	setup := func(t *testing.T, opts ...RestServerOption) (*gin.Engine, *persistentConfigStore, *collector, func()) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		co := &collector{}
+		server := httptest.NewServer(co)
+		tracer, err := NewTracer(TracingOptions{Endpoint: server.URL, FlushInterval: 10 * time.Millisecond})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			tracer.Run(ctx)
+			close(done)
+		}()
+
+		gin.SetMode(gin.TestMode)
+		return SetupRestServer(store, append(opts, WithTracing(tracer))...), store, co, func() {
+			cancel()
+			<-done
+			server.Close()
+			closer()
+		}
+	}
+
+	t.Run("Operations and store calls join the client's trace", func(t *testing.T) {
+		engine, store, co, stop := setup(t)
+		defer stop()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/kv/App:Name?api-version=2023-10-01", nil)
+		rq.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		engine.ServeHTTP(rec, rq)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		require.Eventually(t, func() bool { return len(co.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+		co.Lock()
+		require.Equal(t, otlpTracesPath, co.paths[0])
+		require.Equal(t, DefaultTracingServiceName, co.services[0])
+		co.Unlock()
+
+		storeSpan, server := co.received()[0], co.received()[1]
+		require.Equal(t, "GetKeyValue", server.Name)
+		require.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind)
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(server.TraceId))
+		require.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId))
+		statusCode := int64(0)
+		for _, attribute := range server.Attributes {
+			if attribute.Key == "http.response.status_code" {
+				statusCode = attribute.GetValue().GetIntValue()
+			}
+		}
+		require.Equal(t, int64(http.StatusOK), statusCode)
+
+		require.Equal(t, "store.GetLabeledConfigSetting", storeSpan.Name)
+		require.Equal(t, tracepb.Span_SPAN_KIND_INTERNAL, storeSpan.Kind)
+		require.Equal(t, server.TraceId, storeSpan.TraceId)
+		require.Equal(t, server.SpanId, storeSpan.ParentSpanId)
+	})
+
+	t.Run("Failed store calls are marked as errors", func(t *testing.T) {
+		engine, _, co, stop := setup(t)
+		defer stop()
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kv/Missing?api-version=2023-10-01", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		require.Eventually(t, func() bool { return len(co.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+		storeSpan := co.received()[0]
+		require.Equal(t, "store.GetLabeledConfigSetting", storeSpan.Name)
+		require.Equal(t, tracepb.Status_STATUS_CODE_ERROR, storeSpan.GetStatus().GetCode())
+		require.Contains(t, storeSpan.GetStatus().GetMessage(), "Missing")
+	})
+
+	t.Run("Admin, feature flag and Key Vault store calls are traced", func(t *testing.T) {
+		engine, _, co, stop := setup(t, WithKeyVault())
+		defer stop()
+
+		for _, rq := range []*http.Request{
+			httptest.NewRequest(http.MethodPut, AdminBasePath+"/settings?key=App:Name", strings.NewReader(`{"value": "demo"}`)),
+			httptest.NewRequest(http.MethodPut, AdminBasePath+"/featureflags/Beta", strings.NewReader(`{"enabled": true}`)),
+			httptest.NewRequest(http.MethodPut, "/secrets/db-password?api-version=7.4", strings.NewReader(`{"value": "s3cret"}`)),
+		} {
+			rq.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, rq)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		require.Eventually(t, func() bool { return len(co.received()) == 6 }, 5*time.Second, 10*time.Millisecond)
+		names := named(co.received())
+		require.Contains(t, names, "store.UpdateLabeledSetting")
+		require.Contains(t, names, "store.PutFeatureFlag")
+		require.Contains(t, names, "store.SetSecret")
+	})
+
+	t.Run("Unsampled traces are not recorded", func(t *testing.T) {
+		engine, _, co, stop := setup(t)
+		defer stop()
+
+		rq := httptest.NewRequest(http.MethodGet, "/kv?api-version=2023-10-01", nil)
+		rq.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		engine.ServeHTTP(httptest.NewRecorder(), rq)
+
+		// Untraced requests start a new trace
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/kv?api-version=2023-10-01", nil))
+
+		require.Eventually(t, func() bool { return len(co.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		spans := co.received()
+		require.Len(t, spans, 2)
+		require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(spans[0].TraceId))
+		require.Empty(t, spans[1].ParentSpanId)
+	})
+
+	t.Run("Invalid traceparent headers start a new trace", func(t *testing.T) {
+		engine, _, co, stop := setup(t)
+		defer stop()
+
+		for _, traceparent := range []string{
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			rq := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+			rq.Header.Set(traceparentHeader, traceparent)
+			engine.ServeHTTP(httptest.NewRecorder(), rq)
+		}
+
+		require.Eventually(t, func() bool { return len(co.received()) == 5 }, 5*time.Second, 10*time.Millisecond)
+		for _, span := range co.received() {
+			require.Empty(t, span.ParentSpanId)
+			require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(span.TraceId))
+		}
+	})
+}
@@ -0,0 +1,379 @@
+// Admin UI for the App Configuration emulator, over the admin API.
+"use strict";
+
+const API = location.pathname.replace(/\/ui\/.*$/, "");
+
+const FEATURE_FLAG_PREFIX = ".appconfig.featureflag/";
+const FEATURE_FLAG_CONTENT_TYPE = "application/vnd.microsoft.appconfig.ff+json;charset=utf-8";
+const KEY_VAULT_REF_CONTENT_TYPE = "application/vnd.microsoft.appconfig.keyvaultref+json;charset=utf-8";
+
+const $ = (selector, root = document) => root.querySelector(selector);
+
+async function api(method, path, params, body) {
+  const url = new URL(API + path, location.origin);
+  for (const [name, value] of Object.entries(params || {})) {
+    url.searchParams.set(name, value);
+  }
+
+  const response = await fetch(url, {
+    method,
+    headers: body === undefined ? {} : { "Content-Type": "application/json" },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      const problem = await response.json();
+      message = problem.detail || problem.title || message;
+    } catch (e) {
+      // Not a problem document
+    }
+    throw new Error(message);
+  }
+  return response.status === 204 ? null : response.json();
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+    td.title = text;
+  }
+  return td;
+}
+
+function button(parent, text, onclick) {
+  const b = document.createElement("button");
+  b.textContent = text;
+  b.onclick = onclick;
+  parent.append(b);
+  return b;
+}
+
+function labelText(label) {
+  return label === "" ? "(null label)" : label;
+}
+
+function contentKind(key, contentType) {
+  const mediaType = (contentType || "").split(";")[0].trim().toLowerCase();
+  if (key.startsWith(FEATURE_FLAG_PREFIX) && mediaType === FEATURE_FLAG_CONTENT_TYPE.split(";")[0]) {
+    return "featureflag";
+  }
+  if (mediaType === KEY_VAULT_REF_CONTENT_TYPE.split(";")[0]) {
+    return "keyvaultref";
+  }
+  if (mediaType === "application/json" || mediaType.endsWith("+json")) {
+    return "json";
+  }
+  return "text";
+}
+
+// Settings
+
+async function loadLabels() {
+  const select = $("#label-filter");
+  const current = select.value;
+  const { items } = await api("GET", "/labels");
+
+  select.replaceChildren(new Option("(all labels)", "*"));
+  for (const label of items) {
+    select.append(new Option(labelText(label), label === "" ? "\0" : label));
+  }
+  select.value = [...select.options].some((o) => o.value === current) ? current : "*";
+}
+
+async function loadSettings() {
+  const params = { label: $("#label-filter").value || "*" };
+  const key = $("#key-filter").value.trim();
+  if (key) {
+    params.key = key;
+  }
+
+  const { items } = await api("GET", "/settings", params);
+  const tbody = $("#settings tbody");
+  tbody.replaceChildren();
+
+  for (const setting of items) {
+    const row = tbody.insertRow();
+    row.classList.toggle("locked", setting.locked);
+    cell(row, setting.key);
+    cell(row, labelText(setting.label));
+    cell(row, setting.value, "value");
+    cell(row, setting.content_type);
+    cell(row, setting.versions);
+
+    const actions = row.insertCell();
+    const id = { key: setting.key, label: setting.label };
+    button(actions, "Edit", () => openEditor(setting));
+    button(actions, "History", () => openHistory(id));
+    button(actions, setting.locked ? "Unlock" : "Lock", () =>
+      run(api("POST", setting.locked ? "/settings/unlock" : "/settings/lock", id)));
+    button(actions, "Delete", () => {
+      if (confirm(`Delete ${setting.key} (${labelText(setting.label)})?`)) {
+        run(api("DELETE", "/settings", id));
+      }
+    });
+  }
+}
+
+async function refresh() {
+  await loadLabels();
+  await loadSettings();
+}
+
+function run(promise) {
+  promise.then(refresh).catch((e) => alert(e.message));
+}
+
+// Editor
+
+function showEditor(kind) {
+  for (const section of document.querySelectorAll("[data-editor]")) {
+    section.hidden = !section.dataset.editor.split(" ").includes(kind);
+  }
+}
+
+function openEditor(setting) {
+  const form = $("#editor-form");
+  const isNew = !setting;
+  setting = setting || { key: "", label: "", value: "", content_type: "", tags: {} };
+
+  $("#editor-title").textContent = isNew ? "New setting" : `Edit ${setting.key}`;
+  $("#editor-error").textContent = "";
+  form.key.value = setting.key;
+  form.key.readOnly = !isNew;
+  form.label.value = setting.label;
+  form.label.readOnly = !isNew;
+  form.content_type.value = setting.content_type;
+  form.value.value = setting.value;
+  form.tags.value = JSON.stringify(setting.tags || {}, null, 2);
+
+  const kind = contentKind(setting.key, setting.content_type);
+  form.kind.value = kind;
+  if (kind === "json") {
+    try {
+      form.value.value = JSON.stringify(JSON.parse(setting.value), null, 2);
+    } catch (e) {
+      // Leave invalid JSON as it is, for the user to fix
+    }
+  }
+
+  let flag = {};
+  let ref = {};
+  try {
+    if (kind === "featureflag") flag = JSON.parse(setting.value);
+    if (kind === "keyvaultref") ref = JSON.parse(setting.value);
+  } catch (e) {
+    // Start from an empty flag or reference
+  }
+  form.ff_description.value = flag.description || "";
+  form.ff_enabled.checked = !!flag.enabled;
+  form.ff_filters.value = JSON.stringify((flag.conditions || {}).client_filters || [], null, 2);
+  form.kv_uri.value = ref.uri || "";
+
+  showEditor(kind);
+  $("#editor").showModal();
+}
+
+function editorValue(form) {
+  const kind = form.kind.value;
+  let key = form.key.value.trim();
+  let contentType = form.content_type.value.trim();
+  let value = form.value.value;
+
+  switch (kind) {
+    case "json":
+      value = JSON.stringify(JSON.parse(value));
+      if (!contentType) contentType = "application/json";
+      break;
+
+    case "featureflag": {
+      if (!key.startsWith(FEATURE_FLAG_PREFIX)) key = FEATURE_FLAG_PREFIX + key;
+      const id = key.slice(FEATURE_FLAG_PREFIX.length);
+      value = JSON.stringify({
+        id,
+        description: form.ff_description.value,
+        enabled: form.ff_enabled.checked,
+        conditions: { client_filters: JSON.parse(form.ff_filters.value || "[]") },
+      });
+      contentType = FEATURE_FLAG_CONTENT_TYPE;
+      break;
+    }
+
+    case "keyvaultref":
+      value = JSON.stringify({ uri: form.kv_uri.value.trim() });
+      contentType = KEY_VAULT_REF_CONTENT_TYPE;
+      break;
+  }
+
+  return {
+    key,
+    label: form.label.value,
+    body: { value, content_type: contentType, tags: JSON.parse(form.tags.value || "{}") },
+  };
+}
+
+async function saveEditor(event) {
+  const form = $("#editor-form");
+  if (event.submitter && event.submitter.value !== "save") {
+    return;
+  }
+  event.preventDefault();
+
+  try {
+    const { key, label, body } = editorValue(form);
+    await api("PUT", "/settings", { key, label }, body);
+    $("#editor").close();
+    await refresh();
+  } catch (e) {
+    $("#editor-error").textContent = e.message;
+  }
+}
+
+// History
+
+// diffLines is a longest common subsequence line diff of @a and @b
+function diffLines(a, b) {
+  const x = a.split("\n");
+  const y = b.split("\n");
+  const lcs = Array.from({ length: x.length + 1 }, () => new Array(y.length + 1).fill(0));
+  for (let i = x.length - 1; i >= 0; i--) {
+    for (let j = y.length - 1; j >= 0; j--) {
+      lcs[i][j] = x[i] === y[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+    }
+  }
+
+  const lines = [];
+  let i = 0;
+  let j = 0;
+  while (i < x.length || j < y.length) {
+    if (i < x.length && j < y.length && x[i] === y[j]) {
+      lines.push({ op: " ", text: x[i++] });
+      j++;
+    } else if (j < y.length && (i === x.length || lcs[i][j + 1] >= lcs[i + 1][j])) {
+      lines.push({ op: "+", text: y[j++] });
+    } else {
+      lines.push({ op: "-", text: x[i++] });
+    }
+  }
+  return lines;
+}
+
+function prettyValue(value) {
+  try {
+    return JSON.stringify(JSON.parse(value), null, 2);
+  } catch (e) {
+    return value;
+  }
+}
+
+function showDiff(older, newer) {
+  const pre = $("#history-diff");
+  pre.replaceChildren();
+  for (const line of diffLines(prettyValue(older.value), prettyValue(newer.value))) {
+    const span = document.createElement("span");
+    span.className = line.op === "+" ? "added" : line.op === "-" ? "removed" : "";
+    span.textContent = `${line.op} ${line.text}\n`;
+    pre.append(span);
+  }
+}
+
+async function openHistory(id) {
+  const history = await api("GET", "/settings/history", id);
+  $("#history-title").textContent = `${history.key} (${labelText(history.label)})`;
+  $("#history-diff").replaceChildren();
+
+  const tbody = $("#history-versions tbody");
+  tbody.replaceChildren();
+  history.items.forEach((version, index) => {
+    const row = tbody.insertRow();
+    cell(row, new Date(version.last_modified).toLocaleString());
+    cell(row, version.etag);
+    cell(row, version.content_type);
+    cell(row, version.value, "value");
+
+    const actions = row.insertCell();
+    const previous = history.items[index + 1];
+    if (previous) {
+      button(actions, "Diff with previous", () => showDiff(previous, version));
+    }
+    if (index > 0) {
+      button(actions, "Restore", () => {
+        api("PUT", "/settings", id, {
+          value: version.value,
+          content_type: version.content_type,
+          tags: version.tags,
+        }).then(() => openHistory(id)).then(refresh).catch((e) => alert(e.message));
+      });
+    }
+  });
+
+  if (!$("#history").open) {
+    $("#history").showModal();
+  }
+}
+
+// Snapshots
+
+async function loadSnapshots() {
+  const { items } = await api("GET", "/snapshots");
+  const tbody = $("#snapshots tbody");
+  tbody.replaceChildren();
+
+  for (const snapshot of items) {
+    const row = tbody.insertRow();
+    row.classList.toggle("archived", snapshot.status === "archived");
+    cell(row, snapshot.name);
+    cell(row, snapshot.status);
+    cell(row, new Date(snapshot.created).toLocaleString());
+    cell(row, (snapshot.settings || []).length);
+
+    const actions = row.insertCell();
+    const path = `/snapshots/${encodeURIComponent(snapshot.name)}`;
+    if (snapshot.status === "archived") {
+      button(actions, "Recover", () => api("POST", path + "/recover").then(loadSnapshots).catch((e) => alert(e.message)));
+    } else {
+      button(actions, "Archive", () => api("POST", path + "/archive").then(loadSnapshots).catch((e) => alert(e.message)));
+    }
+  }
+}
+
+async function createSnapshot(event) {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    await api("POST", "/snapshots", undefined, {
+      name: form.name.value.trim(),
+      filters: [{ key: form.key.value.trim(), label: form.label.value }],
+    });
+    form.name.value = "";
+    await loadSnapshots();
+  } catch (e) {
+    alert(e.message);
+  }
+}
+
+// Navigation
+
+function showView(view) {
+  for (const b of document.querySelectorAll("nav button")) {
+    b.classList.toggle("active", b.dataset.view === view);
+  }
+  $("#settings-view").hidden = view !== "settings";
+  $("#snapshots-view").hidden = view !== "snapshots";
+  (view === "settings" ? refresh() : loadSnapshots()).catch((e) => alert(e.message));
+}
+
+for (const b of document.querySelectorAll("nav button")) {
+  b.onclick = () => showView(b.dataset.view);
+}
+$("#refresh").onclick = () => refresh().catch((e) => alert(e.message));
+$("#label-filter").onchange = () => loadSettings().catch((e) => alert(e.message));
+$("#key-filter").onchange = () => loadSettings().catch((e) => alert(e.message));
+$("#new-setting").onclick = () => openEditor(null);
+$("#editor-form").kind.onchange = (e) => showEditor(e.target.value);
+$("#editor-form").addEventListener("submit", saveEditor);
+$("#snapshot-form").addEventListener("submit", createSnapshot);
+
+showView("settings");
@@ -0,0 +1,151 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The watch endpoint is not part of App Configuration. It streams changes
+// to settings as server-sent events, so that tests and hot-reload demos
+// can wait for a change instead of polling GetKeyValues:
+//
+//	GET /_watch?key=App:*&label=prod
+//
+// Each change is sent as an event named "modified" or "deleted", with the
+// change's sequence number as the event id. With once=true the request
+// instead long-polls: it returns the first matching change as JSON, or 204
+// No Content if none is made within timeout (default 30s).
+
+const (
+	WatchPath = "/_watch"
+
+	defaultWatchTimeout = 30 * time.Second
+	watchHeartbeat      = 15 * time.Second
+	// watchBufferSize bounds the changes waiting to be sent to one watcher;
+	// a watcher that falls further behind is disconnected
+	watchBufferSize = 100
+)
+
+// watchEvent is a change as sent to watchers
+type watchEvent struct {
+	Type      SettingChangeType `json:"type"`
+	Key       string            `json:"key"`
+	Label     string            `json:"label"`
+	Etag      string            `json:"etag"`
+	SyncToken string            `json:"syncToken"`
+	Time      time.Time         `json:"time"`
+}
+
+type watchServer struct {
+	configStore *persistentConfigStore
+}
+
+func registerWatchRoutes(g *gin.RouterGroup, configStore *persistentConfigStore) {
+	ws := watchServer{configStore: configStore}
+	g.GET(WatchPath, ws.watch)
+}
+
+func (ws *watchServer) watch(c *gin.Context) {
+	keyFilter, labelFilter, ok := querySettingFilters(c)
+	if !ok {
+		return
+	}
+
+	timeout := defaultWatchTimeout
+	if t := c.Query("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout <= 0 {
+			writeError(c, http.StatusBadRequest, errTypeInvalidArgument, "Invalid timeout", "timeout",
+				"timeout must be a positive duration, such as 30s")
+			return
+		}
+	}
+
+	changes := make(chan SettingChange, watchBufferSize)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+
+	unsubscribe := ws.configStore.OnChange(func(change SettingChange) {
+		if !keyFilter.Apply(change.Key) || !labelFilter.Apply(change.Label) {
+			return
+		}
+		select {
+		case changes <- change:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	if c.Query("once") == "true" {
+		ws.longPoll(c, changes, timeout)
+		return
+	}
+
+	ws.stream(c, changes, overflow)
+}
+
+// longPoll returns the first change, or No Content after @param timeout
+func (ws *watchServer) longPoll(c *gin.Context, changes chan SettingChange, timeout time.Duration) {
+	select {
+	case <-c.Request.Context().Done():
+	case <-time.After(timeout):
+		c.Status(http.StatusNoContent)
+	case change := <-changes:
+		c.JSON(http.StatusOK, newWatchEvent(change))
+	}
+}
+
+// stream sends changes as server-sent events until the client disconnects
+func (ws *watchServer) stream(c *gin.Context, changes chan SettingChange, overflow chan struct{}) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// Send the headers now, so the client knows it is subscribed
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+
+		case <-overflow:
+			fmt.Fprint(c.Writer, "event: overflow\ndata: {}\n\n")
+			c.Writer.Flush()
+			return
+
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+
+		case change := <-changes:
+			data, err := json.Marshal(newWatchEvent(change))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", change.Sequence, change.Type, data)
+			c.Writer.Flush()
+		}
+	}
+}
+
+func newWatchEvent(change SettingChange) watchEvent {
+	return watchEvent{
+		Type:      change.Type,
+		Key:       change.Key,
+		Label:     change.Label,
+		Etag:      change.Etag,
+		SyncToken: change.SyncToken,
+		Time:      change.Time,
+	}
+}
@@ -0,0 +1,104 @@
+package emulator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	t.Run("Streams matching changes as server-sent events", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+		server := httptest.NewServer(engine)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		rq, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+WatchPath+"?key=App:*&label=prod", nil)
+		require.NoError(t, err)
+		rs, err := http.DefaultClient.Do(rq)
+		require.NoError(t, err)
+		defer rs.Body.Close()
+		require.Equal(t, http.StatusOK, rs.StatusCode)
+		require.Equal(t, "text/event-stream", rs.Header.Get("Content-Type"))
+
+		_, err = store.UpdateSetting("App:Name", "null label, not sent")
+		require.NoError(t, err)
+		_, err = store.UpdateLabeledSetting("Other", "prod", "not sent", SettingAttributes{})
+		require.NoError(t, err)
+		setting, err := store.UpdateLabeledSetting("App:Name", "prod", "demo", SettingAttributes{})
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteLabeledSetting("App:Name", "prod"))
+
+		lines := []string{}
+		scanner := bufio.NewScanner(rs.Body)
+		for len(lines) < 8 && scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		require.Equal(t, "id: 3", lines[0])
+		require.Equal(t, "event: modified", lines[1])
+		var event watchEvent
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event))
+		require.Equal(t, "App:Name", event.Key)
+		require.Equal(t, "prod", event.Label)
+		require.Equal(t, setting.Versions[0].Uuid, event.Etag)
+		require.Equal(t, formatSyncToken(3), event.SyncToken)
+
+		require.Equal(t, "id: 4", lines[4])
+		require.Equal(t, "event: deleted", lines[5])
+	})
+
+	t.Run("Long-polls for the first change", func(t *testing.T) {
+		engine, store, closer := makeTestRestServer(t)
+		defer closer()
+
+		result := make(chan *httptest.ResponseRecorder)
+		go func() {
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, WatchPath+"?key=App:Name&once=true", nil))
+			result <- rec
+		}()
+
+		// The watch may not have subscribed yet, so keep changing the key
+		var rec *httptest.ResponseRecorder
+		require.Eventually(t, func() bool {
+			_, err := store.UpdateSetting("App:Name", "demo")
+			require.NoError(t, err)
+			select {
+			case rec = <-result:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 20*time.Millisecond)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var event watchEvent
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &event))
+		require.Equal(t, SettingModified, event.Type)
+		require.Equal(t, "App:Name", event.Key)
+	})
+
+	t.Run("Long-polls time out with no content", func(t *testing.T) {
+		engine, _, closer := makeTestRestServer(t)
+		defer closer()
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, WatchPath+"?once=true&timeout=10ms", nil))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, WatchPath+"?timeout=soon", nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
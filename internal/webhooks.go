@@ -0,0 +1,301 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhooks deliver change notifications the way an Event Grid subscription
+// on an App Configuration store does, so apps can test push refresh:
+// every change to a setting is POSTed to each subscribed endpoint as a
+// Microsoft.AppConfiguration.KeyValueModified or KeyValueDeleted event.
+//
+// Before delivering anything, each endpoint must complete the validation
+// handshake of its schema. Failed deliveries are retried with exponential
+// backoff; each endpoint receives its events in order.
+
+const (
+	eventTypeSubscriptionValidation = "Microsoft.EventGrid.SubscriptionValidationEvent"
+
+	// webhookQueueSize bounds the events waiting for each endpoint;
+	// events beyond it are dropped
+	webhookQueueSize = 1000
+)
+
+// WebhookSubscription subscribes an endpoint to changes to settings whose
+// keys start with KeyPrefix (all settings if empty)
+type WebhookSubscription struct {
+	Endpoint  string
+	Schema    string
+	KeyPrefix string
+}
+
+// WebhookOptions configure how events are delivered
+type WebhookOptions struct {
+	EventOptions
+	// MaxAttempts is the number of delivery attempts per event (default 5)
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, doubling for each
+	// subsequent retry (default 1s)
+	RetryDelay time.Duration
+	// Client sends the requests (http.DefaultClient if nil)
+	Client *http.Client
+}
+
+type WebhookDispatcher struct {
+	subscriptions []WebhookSubscription
+	opts          WebhookOptions
+	queues        []chan SettingChange
+}
+
+// NewWebhookDispatcher delivers changes to @param store to each of
+// @param subscriptions. Nothing is delivered until Run is called.
+func NewWebhookDispatcher(store *persistentConfigStore, subscriptions []WebhookSubscription, opts WebhookOptions) (*WebhookDispatcher, error) {
+	for _, sub := range subscriptions {
+		u, err := url.Parse(sub.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook endpoint '%s'", sub.Endpoint)
+		}
+		if !slices.Contains(EventSchemas, sub.Schema) {
+			return nil, fmt.Errorf("unknown webhook schema '%s'", sub.Schema)
+		}
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	wd := &WebhookDispatcher{
+		subscriptions: subscriptions,
+		opts:          opts,
+		queues:        make([]chan SettingChange, len(subscriptions)),
+	}
+	for i := range wd.queues {
+		wd.queues[i] = make(chan SettingChange, webhookQueueSize)
+	}
+
+	store.OnChange(wd.enqueue)
+
+	return wd, nil
+}
+
+// Run validates each endpoint, then delivers events to it until @param ctx
+// is done. Endpoints that fail validation receive no events.
+func (wd *WebhookDispatcher) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for i, sub := range wd.subscriptions {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			wd.deliver(ctx, sub, wd.queues[i])
+		}()
+	}
+
+	for range wd.subscriptions {
+		<-done
+	}
+}
+
+// enqueue is the store listener, so must not block
+func (wd *WebhookDispatcher) enqueue(change SettingChange) {
+	for i, sub := range wd.subscriptions {
+		if !strings.HasPrefix(change.Key, sub.KeyPrefix) {
+			continue
+		}
+
+		select {
+		case wd.queues[i] <- change:
+		default:
+			slog.Warn("webhook queue full, dropping event", "endpoint", sub.Endpoint, "key", change.Key)
+		}
+	}
+}
+
+func (wd *WebhookDispatcher) deliver(ctx context.Context, sub WebhookSubscription, queue chan SettingChange) {
+	err := wd.validate(ctx, sub)
+	if err != nil {
+		slog.Error("webhook validation failed, no events will be sent", "endpoint", sub.Endpoint, "error", err)
+		// Keep draining so the queue never fills
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-queue:
+			}
+		}
+	}
+	slog.Info("webhook validated", "endpoint", sub.Endpoint, "schema", sub.Schema)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-queue:
+			err := wd.send(ctx, sub, change)
+			if err != nil {
+				slog.Error("failed to deliver webhook event", "endpoint", sub.Endpoint,
+					"key", change.Key, "label", change.Label, "error", err)
+			}
+		}
+	}
+}
+
+// validate performs the validation handshake of @param sub's schema, with
+// retries in case the endpoint is still starting. Event Grid endpoints must
+// echo a validation code; CloudEvents endpoints must allow the emulator as
+// a webhook origin.
+func (wd *WebhookDispatcher) validate(ctx context.Context, sub WebhookSubscription) error {
+	if sub.Schema == EventSchemaCloudEvents {
+		origin := wd.opts.origin()
+
+		return wd.retry(ctx, sub, func() (bool, error) {
+			rq, err := http.NewRequestWithContext(ctx, http.MethodOptions, sub.Endpoint, nil)
+			if err != nil {
+				return false, err
+			}
+			rq.Header.Set("WebHook-Request-Origin", origin)
+
+			rs, err := wd.opts.Client.Do(rq)
+			if err != nil {
+				return true, err
+			}
+			rs.Body.Close()
+
+			allowed := rs.Header.Get("WebHook-Allowed-Origin")
+			if rs.StatusCode != http.StatusOK || (allowed != origin && allowed != "*") {
+				return retryableStatus(rs.StatusCode),
+					fmt.Errorf("endpoint did not allow origin %s (status %d)", origin, rs.StatusCode)
+			}
+			return false, nil
+		})
+	}
+
+	code := uuid.NewString()
+	body, err := json.Marshal([]eventGridEvent{{
+		Id:              uuid.NewString(),
+		Topic:           wd.opts.topic(),
+		EventType:       eventTypeSubscriptionValidation,
+		EventTime:       time.Now().UTC(),
+		Data:            map[string]string{"validationCode": code},
+		DataVersion:     "2",
+		MetadataVersion: "1",
+	}})
+	if err != nil {
+		return err
+	}
+
+	return wd.retry(ctx, sub, func() (bool, error) {
+		rs, err := wd.post(ctx, sub.Endpoint, "SubscriptionValidation", "application/json", body)
+		if err != nil {
+			return true, err
+		}
+		defer rs.Body.Close()
+
+		if rs.StatusCode != http.StatusOK {
+			return retryableStatus(rs.StatusCode), fmt.Errorf("validation returned status %d", rs.StatusCode)
+		}
+
+		var response struct {
+			ValidationResponse string `json:"validationResponse"`
+		}
+		err = json.NewDecoder(rs.Body).Decode(&response)
+		if err != nil {
+			return false, fmt.Errorf("invalid validation response: %s", err)
+		}
+		if response.ValidationResponse != code {
+			return false, fmt.Errorf("validation response did not match the validation code")
+		}
+		return false, nil
+	})
+}
+
+// send delivers @param change to @param sub
+func (wd *WebhookDispatcher) send(ctx context.Context, sub WebhookSubscription, change SettingChange) error {
+	body, contentType, err := wd.encode(sub, change)
+	if err != nil {
+		return err
+	}
+
+	return wd.retry(ctx, sub, func() (bool, error) {
+		rs, err := wd.post(ctx, sub.Endpoint, "Notification", contentType, body)
+		if err != nil {
+			return true, err
+		}
+		io.Copy(io.Discard, rs.Body)
+		rs.Body.Close()
+
+		if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+			return retryableStatus(rs.StatusCode), fmt.Errorf("endpoint returned status %d", rs.StatusCode)
+		}
+		return false, nil
+	})
+}
+
+// retry calls @param attempt until it succeeds, fails with an error it
+// reports as not retryable, or has been tried MaxAttempts times
+func (wd *WebhookDispatcher) retry(ctx context.Context, sub WebhookSubscription, attempt func() (bool, error)) error {
+	delay := wd.opts.RetryDelay
+	for n := 1; ; n++ {
+		retryable, err := attempt()
+		if err == nil || !retryable {
+			return err
+		}
+		if n >= wd.opts.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %s", n, err)
+		}
+
+		slog.Debug("retrying webhook request", "endpoint", sub.Endpoint, "attempt", n, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (wd *WebhookDispatcher) post(ctx context.Context, endpoint string, eventType string, contentType string, body []byte) (*http.Response, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Set("Content-Type", contentType)
+	rq.Header.Set("aeg-event-type", eventType)
+
+	return wd.opts.Client.Do(rq)
+}
+
+// encode returns the payload for @param change in @param sub's schema.
+// Event Grid delivers events to webhooks in arrays.
+func (wd *WebhookDispatcher) encode(sub WebhookSubscription, change SettingChange) ([]byte, string, error) {
+	event, contentType := newChangeEvent(sub.Schema, wd.opts.EventOptions, change)
+	if sub.Schema == EventSchemaEventGrid {
+		event = []interface{}{event}
+	}
+
+	body, err := json.Marshal(event)
+	return body, contentType, err
+}
+
+// retryableStatus reports whether a delivery failing with @param status may
+// succeed if retried. Like Event Grid, client errors other than timeouts
+// and throttling are not retried.
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
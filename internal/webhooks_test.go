@@ -0,0 +1,159 @@
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver is an endpoint that completes the validation handshake
+// and records the events it receives
+type webhookReceiver struct {
+	sync.Mutex
+	events   []map[string]interface{}
+	failures int
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.Lock()
+	defer wr.Unlock()
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("WebHook-Allowed-Origin", r.Header.Get("WebHook-Request-Origin"))
+		return
+	}
+
+	if wr.failures > 0 {
+		wr.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var events []map[string]interface{}
+	if r.Header.Get("Content-Type") == "application/json" {
+		_ = json.NewDecoder(r.Body).Decode(&events)
+	} else {
+		var event map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}
+
+	for _, event := range events {
+		if event["eventType"] == eventTypeSubscriptionValidation {
+			data := event["data"].(map[string]interface{})
+			json.NewEncoder(w).Encode(map[string]interface{}{"validationResponse": data["validationCode"]})
+			return
+		}
+		wr.events = append(wr.events, event)
+	}
+}
+
+func (wr *webhookReceiver) received() []map[string]interface{} {
+	wr.Lock()
+	defer wr.Unlock()
+	return append([]map[string]interface{}{}, wr.events...)
+}
+
+func TestWebhooks(t *testing.T) {
+	start := func(t *testing.T, schema string, prefix string, receiver http.Handler) (*persistentConfigStore, func()) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+
+		server := httptest.NewServer(receiver)
+		dispatcher, err := NewWebhookDispatcher(store, []WebhookSubscription{
+			{Endpoint: server.URL, Schema: schema, KeyPrefix: prefix},
+		}, WebhookOptions{
+			EventOptions: EventOptions{Origin: "http://localhost:9876"},
+			RetryDelay:   time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go dispatcher.Run(ctx)
+
+		return store, func() {
+			cancel()
+			server.Close()
+			closer()
+		}
+	}
+
+	t.Run("Event Grid schema, filtered by key prefix, with retries", func(t *testing.T) {
+		receiver := &webhookReceiver{failures: 2}
+		store, stop := start(t, EventSchemaEventGrid, "App:", receiver)
+		defer stop()
+
+		setting, err := store.UpdateLabeledSetting("App:Name", "prod", "demo", SettingAttributes{})
+		require.NoError(t, err)
+		_, err = store.UpdateSetting("Other", "not sent")
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteLabeledSetting("App:Name", "prod"))
+
+		require.Eventually(t, func() bool { return len(receiver.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+		events := receiver.received()
+		require.Equal(t, EventTypeKeyValueModified, events[0]["eventType"])
+		require.Equal(t, "http://localhost:9876/kv/App:Name?label=prod", events[0]["subject"])
+		require.Equal(t, map[string]interface{}{
+			"key":       "App:Name",
+			"label":     "prod",
+			"etag":      setting.Versions[0].Uuid,
+			"syncToken": formatSyncToken(1),
+		}, events[0]["data"])
+		require.Equal(t, EventTypeKeyValueDeleted, events[1]["eventType"])
+	})
+
+	t.Run("CloudEvents schema", func(t *testing.T) {
+		receiver := &webhookReceiver{}
+		store, stop := start(t, EventSchemaCloudEvents, "", receiver)
+		defer stop()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return len(receiver.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+		event := receiver.received()[0]
+		require.Equal(t, "1.0", event["specversion"])
+		require.Equal(t, EventTypeKeyValueModified, event["type"])
+		require.Equal(t, DefaultEventTopic, event["source"])
+	})
+
+	t.Run("Endpoints that fail validation receive no events", func(t *testing.T) {
+		var mu sync.Mutex
+		posts := 0
+		store, stop := start(t, EventSchemaEventGrid, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			posts++
+		}))
+		defer stop()
+
+		_, err := store.UpdateSetting("App:Name", "demo")
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, 1, posts)
+	})
+
+	t.Run("Sync tokens advance with every change", func(t *testing.T) {
+		store, _, closer, err := makeTestStore(t)
+		require.NoError(t, err)
+		defer closer()
+
+		require.Equal(t, formatSyncToken(0), store.SyncToken())
+		_, err = store.UpdateSetting("a", "1")
+		require.NoError(t, err)
+		_, err = store.LockSetting("a")
+		require.NoError(t, err)
+		require.Equal(t, "aacemu=MDoy;sn=2", store.SyncToken())
+	})
+}
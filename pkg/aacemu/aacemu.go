@@ -0,0 +1,342 @@
+// Package aacemu runs the App Configuration emulator in-process, for the
+// tests of other modules. Each emulator has its own in-memory store and
+// listens on a random local port, so tests can run isolated emulators in
+// parallel:
+//
+//	emu := aacemu.New(t, aacemu.Options{
+//		Settings: []aacemu.Setting{{Key: "App:Name", Value: "demo"}},
+//	})
+//	client, err := azappconfig.NewClientFromConnectionString(emu.ConnectionString(), nil)
+package aacemu
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	emulator "urbanwizardry.com/aac-emulator/internal"
+)
+
+// ErrNotFound is returned for settings that do not exist
+var ErrNotFound = emulator.ErrSettingNotFound
+
+// NullLabel is the label of settings that have none
+const NullLabel = emulator.NullLabel
+
+// Feature flags, as stored by PutFeatureFlag
+type (
+	FeatureFlag           = emulator.FeatureFlag
+	FeatureFlagConditions = emulator.FeatureFlagConditions
+	FeatureFlagFilter     = emulator.FeatureFlagFilter
+	FeatureFlagVariant    = emulator.FeatureFlagVariant
+	FeatureFlagAllocation = emulator.FeatureFlagAllocation
+)
+
+// quietGin sets gin's mode, which is global, once rather than as each
+// emulator starts, as tests may start emulators in parallel
+var quietGin sync.Once
+
+// Setting is a key-value, with its label and attributes
+type Setting struct {
+	Key         string
+	Label       string
+	Value       string
+	ContentType string
+	Tags        map[string]string
+	Locked      bool
+}
+
+// Options configure an Emulator
+type Options struct {
+	// Settings are stored before the emulator starts serving
+	Settings []Setting
+	// RequireHmac rejects requests not signed with the emulator's
+	// credential. Otherwise any request is accepted, signed or not.
+	RequireHmac bool
+	// ReadOnly rejects every operation that modifies the store, other than
+	// through the Emulator's own methods
+	ReadOnly bool
+	// ApiVersions are the api-version values accepted (default all those
+	// the emulator supports)
+	ApiVersions []string
+}
+
+// configStore is the part of the emulator's store an Emulator uses
+type configStore interface {
+	UpdateLabeledSetting(key string, label string, value string, attrs emulator.SettingAttributes) (emulator.ConfigSetting, error)
+	GetLabeledConfigSetting(key string, label string) (emulator.ConfigSetting, error)
+	GetSettings() ([]emulator.ConfigSetting, error)
+	DeleteLabeledSetting(key string, label string) error
+	LockLabeledSetting(key string, label string) (emulator.ConfigSetting, error)
+	UnlockLabeledSetting(key string, label string) (emulator.ConfigSetting, error)
+	PutFeatureFlag(flag emulator.FeatureFlag) (emulator.FeatureFlag, error)
+	GetFeatureFlag(id string) (emulator.FeatureFlag, error)
+	SetFeatureFlagEnabled(id string, enabled bool) (emulator.FeatureFlag, error)
+	Reset() error
+}
+
+// An Emulator is an App Configuration store served on a local port
+type Emulator struct {
+	server     *httptest.Server
+	store      configStore
+	closer     func()
+	closeOnce  sync.Once
+	credential emulator.Credential
+}
+
+// Start starts an emulator configured by @param opts. It must be closed
+// when no longer needed.
+func Start(opts Options) (*Emulator, error) {
+	credential, err := newCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	store, closer, err := emulator.NewPersistentConfigStore(emulator.MakeInMemoryCloverFactory())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open store")
+	}
+
+	emu := &Emulator{store: store, closer: closer, credential: credential}
+	for _, setting := range opts.Settings {
+		err = emu.Put(setting)
+		if err != nil {
+			closer()
+			return nil, err
+		}
+	}
+
+	serverOpts := []emulator.RestServerOption{}
+	if opts.RequireHmac {
+		serverOpts = append(serverOpts, emulator.WithHmacAuth([]emulator.Credential{credential}))
+	}
+	if opts.ReadOnly {
+		serverOpts = append(serverOpts, emulator.WithReadOnly())
+	}
+	if len(opts.ApiVersions) > 0 {
+		serverOpts = append(serverOpts, emulator.WithApiVersions(opts.ApiVersions))
+	}
+
+	quietGin.Do(func() {
+		// Don't log the routes of every emulator a test suite starts
+		if gin.Mode() == gin.DebugMode {
+			gin.SetMode(gin.TestMode)
+		}
+	})
+	emu.server = httptest.NewServer(emulator.SetupRestServer(store, serverOpts...))
+
+	return emu, nil
+}
+
+// New starts an emulator configured by @param opts, that is closed when
+// @param t and its subtests complete. The test fails if it can't start.
+func New(t testing.TB, opts Options) *Emulator {
+	t.Helper()
+
+	emu, err := Start(opts)
+	if err != nil {
+		t.Fatalf("failed to start the App Configuration emulator: %v", err)
+	}
+	t.Cleanup(emu.Close)
+
+	return emu
+}
+
+// newCredential returns a random access key
+func newCredential() (emulator.Credential, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	for _, b := range [][]byte{id, secret} {
+		_, err := rand.Read(b)
+		if err != nil {
+			return emulator.Credential{}, errors.Wrap(err, "failed to generate a credential")
+		}
+	}
+
+	return emulator.Credential{
+		Id:     "aacemu-" + hex.EncodeToString(id),
+		Secret: base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+// Close stops serving, and discards the store
+func (emu *Emulator) Close() {
+	emu.closeOnce.Do(func() {
+		emu.server.Close()
+		emu.closer()
+	})
+}
+
+// Endpoint is the base URL the emulator serves the App Configuration API on
+func (emu *Emulator) Endpoint() string {
+	return emu.server.URL
+}
+
+// Credential returns the id and secret of the emulator's access key
+func (emu *Emulator) Credential() (string, string) {
+	return emu.credential.Id, emu.credential.Secret
+}
+
+// ConnectionString is the connection string of the emulator, as for a real store
+func (emu *Emulator) ConnectionString() string {
+	return fmt.Sprintf("Endpoint=%s;Id=%s;Secret=%s", emu.Endpoint(), emu.credential.Id, emu.credential.Secret)
+}
+
+// Set stores @param value as the latest version of @param key, with no label
+func (emu *Emulator) Set(key string, value string) error {
+	return emu.Put(Setting{Key: key, Value: value})
+}
+
+// Put stores @param setting as the latest version of its key and label,
+// and locks or unlocks it
+func (emu *Emulator) Put(setting Setting) error {
+	current, err := emu.store.GetLabeledConfigSetting(setting.Key, setting.Label)
+	if err == nil && current.Locked {
+		_, err = emu.store.UnlockLabeledSetting(setting.Key, setting.Label)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = emu.store.UpdateLabeledSetting(setting.Key, setting.Label, setting.Value, emulator.SettingAttributes{
+		ContentType: setting.ContentType,
+		Tags:        setting.Tags,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to store %s", setting.Key)
+	}
+
+	if setting.Locked {
+		_, err = emu.store.LockLabeledSetting(setting.Key, setting.Label)
+	}
+	return err
+}
+
+// Get returns the latest version of the setting identified by @param key
+// and @param label
+func (emu *Emulator) Get(key string, label string) (Setting, error) {
+	setting, err := emu.store.GetLabeledConfigSetting(key, label)
+	if err != nil {
+		return Setting{}, err
+	}
+
+	return toSetting(setting)
+}
+
+// Settings returns the latest version of every setting
+func (emu *Emulator) Settings() ([]Setting, error) {
+	stored, err := emu.store.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make([]Setting, 0, len(stored))
+	for _, s := range stored {
+		setting, err := toSetting(s)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+
+	return settings, nil
+}
+
+// Delete deletes the setting identified by @param key and @param label
+func (emu *Emulator) Delete(key string, label string) error {
+	return emu.store.DeleteLabeledSetting(key, label)
+}
+
+// PutFeatureFlag stores @param flag as the latest version of its key
+func (emu *Emulator) PutFeatureFlag(flag FeatureFlag) error {
+	_, err := emu.store.PutFeatureFlag(flag)
+	return err
+}
+
+// SetFeatureFlag stores a feature flag @param id, with no conditions,
+// that is @param enabled
+func (emu *Emulator) SetFeatureFlag(id string, enabled bool) error {
+	_, err := emu.store.GetFeatureFlag(id)
+	if err == nil {
+		_, err = emu.store.SetFeatureFlagEnabled(id, enabled)
+		return err
+	}
+
+	return emu.PutFeatureFlag(FeatureFlag{Id: id, Enabled: &enabled})
+}
+
+// FeatureFlag returns the latest version of the feature flag @param id
+func (emu *Emulator) FeatureFlag(id string) (FeatureFlag, error) {
+	return emu.store.GetFeatureFlag(id)
+}
+
+// Reset empties the store: settings, snapshots and secrets
+func (emu *Emulator) Reset() error {
+	return emu.store.Reset()
+}
+
+func toSetting(setting emulator.ConfigSetting) (Setting, error) {
+	latest, err := setting.GetLatest()
+	if err != nil {
+		return Setting{}, err
+	}
+
+	return Setting{
+		Key:         setting.Key,
+		Label:       setting.Label,
+		Value:       latest.Value,
+		ContentType: latest.ContentType,
+		Tags:        maps.Clone(latest.Tags),
+		Locked:      setting.Locked,
+	}, nil
+}
+
+// RequireValue fails @param t unless the setting identified by @param key
+// and @param label has @param value
+func (emu *Emulator) RequireValue(t testing.TB, key string, label string, value string) {
+	t.Helper()
+
+	setting, err := emu.Get(key, label)
+	if err != nil {
+		t.Fatalf("setting %s (label '%s'): %v", key, label, err)
+	}
+	if setting.Value != value {
+		t.Fatalf("setting %s (label '%s') is %q, expected %q", key, label, setting.Value, value)
+	}
+}
+
+// RequireAbsent fails @param t if the setting identified by @param key and
+// @param label exists
+func (emu *Emulator) RequireAbsent(t testing.TB, key string, label string) {
+	t.Helper()
+
+	setting, err := emu.Get(key, label)
+	if err == nil {
+		t.Fatalf("setting %s (label '%s') exists, with value %q", key, label, setting.Value)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("setting %s (label '%s'): %v", key, label, err)
+	}
+}
+
+// RequireFeatureFlag fails @param t unless the feature flag @param id is
+// @param enabled
+func (emu *Emulator) RequireFeatureFlag(t testing.TB, id string, enabled bool) {
+	t.Helper()
+
+	flag, err := emu.FeatureFlag(id)
+	if err != nil {
+		t.Fatalf("feature flag %s: %v", id, err)
+	}
+	if flag.IsEnabled() != enabled {
+		t.Fatalf("feature flag %s is enabled=%t, expected %t", id, flag.IsEnabled(), enabled)
+	}
+}
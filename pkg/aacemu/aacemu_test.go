@@ -0,0 +1,150 @@
+package aacemu
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	emulator "urbanwizardry.com/aac-emulator/internal"
+)
+
+// TestStartParallel comes first, so that the first emulators start
+// concurrently, as in a suite of parallel tests. They start from goroutines,
+// as parallel tests run one at a time with -parallel 1.
+func TestStartParallel(t *testing.T) {
+	names := []string{"first", "second", "third", "fourth"}
+	emus := make([]*Emulator, len(names))
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			emus[i], errs[i] = Start(Options{Settings: []Setting{{Key: "Name", Value: name}}})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, name := range names {
+		require.NoError(t, errs[i])
+		t.Cleanup(emus[i].Close)
+
+		rs, err := http.Get(emus[i].Endpoint() + "/kv/Name?api-version=2023-10-01")
+		require.NoError(t, err)
+		rs.Body.Close()
+		require.Equal(t, http.StatusOK, rs.StatusCode)
+		emus[i].RequireValue(t, "Name", NullLabel, name)
+	}
+}
+
+func TestEmulator(t *testing.T) {
+	get := func(t *testing.T, emu *Emulator, path string, sign bool) *http.Response {
+		rq, err := http.NewRequest(http.MethodGet, emu.Endpoint()+path, nil)
+		require.NoError(t, err)
+		if sign {
+			id, secret := emu.Credential()
+			require.NoError(t, emulator.SignHmacRequest(rq, nil, emulator.Credential{Id: id, Secret: secret}))
+		}
+
+		rs, err := http.DefaultClient.Do(rq)
+		require.NoError(t, err)
+		t.Cleanup(func() { rs.Body.Close() })
+		return rs
+	}
+
+	t.Run("Seeded settings are served", func(t *testing.T) {
+		emu := New(t, Options{Settings: []Setting{
+			{Key: "App:Name", Value: "demo"},
+			{Key: "App:Name", Label: "prod", Value: "live", ContentType: "text/plain", Tags: map[string]string{"team": "a"}, Locked: true},
+		}})
+
+		rs := get(t, emu, "/kv/App:Name?label=prod&api-version=2023-10-01", false)
+		require.Equal(t, http.StatusOK, rs.StatusCode)
+		var kv map[string]interface{}
+		body, err := io.ReadAll(rs.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &kv))
+		require.Equal(t, "live", kv["value"])
+		require.Equal(t, true, kv["locked"])
+
+		emu.RequireValue(t, "App:Name", NullLabel, "demo")
+		setting, err := emu.Get("App:Name", "prod")
+		require.NoError(t, err)
+		require.Equal(t, Setting{Key: "App:Name", Label: "prod", Value: "live", ContentType: "text/plain", Tags: map[string]string{"team": "a"}, Locked: true}, setting)
+
+		// Locked settings can still be changed through the Emulator
+		require.NoError(t, emu.Put(Setting{Key: "App:Name", Label: "prod", Value: "changed"}))
+		setting, err = emu.Get("App:Name", "prod")
+		require.NoError(t, err)
+		require.False(t, setting.Locked)
+		require.Equal(t, "changed", setting.Value)
+	})
+
+	t.Run("Emulators are isolated", func(t *testing.T) {
+		first := New(t, Options{})
+		second := New(t, Options{})
+		require.NotEqual(t, first.Endpoint(), second.Endpoint())
+
+		require.NoError(t, first.Set("Key", "value"))
+		second.RequireAbsent(t, "Key", NullLabel)
+
+		require.NoError(t, first.Delete("Key", NullLabel))
+		first.RequireAbsent(t, "Key", NullLabel)
+	})
+
+	t.Run("The connection string authenticates", func(t *testing.T) {
+		emu := New(t, Options{RequireHmac: true})
+
+		endpoint, credential, err := emulator.ParseConnectionString(emu.ConnectionString())
+		require.NoError(t, err)
+		require.Equal(t, emu.Endpoint(), endpoint)
+		id, secret := emu.Credential()
+		require.Equal(t, emulator.Credential{Id: id, Secret: secret}, credential)
+
+		require.Equal(t, http.StatusUnauthorized, get(t, emu, "/kv?api-version=2023-10-01", false).StatusCode)
+		require.Equal(t, http.StatusOK, get(t, emu, "/kv?api-version=2023-10-01", true).StatusCode)
+	})
+
+	t.Run("Feature flags are seeded and asserted", func(t *testing.T) {
+		emu := New(t, Options{})
+
+		require.NoError(t, emu.SetFeatureFlag("Beta", true))
+		emu.RequireFeatureFlag(t, "Beta", true)
+		require.NoError(t, emu.SetFeatureFlag("Beta", false))
+		emu.RequireFeatureFlag(t, "Beta", false)
+
+		settings, err := emu.Settings()
+		require.NoError(t, err)
+		require.Len(t, settings, 1)
+		require.Equal(t, emulator.FeatureFlagKey("Beta"), settings[0].Key)
+
+		require.NoError(t, emu.Reset())
+		settings, err = emu.Settings()
+		require.NoError(t, err)
+		require.Empty(t, settings)
+	})
+
+	t.Run("Closed emulators stop serving", func(t *testing.T) {
+		emu, err := Start(Options{})
+		require.NoError(t, err)
+		endpoint := emu.Endpoint()
+		emu.Close()
+		emu.Close()
+
+		_, err = http.Get(endpoint + "/kv?api-version=2023-10-01")
+		require.Error(t, err)
+	})
+
+	t.Run("Invalid seed settings fail to start", func(t *testing.T) {
+		_, err := Start(Options{Settings: []Setting{{Key: ".."}}})
+		require.Error(t, err)
+	})
+}